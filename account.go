@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const accountDir = ".account"
+
+// runAccountBackup implements the account-backup subcommand, which exports the
+// authenticated user's profile, settings, keys and avatar.
+func runAccountBackup(args []string) error {
+	fs := flag.NewFlagSet("account-backup", flag.ExitOnError)
+	fs.Parse(args)
+
+	config, err := loadConfig("config.env")
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	giteaHost, err := parseHost(config["GITEA_HOST"])
+	if err != nil {
+		return err
+	}
+	giteaAccessToken := config["GITEA_ACCESS_TOKEN"]
+
+	closeAudit, err := openAuditLog(config["TARGET_DIR"])
+	if err != nil {
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	defer closeAudit()
+
+	var profile map[string]interface{}
+	if err := getJSON(giteaHost+userEndpoint, giteaAccessToken, &profile); err != nil {
+		return fmt.Errorf("fetching profile: %w", err)
+	}
+	login, _ := profile["login"].(string)
+	dir := filepath.Join(config["TARGET_DIR"], accountDir, login)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	if err := writeJSONFile(filepath.Join(dir, "profile.json"), profile); err != nil {
+		return err
+	}
+
+	exports := []struct {
+		endpoint string
+		file     string
+	}{
+		{"/api/v1/user/settings", "settings.json"},
+		{"/api/v1/user/emails", "emails.json"},
+		{"/api/v1/user/keys", "ssh_keys.json"},
+		{"/api/v1/user/gpg_keys", "gpg_keys.json"},
+	}
+	for _, export := range exports {
+		var data interface{}
+		if err := getJSON(giteaHost+export.endpoint, giteaAccessToken, &data); err != nil {
+			fmt.Printf("Warning: could not export %s: %v\n", export.endpoint, err)
+			continue
+		}
+		if err := writeJSONFile(filepath.Join(dir, export.file), data); err != nil {
+			return err
+		}
+	}
+
+	if avatarURL, _ := profile["avatar_url"].(string); avatarURL != "" {
+		if err := downloadAvatar(avatarURL, giteaAccessToken, filepath.Join(dir, "avatar")); err != nil {
+			fmt.Printf("Warning: could not download avatar: %v\n", err)
+		}
+	}
+
+	fmt.Printf("Account %s backed up to %s\n", login, dir)
+	return nil
+}
+
+// downloadAvatar saves the avatar next to the other exports, picking the file
+// extension from the response's content type.
+func downloadAvatar(avatarURL, giteaAccessToken, destWithoutExt string) error {
+	req, err := http.NewRequest("GET", avatarURL, nil)
+	if err != nil {
+		return err
+	}
+	setAuth(req, giteaAccessToken)
+	response, err := doWithRetry(downloadClient, req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return fmt.Errorf("download failed with HTTP status code: %d", response.StatusCode)
+	}
+
+	ext := ".png"
+	if exts, _ := mime.ExtensionsByType(response.Header.Get("Content-Type")); len(exts) > 0 {
+		ext = exts[0]
+	}
+	out, err := os.Create(destWithoutExt + ext)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, response.Body)
+	return err
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+)
+
+// feedActivity is the part of an entry of a repository's activity feed that
+// change detection needs.
+type feedActivity struct {
+	Created time.Time `json:"created"`
+}
+
+// latestActivity returns the time of the most recent change to repo that the
+// server knows of, by the server's clock: its newest activity feed entry, such
+// as a push, a new tag or a deleted branch, or else when the repository was
+// last updated. Servers older than Gitea 1.20 have no activity feeds.
+func latestActivity(giteaHost, giteaAccessToken string, server serverInfo, repo Repository) time.Time {
+	latest := repo.UpdatedAt
+	if !server.atLeast(activityFeedsVersion) {
+		return latest
+	}
+	var feed []feedActivity
+	if err := getJSON(repoAPIURL(giteaHost, repo)+"/activities/feeds?limit=1", giteaAccessToken, &feed); err != nil {
+		return latest
+	}
+	if len(feed) > 0 && feed[0].Created.After(latest) {
+		latest = feed[0].Created
+	}
+	return latest
+}
+
+// lastActivity returns the latest activity of repository fullName recorded at
+// its last successful clone or sync.
+func (q *workQueue) lastActivity(fullName string) (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	t, ok := q.state.Activity[fullName]
+	return t, ok
+}
+
+// recordActivity records the latest activity of repository fullName after it
+// was cloned or synced.
+func (q *workQueue) recordActivity(fullName string, t time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.state.Activity == nil {
+		q.state.Activity = make(map[string]time.Time)
+	}
+	q.state.Activity[fullName] = t
+	q.dirty = true
+}
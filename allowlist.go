@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// allowedRepo is an entry of the --repos file.
+type allowedRepo struct {
+	fullName string
+	// ref is the branch, tag or commit to check out, empty for the default
+	// branch.
+	ref string
+	// line is where the entry is, for the error messages.
+	line int
+}
+
+// loadAllowlist reads the repositories to manage from a YAML file of
+// "owner/name: ref" lines, where the ref is optional, e.g.
+//
+//	alice/notes:
+//	infra/deploy: v2.3.0
+func loadAllowlist(file string) ([]allowedRepo, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var entries []allowedRepo
+	for i, line := range strings.Split(string(data), "\n") {
+		line = stripYAMLComment(line)
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		key, value, ok := splitYAMLPair(line)
+		owner, name, slash := strings.Cut(key, "/")
+		if !ok || !slash || owner == "" || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("%s:%d: expected \"owner/name: ref\", the ref being optional", file, i+1)
+		}
+		if seen[strings.ToLower(key)] {
+			return nil, fmt.Errorf("%s:%d: %s is listed twice", file, i+1, key)
+		}
+		seen[strings.ToLower(key)] = true
+		entries = append(entries, allowedRepo{fullName: key, ref: value, line: i + 1})
+	}
+	return entries, nil
+}
+
+// fetchAllowedRepositories looks up every repository of the --repos file on
+// the server. Repositories the server does not have, or does not show the
+// token, are all reported at once.
+func fetchAllowedRepositories(ctx context.Context, giteaHost, giteaAccessToken, file string, entries []allowedRepo) ([]Repository, error) {
+	var repos []Repository
+	var missing []string
+	for _, entry := range entries {
+		var repo Repository
+		if err := getJSONContext(ctx, repoAPIURL(giteaHost, Repository{FullName: entry.fullName}), giteaAccessToken, &repo); isNotFound(err) {
+			missing = append(missing, fmt.Sprintf("%s:%d: %s", file, entry.line, entry.fullName))
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("fetching %s: %w", entry.fullName, err)
+		}
+		repo.Ref = entry.ref
+		repos = append(repos, repo)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("not found on the server or not visible to the token:\n  %s", strings.Join(missing, "\n  "))
+	}
+	return repos, nil
+}
+
+// pinnedKey is the git config key that records in a clone the tag or commit
+// it is pinned to, so that health does not report its detached HEAD.
+const pinnedKey = "clonegitea.pinned"
+
+// checkoutRef checks out ref in the clone in dir. A branch is checked out
+// tracking the server's branch, so that --sync keeps it up to date; a tag or
+// a commit is checked out with a detached HEAD, which --sync leaves alone.
+func checkoutRef(ctx context.Context, dir, ref string) error {
+	remoteBranch := "refs/remotes/" + remoteName + "/" + ref
+	if _, err := gitOutput(ctx, dir, "rev-parse", "--verify", "--quiet", remoteBranch); err == nil {
+		if current, _ := gitOutput(ctx, dir, "symbolic-ref", "--quiet", "--short", "HEAD"); current == ref {
+			return nil
+		}
+		if _, err := gitOutput(ctx, dir, "rev-parse", "--verify", "--quiet", "refs/heads/"+ref); err == nil {
+			_, err := gitOutput(ctx, dir, "checkout", "--quiet", ref)
+			return err
+		}
+		_, err := gitOutput(ctx, dir, "checkout", "--quiet", "--track", remoteName+"/"+ref)
+		return err
+	}
+	commit := ref
+	if _, err := gitOutput(ctx, dir, "rev-parse", "--verify", "--quiet", ref+"^{commit}"); err != nil {
+		// A commit that no branch or tag reaches is asked for directly.
+		args := append(append([]string{"fetch"}, gitTransportArgs()...), remoteName, ref)
+		_, err := gitOutput(ctx, dir, args...)
+		audit("fetch", dir, ref, err)
+		if err != nil {
+			return fmt.Errorf("%s is no branch, tag or commit of the repository: %w", ref, err)
+		}
+		commit = "FETCH_HEAD"
+	}
+	if _, err := gitOutput(ctx, dir, "-c", "advice.detachedHead=false", "checkout", "--quiet", "--detach", commit); err != nil {
+		return err
+	}
+	_, err := gitOutput(ctx, dir, "config", pinnedKey, ref)
+	return err
+}
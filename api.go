@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxAPIRetries  = 5
+	initialBackoff = time.Second
+	maxBackoff     = time.Minute
+	listPageSize   = 50
+	// listConcurrency bounds the pages of a repository listing fetched at once.
+	listConcurrency = 4
+)
+
+// doWithRetry sends req and retries it when the server answers 429 or a
+// transient 5xx, honoring Retry-After when present and otherwise backing off
+// exponentially. A POST or PATCH may have taken effect before a 5xx, so those
+// are only retried on 429.
+func doWithRetry(client *http.Client, req *http.Request) (*http.Response, error) {
+	backoff := initialBackoff
+	for attempt := 0; ; attempt++ {
+		response, err := client.Do(req)
+		if err != nil {
+			audit("api", req.Method+" "+req.URL.String(), "", err)
+			return nil, err
+		}
+		audit("api", req.Method+" "+req.URL.String(), response.Status, nil)
+		retry := isRetryableStatus(response.StatusCode)
+		if req.Method == http.MethodPost || req.Method == http.MethodPatch {
+			retry = response.StatusCode == http.StatusTooManyRequests
+		}
+		if !retry || attempt >= maxAPIRetries {
+			return response, nil
+		}
+		response.Body.Close()
+
+		wait := retryAfter(response.Header.Get("Retry-After"))
+		if wait <= 0 {
+			wait = backoff
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+		if wait > maxBackoff {
+			wait = maxBackoff
+		}
+		fmt.Printf("Server returned %d for %s, retrying in %s\n", response.StatusCode, req.URL, wait)
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// parseHost validates a Gitea host from the configuration and returns it without
+// a trailing slash, so API endpoints can be appended to it. The host may
+// include a subpath, e.g. https://example.com/gitea. A host without a scheme
+// is taken to be served over HTTPS, and the URL of the API is taken for that
+// of the server.
+func parseHost(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid Gitea host %q, expected [http(s)://]host[:port][/path]", raw)
+	}
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.Path = strings.TrimRight(strings.TrimSuffix(u.Path, "/api/v1"), "/")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// followHostRedirect asks giteaHost for the server version without the token
+// and without following redirects. When the server redirects the API, as from
+// http:// to https://, it warns and returns where the API is, so that later
+// requests do not send the token to the old address first.
+func followHostRedirect(giteaHost string) (string, error) {
+	client := &http.Client{
+		Transport: apiTransport,
+		Timeout:   apiClient.Timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	host := giteaHost
+	for redirects := 0; redirects < 10; redirects++ {
+		response, err := client.Get(host + versionEndpoint)
+		if err != nil {
+			// Reported by the requests that follow.
+			break
+		}
+		response.Body.Close()
+		location, err := response.Location()
+		if err != nil {
+			break
+		}
+		if !strings.HasSuffix(location.Path, versionEndpoint) {
+			return giteaHost, fmt.Errorf("%s redirects the API to %s, check GITEA_HOST", giteaHost, location)
+		}
+		location.Path = strings.TrimSuffix(location.Path, versionEndpoint)
+		location.RawQuery = ""
+		if host, err = parseHost(location.String()); err != nil {
+			return giteaHost, err
+		}
+	}
+	if host != giteaHost {
+		fmt.Printf("Warning: %s redirects to %s, set GITEA_HOST=%s to skip the redirect\n", giteaHost, host, host)
+	}
+	return host, nil
+}
+
+// setAuth authenticates req with the access token. Without a token the request
+// is sent anonymously and only sees public data.
+func setAuth(req *http.Request, giteaAccessToken string) {
+	if giteaAccessToken != "" {
+		req.Header.Add("Authorization", "token "+giteaAccessToken)
+	}
+}
+
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header given either in seconds or as an
+// HTTP date.
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+func getJSON(url, giteaAccessToken string, v interface{}) error {
+	return getJSONContext(context.Background(), url, giteaAccessToken, v)
+}
+
+// getJSONContext is getJSON canceled with ctx.
+func getJSONContext(ctx context.Context, url, giteaAccessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	setAuth(req, giteaAccessToken)
+	response, err := doWithRetry(apiClient, req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return &apiStatusError{response.StatusCode}
+	}
+
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+// apiStatusError is returned for an API response with an unexpected status.
+type apiStatusError struct {
+	code int
+}
+
+func (e *apiStatusError) Error() string {
+	return fmt.Sprintf("API request failed with HTTP status code: %d", e.code)
+}
+
+// isNotFound reports whether err is an API response 404 Not Found, as Gitea
+// answers for features that are disabled.
+func isNotFound(err error) bool {
+	var statusErr *apiStatusError
+	return errors.As(err, &statusErr) && statusErr.code == http.StatusNotFound
+}
+
+// isForbidden reports whether err is an API response 403 Forbidden, as Gitea
+// answers for settings only repository admins may see.
+func isForbidden(err error) bool {
+	var statusErr *apiStatusError
+	return errors.As(err, &statusErr) && statusErr.code == http.StatusForbidden
+}
+
+// fetchPage GETs a single page of a listing, serving it from the on-disk cache
+// when the server reports it unchanged. It also returns the X-Total-Count of
+// the listing, or -1 when the server does not send it.
+func fetchPage(ctx context.Context, url, giteaAccessToken string, useCache bool) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	setAuth(req, giteaAccessToken)
+
+	key := cacheKey(giteaAccessToken, url)
+	var cached *cachedResponse
+	if useCache {
+		if cached, _ = loadCachedResponse(key); cached != nil && cached.ETag != "" {
+			req.Header.Add("If-None-Match", cached.ETag)
+		}
+	}
+
+	response, err := doWithRetry(apiClient, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+
+	var body []byte
+	total := -1
+	switch {
+	case response.StatusCode == http.StatusNotModified && cached != nil:
+		body, total = cached.Body, cached.Total
+	case response.StatusCode == 200:
+		body, err = io.ReadAll(response.Body)
+		if err != nil {
+			return nil, 0, err
+		}
+		if n, err := strconv.Atoi(response.Header.Get("X-Total-Count")); err == nil {
+			total = n
+		}
+		if etag := response.Header.Get("ETag"); useCache && etag != "" {
+			if err := saveCachedResponse(key, url, etag, body, total); err != nil {
+				fmt.Printf("Warning: could not cache API response: %v\n", err)
+			}
+		}
+	default:
+		return nil, 0, fmt.Errorf("API request failed with HTTP status code: %d", response.StatusCode)
+	}
+	return body, total, nil
+}
+
+// fetchRepositoryPages fetches every page of a repository listing. The first
+// page reveals the total count, after which the remaining pages are fetched in
+// parallel. Listings without a usable X-Total-Count are paged sequentially
+// until an empty page.
+func fetchRepositoryPages(ctx context.Context, pageURL func(page int) string, giteaAccessToken string, useCache bool, decode func([]byte) ([]Repository, error)) ([]Repository, error) {
+	fetch := func(page int) ([]Repository, int, error) {
+		body, total, err := fetchPage(ctx, pageURL(page), giteaAccessToken, useCache)
+		if err != nil {
+			return nil, 0, err
+		}
+		repos, err := decode(body)
+		return repos, total, err
+	}
+
+	all, total, err := fetch(1)
+	if err != nil || len(all) == 0 {
+		return all, err
+	}
+
+	if total < len(all) {
+		for page := 2; ; page++ {
+			repos, _, err := fetch(page)
+			if err != nil {
+				return nil, err
+			}
+			if len(repos) == 0 {
+				return all, nil
+			}
+			all = append(all, repos...)
+		}
+	}
+
+	lastPage := (total + len(all) - 1) / len(all)
+	pages := make([][]Repository, lastPage+1)
+	errs := make([]error, lastPage+1)
+	sem := make(chan struct{}, listConcurrency)
+	var wg sync.WaitGroup
+	for page := 2; page <= lastPage; page++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(page int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			pages[page], _, errs[page] = fetch(page)
+		}(page)
+	}
+	wg.Wait()
+
+	for page := 2; page <= lastPage; page++ {
+		if errs[page] != nil {
+			return nil, errs[page]
+		}
+		all = append(all, pages[page]...)
+	}
+	return all, nil
+}
+
+// getAllPages GETs every page of a listing that returns a JSON array. It is
+// only for endpoints that take page and limit: one that ignores them returns
+// the same items for every page and is never done.
+func getAllPages[T any](url, giteaAccessToken string) ([]T, error) {
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	var all []T
+	for page := 1; ; page++ {
+		var items []T
+		if err := getJSON(fmt.Sprintf("%s%spage=%d&limit=%d", url, sep, page, listPageSize), giteaAccessToken, &items); err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			return all, nil
+		}
+		all = append(all, items...)
+	}
+}
+
+// sendJSON sends in as the JSON body of a method request to url and decodes
+// the response into out, if out is not nil.
+func sendJSON(method, url, giteaAccessToken string, in, out interface{}) error {
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	setAuth(req, giteaAccessToken)
+	response, err := doWithRetry(apiClient, req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(response.Body).Decode(&apiErr)
+		return fmt.Errorf("API request failed with HTTP status code: %d %s", response.StatusCode, apiErr.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseHost(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{raw: "example.com", want: "https://example.com"},
+		{raw: " Example.COM ", want: "https://example.com"},
+		{raw: "http://h:3000/gitea/", want: "http://h:3000/gitea"},
+		{raw: "https://h/api/v1", want: "https://h"},
+		{raw: "https://h/gitea/api/v1/", want: "https://h/gitea"},
+		{raw: "https://u:p@h", wantErr: true},
+		{raw: "ftp://h", wantErr: true},
+		{raw: "", wantErr: true},
+		{raw: "h?q", wantErr: true},
+		{raw: "https://h/?q", wantErr: true},
+		{raw: "https://h#top", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseHost(tt.raw)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseHost(%q) = %q, want an error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseHost(%q) returned error: %v", tt.raw, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseHost(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+// TestSubpathRequests checks that API requests to an instance under a
+// subpath keep the subpath, also when GITEA_HOST ends with a slash.
+func TestSubpathRequests(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(`{"version":"1.21.0"}`))
+	}))
+	defer server.Close()
+
+	host, err := parseHost(server.URL + "/gitea/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var version struct {
+		Version string `json:"version"`
+	}
+	if err := getJSON(host+"/api/v1/version", "", &version); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/gitea/api/v1/version" || version.Version != "1.21.0" {
+		t.Errorf("requested %q and got version %q, want /gitea/api/v1/version and 1.21.0", path, version.Version)
+	}
+}
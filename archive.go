@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	formatGit   = "git"
+	formatTarGz = "tar.gz"
+)
+
+// archivePath is where the snapshot of repo is stored in tar.gz mode.
+func archivePath(repo Repository) string {
+	return repoDir(repo) + "." + formatTarGz
+}
+
+// downloadArchive saves a snapshot of repo's default branch, without history,
+// through the archive API.
+func downloadArchive(giteaHost, giteaAccessToken string, repo Repository) error {
+	dest := archivePath(repo)
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return err
+	}
+	ref := strings.ReplaceAll(url.PathEscape(repo.DefaultBranch), "%2F", "/")
+	return downloadFile(repoAPIURL(giteaHost, repo)+"/archive/"+ref+"."+formatTarGz, giteaAccessToken, dest, "")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	artifactsDir = ".artifacts"
+	// artifactsVersion added the API listing and downloading Actions
+	// artifacts; workflowRunsVersion replaced the tasks listing with runs.
+	artifactsVersion    = "1.23.0"
+	workflowRunsVersion = "1.24.0"
+	// workflowRunsKept is how many of the most recent workflow runs are
+	// exported.
+	workflowRunsKept = 100
+	actionsPageSize  = 50
+)
+
+type actionArtifact struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size_in_bytes"`
+	Expired     bool      `json:"expired"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	WorkflowRun struct {
+		ID      int64  `json:"id"`
+		HeadSHA string `json:"head_sha"`
+	} `json:"workflow_run"`
+	// File is the name of the downloaded archive in .artifacts.
+	File string `json:"file,omitempty"`
+}
+
+type workflowRun struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	DisplayTitle string    `json:"display_title"`
+	Event        string    `json:"event"`
+	HeadBranch   string    `json:"head_branch"`
+	HeadSHA      string    `json:"head_sha"`
+	RunNumber    int64     `json:"run_number"`
+	Status       string    `json:"status"`
+	Conclusion   string    `json:"conclusion,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	URL          string    `json:"url"`
+}
+
+// exportArtifacts downloads the Actions artifacts of repo that have not
+// expired into owner/name/.artifacts/<id>-<name>.zip and writes their list to
+// artifacts.json and the most recent workflow runs to runs.json. Archives
+// downloaded by earlier runs are kept after the server lets them expire.
+func exportArtifacts(giteaHost, giteaAccessToken string, server serverInfo, repo Repository) error {
+	base := repoAPIURL(giteaHost, repo)
+
+	var artifacts []actionArtifact
+	for page := 1; ; page++ {
+		var response struct {
+			Artifacts []actionArtifact `json:"artifacts"`
+		}
+		err := getJSON(fmt.Sprintf("%s/actions/artifacts?page=%d&limit=%d", base, page, actionsPageSize), giteaAccessToken, &response)
+		if isNotFound(err) {
+			// Actions are disabled for the repository.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		artifacts = append(artifacts, response.Artifacts...)
+		if len(response.Artifacts) < actionsPageSize {
+			break
+		}
+	}
+
+	runsEndpoint := "/actions/tasks"
+	if server.atLeast(workflowRunsVersion) {
+		runsEndpoint = "/actions/runs"
+	}
+	var runs []workflowRun
+	for page := 1; len(runs) < workflowRunsKept; page++ {
+		var response struct {
+			Runs []workflowRun `json:"workflow_runs"`
+		}
+		err := getJSON(fmt.Sprintf("%s%s?page=%d&limit=%d", base, runsEndpoint, page, actionsPageSize), giteaAccessToken, &response)
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("fetching workflow runs: %w", err)
+		}
+		runs = append(runs, response.Runs...)
+		if len(response.Runs) < actionsPageSize {
+			break
+		}
+	}
+	if len(runs) > workflowRunsKept {
+		runs = runs[:workflowRunsKept]
+	}
+	if len(artifacts) == 0 && len(runs) == 0 {
+		return nil
+	}
+
+	dir := filepath.Join(repoDir(repo), artifactsDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	if err := excludeFromGit(repoDir(repo), "/"+artifactsDir+"/"); err != nil {
+		return err
+	}
+
+	// Artifacts listed before but expired since are kept in the list, as
+	// their archives are.
+	var previous []actionArtifact
+	if err := readJSONFile(filepath.Join(dir, "artifacts.json"), &previous); err != nil && err != errNotFound {
+		return err
+	}
+	listed := make(map[int64]bool, len(artifacts))
+	for _, artifact := range artifacts {
+		listed[artifact.ID] = true
+	}
+	for _, artifact := range previous {
+		if !listed[artifact.ID] && artifact.File != "" {
+			artifact.Expired = true
+			artifacts = append(artifacts, artifact)
+		}
+	}
+
+	for i := range artifacts {
+		artifact := &artifacts[i]
+		if artifact.File != "" {
+			continue
+		}
+		file := strconv.FormatInt(artifact.ID, 10) + "-" + sanitizeName(artifact.Name) + ".zip"
+		if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+			artifact.File = file
+			continue
+		}
+		if artifact.Expired {
+			continue
+		}
+		if err := downloadFile(fmt.Sprintf("%s/actions/artifacts/%d/zip", base, artifact.ID), giteaAccessToken, filepath.Join(dir, file), ""); err != nil {
+			return fmt.Errorf("downloading artifact %s: %w", artifact.Name, err)
+		}
+		artifact.File = file
+	}
+
+	if err := writeJSONFile(filepath.Join(dir, "runs.json"), runs); err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(dir, "artifacts.json"), artifacts)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// askpassTokenEnv carries the access token to the askpass helper. Git passes
+// its environment on to the helper, so the token never appears in argv or in
+// a clone's .git/config.
+const askpassTokenEnv = "CLONEGITEA_ASKPASS_TOKEN"
+
+// askpassHostEnv carries GITEA_HOST to the askpass helper, which only gives
+// the token to that server.
+const askpassHostEnv = "CLONEGITEA_ASKPASS_HOST"
+
+// askpassUsername is sent along with the token. Gitea ignores the user name
+// when the password is an access token.
+const askpassUsername = "oauth2"
+
+// askpassEnv is the environment git commands run with to authenticate with
+// the access token, nil unless configureAskpass was called. It is only set
+// on the git commands, so that other programs the tool starts never see the
+// token.
+var askpassEnv []string
+
+// runAskpass answers a credential prompt of git when this executable was
+// started as its GIT_ASKPASS helper, and reports whether it was. Prompts for
+// any other server than GITEA_HOST, as for a submodule, an LFS server or
+// after a redirect, get an empty answer.
+func runAskpass() bool {
+	token, ok := os.LookupEnv(askpassTokenEnv)
+	if !ok || len(os.Args) != 2 {
+		return false
+	}
+	prompt := os.Args[1]
+	var answer string
+	switch {
+	case strings.HasPrefix(prompt, "Username for "):
+		answer = askpassUsername
+	case strings.HasPrefix(prompt, "Password for "):
+		answer = token
+	default:
+		return false
+	}
+	if !promptForHost(prompt, os.Getenv(askpassHostEnv)) {
+		answer = ""
+	}
+	fmt.Println(answer)
+	return true
+}
+
+// promptForHost reports whether a prompt of git such as
+// "Password for 'https://oauth2@gitea.example.com': " asks for credentials of
+// the server at giteaHost: same scheme, host and port.
+func promptForHost(prompt, giteaHost string) bool {
+	_, quoted, ok := strings.Cut(prompt, " for ")
+	if !ok {
+		return false
+	}
+	raw := strings.Trim(strings.TrimSuffix(strings.TrimSpace(quoted), ":"), "'")
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host, err := url.Parse(giteaHost)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Scheme, host.Scheme) && strings.EqualFold(u.Hostname(), host.Hostname()) && urlPort(u) == urlPort(host)
+}
+
+// urlPort returns the port of u, the scheme's default one if it has none.
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if strings.EqualFold(u.Scheme, "http") {
+		return "80"
+	}
+	return "443"
+}
+
+// configureAskpass makes git clone and fetch from giteaHost over HTTP(S) with
+// the token: git asks this executable for credentials, and credential helpers
+// are disabled for the program's git commands, so they neither answer with
+// other credentials nor store the token.
+func configureAskpass(giteaHost, giteaAccessToken string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locating the askpass helper: %w", err)
+	}
+	askpassEnv = []string{
+		askpassTokenEnv + "=" + giteaAccessToken,
+		askpassHostEnv + "=" + giteaHost,
+		"GIT_ASKPASS=" + executable,
+		"GIT_TERMINAL_PROMPT=0",
+	}
+	return nil
+}
+
+// gitCommand returns the git command with args, set up to authenticate with
+// the access token when configureAskpass was called.
+func gitCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	if askpassEnv != nil {
+		// The git config of the environment is counted when the command is
+		// made, so that it adds to what the process has set, e.g. by
+		// --resolve.
+		env := append(os.Environ(), askpassEnv...)
+		cmd.Env = append(env, gitConfigEnv("credential.helper", "")...)
+	}
+	return cmd
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const auditLogFile = ".clonegitea/audit.log"
+
+// auditEntry is one line of the audit log.
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Target string    `json:"target"`
+	Detail string    `json:"detail,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+var auditLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// openAuditLog opens the append-only audit log below root. Actions recorded
+// before it is opened, e.g. by subcommands that do not use it, are dropped.
+func openAuditLog(root string) (func(), error) {
+	path := filepath.Join(root, auditLogFile)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	auditLog.mu.Lock()
+	auditLog.f = f
+	auditLog.mu.Unlock()
+	return func() {
+		auditLog.mu.Lock()
+		defer auditLog.mu.Unlock()
+		auditLog.f.Close()
+		auditLog.f = nil
+	}, nil
+}
+
+// audit appends an action, such as an API call, clone, fetch, prune or hook
+// execution, to the audit log.
+func audit(action, target, detail string, err error) {
+	entry := auditEntry{Time: time.Now().UTC(), Action: action, Target: redactURL(target), Detail: detail}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	line, jsonErr := json.Marshal(entry)
+	if jsonErr != nil {
+		return
+	}
+
+	auditLog.mu.Lock()
+	defer auditLog.mu.Unlock()
+	if auditLog.f != nil {
+		auditLog.f.Write(append(line, '\n'))
+	}
+}
+
+// redactURL strips credentials embedded in a URL so they never reach the log.
+func redactURL(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.User == nil {
+		return target
+	}
+	u.User = nil
+	return u.String()
+}
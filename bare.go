@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// bareSuffix ends the directory of a --bare repository, as cgit and Gitea
+// expect.
+const bareSuffix = ".git"
+
+// gitCloneBare clones repo as a bare repository whose branches are local
+// branches, and configures fetch to keep updating them, so the directory can
+// be served as it is.
+func gitCloneBare(ctx context.Context, cloneURL, dir string, extraArgs ...string) error {
+	if err := gitClone(ctx, cloneURL, dir, append([]string{"--bare"}, extraArgs...)...); err != nil {
+		return err
+	}
+	_, err := gitOutput(ctx, dir, "config", "remote."+remoteName+".fetch", "+refs/heads/*:refs/heads/*")
+	return err
+}
+
+// isBareRepo reports whether dir is a bare repository rather than a clone
+// with a working tree.
+func isBareRepo(dir string) bool {
+	if !strings.HasSuffix(dir, bareSuffix) {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(dir, "objects"))
+	return err == nil
+}
+
+// isClone reports whether dir is a clone, with a working tree or bare.
+func isClone(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		return true
+	}
+	return isBareRepo(dir)
+}
+
+// branchRefs is where the clone in dir keeps the server's branches: local
+// branches in a bare repository, remote branches otherwise.
+func branchRefs(dir string) string {
+	if isBareRepo(dir) {
+		return "refs/heads/"
+	}
+	return "refs/remotes/" + remoteName + "/"
+}
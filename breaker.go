@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// breakerWindow is how many of the most recent repositories the circuit
+	// breaker looks at.
+	breakerWindow = 10
+	// breakerChecks is how often the breaker tests the server, breakerRetry
+	// apart, before it gives up on the run.
+	breakerChecks = 10
+	breakerRetry  = 30 * time.Second
+)
+
+// circuitBreaker pauses the run when too many of the recent repositories
+// failed for network or authentication reasons, which usually means the
+// server or the token, not the repositories, are the problem. It then tests
+// the server and the token until they work again, and resumes the run, or
+// aborts it with a diagnosis.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold float64
+	recent    []bool
+	tripped   bool
+	// lastURL is the clone URL of the latest repository that failed, to
+	// test git's access to the server with.
+	lastURL string
+
+	giteaHost        string
+	giteaAccessToken string
+	// abortRun cancels the run with a reason.
+	abortRun func(reason string)
+}
+
+func newCircuitBreaker(threshold float64, giteaHost, giteaAccessToken string, abortRun func(string)) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: threshold, giteaHost: giteaHost, giteaAccessToken: giteaAccessToken, abortRun: abortRun}
+}
+
+// observe records the outcome of the repository cloned from cloneURL.
+func (b *circuitBreaker) observe(err error, cloneURL string) {
+	if b == nil {
+		return
+	}
+	bad := false
+	if err != nil {
+		class := classifyFailure(err, commandStderr(err))
+		bad = class == failureNetwork || class == failureAuth
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if bad {
+		b.lastURL = cloneURL
+	}
+	b.recent = append(b.recent, bad)
+	if len(b.recent) > breakerWindow {
+		b.recent = b.recent[1:]
+	}
+	n := 0
+	for _, bad := range b.recent {
+		if bad {
+			n++
+		}
+	}
+	if b.tripped || len(b.recent) < breakerWindow || float64(n) < b.threshold*float64(len(b.recent)) {
+		return
+	}
+	b.tripped = true
+	dispatchPause.set(true, fmt.Sprintf("circuit breaker: %d of the last %d repositories failed with network or authentication errors", n, len(b.recent)))
+	go b.diagnose(b.lastURL)
+}
+
+// diagnose tests the server and the token, and resumes or aborts the run.
+func (b *circuitBreaker) diagnose(cloneURL string) {
+	for check := 1; ; check++ {
+		err := b.checkServer(cloneURL)
+		if err == nil {
+			b.mu.Lock()
+			b.recent, b.tripped = nil, false
+			b.mu.Unlock()
+			dispatchPause.set(false, "circuit breaker: the server is reachable and accepts the token")
+			return
+		}
+		var statusErr *apiStatusError
+		rejected := errors.As(err, &statusErr) && (statusErr.code == http.StatusUnauthorized || statusErr.code == http.StatusForbidden)
+		if rejected || classifyFailure(err, commandStderr(err)) == failureAuth {
+			b.abortRun(fmt.Sprintf("circuit breaker: the server rejects the credentials (%v), check that GITEA_ACCESS_TOKEN is valid and has not expired", err))
+			break
+		}
+		if check == breakerChecks {
+			b.abortRun(fmt.Sprintf("circuit breaker: the server has been unreachable for %s (%v)", time.Duration(breakerChecks-1)*breakerRetry, err))
+			break
+		}
+		fmt.Printf("Circuit breaker: the server is not reachable (%v), testing again in %s\n", err, breakerRetry)
+		time.Sleep(breakerRetry)
+	}
+	// The dispatcher notices the canceled run once it is let through.
+	dispatchPause.set(false, "")
+}
+
+// errGitAccess marks a failure of git, rather than the API, to reach the
+// server.
+var errGitAccess = errors.New("git cannot reach the server")
+
+// checkServer tests that the API answers and, with a token, accepts it, and
+// that git can list the refs of cloneURL.
+func (b *circuitBreaker) checkServer(cloneURL string) error {
+	if _, err := fetchServerInfo(b.giteaHost, b.giteaAccessToken); err != nil {
+		return err
+	}
+	if b.giteaAccessToken != "" {
+		if _, err := fetchCurrentUser(context.Background(), b.giteaHost, b.giteaAccessToken); err != nil {
+			return err
+		}
+	}
+	if cloneURL == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), breakerRetry)
+	defer cancel()
+	if _, err := commandOutput(ctx, gitCommand("ls-remote", "--heads", cloneURL)); err != nil {
+		return fmt.Errorf("%w: %v", errGitAccess, err)
+	}
+	return nil
+}
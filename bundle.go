@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	formatBundle = "bundle"
+	// mirrorDir holds the bare mirrors bundles are created from.
+	mirrorDir = ".clonegitea/mirrors"
+	// bundledTipsFile, inside a mirror, lists the ref tips of the last bundle.
+	bundledTipsFile = "bundled-tips"
+)
+
+// mirrorPath is the bare mirror bundles of repo are made from.
+func mirrorPath(repo Repository) string {
+	return filepath.Join(mirrorDir, sanitizePath(repo.FullName)+".git")
+}
+
+// updateBundle updates the bare mirror of repo and writes a bundle of what
+// changed since the previous bundle into repoDir(repo): a full bundle the first
+// time, an incremental one afterwards. It returns the new bundle, or "" when
+// nothing changed.
+func updateBundle(ctx context.Context, cloneURL string, repo Repository) (string, error) {
+	mirror := mirrorPath(repo)
+	if _, err := os.Stat(mirror); os.IsNotExist(err) {
+		if err := gitClone(ctx, cloneURL, mirror, "--mirror"); err != nil {
+			return "", err
+		}
+	} else {
+		if err := renameRemote(ctx, mirror); err != nil {
+			return "", err
+		}
+		args := append(append([]string{"fetch", "--prune"}, gitTransportArgs()...), remoteName)
+		_, err := gitOutput(ctx, mirror, args...)
+		audit("fetch", mirror, "", err)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	refs, err := gitOutput(ctx, mirror, "for-each-ref", "--format=%(objectname)")
+	if err != nil {
+		return "", err
+	}
+	if refs == "" {
+		return "", nil
+	}
+
+	// Tips the mirror no longer has, e.g. after a force push and gc, cannot be
+	// excluded; without any left the next bundle is a full one again.
+	var previous []string
+	if data, err := os.ReadFile(filepath.Join(mirror, bundledTipsFile)); err == nil {
+		for _, sha := range strings.Fields(string(data)) {
+			if _, err := gitOutput(ctx, mirror, "cat-file", "-e", sha); err == nil {
+				previous = append(previous, sha)
+			}
+		}
+	}
+
+	kind := "full"
+	args := []string{"--all"}
+	if len(previous) > 0 {
+		kind = "incremental"
+		args = append(append(args, "--not"), previous...)
+		count, err := gitOutput(ctx, mirror, append([]string{"rev-list", "--count"}, args...)...)
+		if err != nil {
+			return "", err
+		}
+		if count == "0" {
+			return "", os.WriteFile(filepath.Join(mirror, bundledTipsFile), []byte(refs+"\n"), 0o644)
+		}
+	}
+
+	dir := repoDir(repo)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	bundle, err := filepath.Abs(filepath.Join(dir, fmt.Sprintf("%s-%s.bundle", time.Now().UTC().Format("20060102T150405Z"), kind)))
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(bundle); err == nil {
+		return "", fmt.Errorf("%s already exists", bundle)
+	}
+	if _, err := gitOutput(ctx, mirror, append([]string{"bundle", "create", "--quiet", bundle}, args...)...); err != nil {
+		os.Remove(bundle)
+		return "", err
+	}
+	return bundle, os.WriteFile(filepath.Join(mirror, bundledTipsFile), []byte(refs+"\n"), 0o644)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+const cacheDir = ".clonegitea/cache"
+
+type cachedResponse struct {
+	URL  string          `json:"url"`
+	ETag string          `json:"etag"`
+	Body json.RawMessage `json:"body"`
+	// Total is the X-Total-Count of the listing, -1 when unknown.
+	Total int `json:"total"`
+}
+
+// cacheKey includes the token so that users sharing a target directory never
+// see each other's cached listings.
+func cacheKey(giteaAccessToken, url string) string {
+	sum := sha256.Sum256([]byte(giteaAccessToken + "\n" + url))
+	return hex.EncodeToString(sum[:])
+}
+
+func loadCachedResponse(key string) (*cachedResponse, error) {
+	data, err := os.ReadFile(filepath.Join(cacheDir, key+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var cached cachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, err
+	}
+	return &cached, nil
+}
+
+func saveCachedResponse(key, url, etag string, body []byte, total int) error {
+	if !json.Valid(body) {
+		return nil
+	}
+	if err := os.MkdirAll(cacheDir, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.Marshal(cachedResponse{URL: url, ETag: etag, Body: body, Total: total})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(cacheDir, key+".json"), data, 0o600)
+}
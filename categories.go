@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const defaultCategoriesFile = "categories.yaml"
+
+// categoryRule is one rule of a category: a file in the top directory of the
+// repository matching a glob, a topic, or a regular expression matching
+// owner/name.
+type categoryRule struct {
+	kind  string
+	value string
+	name  *regexp.Regexp
+}
+
+// category is a directory below the target directory that the repositories
+// matching any of its rules are cloned into.
+type category struct {
+	dir   string
+	rules []categoryRule
+}
+
+// loadCategories reads the categories from a YAML file of categories, each
+// followed by its rules as a list, e.g.
+//
+//	backend:
+//	  - file: go.mod
+//	  - topic: api
+//	infrastructure:
+//	  - name: ^infra/
+//
+// A missing file is not an error when optional is set.
+func loadCategories(file string, optional bool) ([]category, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) && optional {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var categories []category
+	for i, line := range strings.Split(string(data), "\n") {
+		line = stripYAMLComment(line)
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "- ") {
+			dir, rest, ok := splitYAMLPair(line)
+			if !ok || dir == "" || rest != "" || line[0] == ' ' || line[0] == '\t' {
+				return nil, fmt.Errorf("%s:%d: expected a category, e.g. \"backend:\"", file, i+1)
+			}
+			if filepath.IsAbs(dir) || strings.HasPrefix(dir, ".") || strings.Contains(dir, "..") {
+				return nil, fmt.Errorf("%s:%d: the category %q has to be a directory below the target directory", file, i+1, dir)
+			}
+			categories = append(categories, category{dir: filepath.FromSlash(dir)})
+			continue
+		}
+		kind, value, ok := splitYAMLPair(strings.TrimSpace(trimmed[2:]))
+		if !ok || value == "" || len(categories) == 0 {
+			return nil, fmt.Errorf("%s:%d: expected a rule of a category, e.g. \"  - file: go.mod\"", file, i+1)
+		}
+		rule := categoryRule{kind: kind, value: value}
+		switch kind {
+		case "file":
+			if _, err := path.Match(value, ""); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid pattern %q", file, i+1, value)
+			}
+		case "topic":
+			rule.value = strings.ToLower(value)
+		case "name":
+			if rule.name, err = regexp.Compile(value); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", file, i+1, err)
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown rule %q, use file, topic or name", file, i+1, kind)
+		}
+		last := &categories[len(categories)-1]
+		last.rules = append(last.rules, rule)
+	}
+	return categories, nil
+}
+
+// categorize sets the category of every repository to the first category
+// with a matching rule. The files in the top directory are read from an
+// existing clone, or else asked from the API, and topics are asked from the
+// API when the listing did not have them; either only when a rule needs
+// them.
+func categorize(giteaHost, giteaAccessToken string, repos []Repository, categories []category) error {
+	needFiles, needTopics := false, false
+	for _, c := range categories {
+		for _, rule := range c.rules {
+			needFiles = needFiles || rule.kind == "file"
+			needTopics = needTopics || rule.kind == "topic"
+		}
+	}
+	for i := range repos {
+		repo := &repos[i]
+		if needTopics && repo.Topics == nil {
+			var topics struct {
+				Topics []string `json:"topics"`
+			}
+			if err := getJSON(repoAPIURL(giteaHost, *repo)+"/topics", giteaAccessToken, &topics); err != nil && !isNotFound(err) {
+				return fmt.Errorf("fetching the topics of %s: %w", repo.FullName, err)
+			}
+			repo.Topics = append([]string{}, topics.Topics...)
+		}
+		var files []string
+		if needFiles {
+			var err error
+			if files, err = topLevelFiles(giteaHost, giteaAccessToken, *repo, categories); err != nil {
+				return fmt.Errorf("listing the files of %s: %w", repo.FullName, err)
+			}
+		}
+		repo.Category = ""
+		for _, c := range categories {
+			if c.matches(*repo, files) {
+				repo.Category = c.dir
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (c category) matches(repo Repository, files []string) bool {
+	for _, rule := range c.rules {
+		switch rule.kind {
+		case "file":
+			for _, file := range files {
+				if ok, _ := path.Match(rule.value, file); ok {
+					return true
+				}
+			}
+		case "topic":
+			for _, topic := range repo.Topics {
+				if strings.ToLower(topic) == rule.value {
+					return true
+				}
+			}
+		case "name":
+			if rule.name.MatchString(repo.FullName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// topLevelFiles lists the names in the top directory of repo's default
+// branch.
+func topLevelFiles(giteaHost, giteaAccessToken string, repo Repository, categories []category) ([]string, error) {
+	if dir := existingCategoryClone(repo, categories); dir != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if _, err := gitOutput(ctx, dir, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+			return nil, nil
+		}
+		out, err := gitOutput(ctx, dir, "ls-tree", "-z", "--name-only", "HEAD")
+		if err != nil {
+			return nil, err
+		}
+		return strings.Split(out, "\x00"), nil
+	}
+	var entries []struct {
+		Name string `json:"name"`
+	}
+	// An empty repository has no contents to list.
+	if err := getJSON(repoAPIURL(giteaHost, repo)+"/contents", giteaAccessToken, &entries); err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		files = append(files, entry.Name)
+	}
+	return files, nil
+}
+
+// categoryPaths are the places the clone of repo can be, in any or no
+// category.
+func categoryPaths(repo Repository, categories []category) []string {
+	base := sanitizePath(repo.FullName)
+	paths := []string{base}
+	for _, c := range categories {
+		paths = append(paths, filepath.Join(c.dir, base))
+	}
+	return paths
+}
+
+// existingCategoryClone returns the clone of repo in any or no category,
+// empty if there is none.
+func existingCategoryClone(repo Repository, categories []category) string {
+	for _, dir := range categoryPaths(repo, categories) {
+		for _, candidate := range []string{dir, dir + bareSuffix} {
+			if isClone(candidate) {
+				return candidate
+			}
+		}
+	}
+	return ""
+}
+
+// relocateClones moves the existing clones of repos whose category changed
+// into the directory of their new category. Repositories routed elsewhere by
+// paths.yaml are left alone.
+func relocateClones(repos []Repository, categories []category, suffix string) error {
+	for _, repo := range repos {
+		if _, err := os.Stat(repo.Path); !os.IsNotExist(err) {
+			continue
+		}
+		if repo.Path != filepath.Join(repo.Category, sanitizePath(repo.FullName))+suffix {
+			continue
+		}
+		for _, dir := range categoryPaths(repo, categories) {
+			dir += suffix
+			if dir == repo.Path || !isClone(dir) {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(repo.Path), os.ModePerm); err != nil {
+				return err
+			}
+			err := os.Rename(dir, repo.Path)
+			audit("categorize", dir, repo.Path, err)
+			if err != nil {
+				return fmt.Errorf("moving %s to its category: %w", repo.FullName, err)
+			}
+			fmt.Printf("Moved %s from %s to %s\n", repo.FullName, filepath.ToSlash(dir), filepath.ToSlash(repo.Path))
+			// Leave no empty owner directory behind.
+			os.Remove(filepath.Dir(dir))
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	checksumsDir = ".checksums"
+	// checksumsFile is in the format of sha256sum, so that the tracked files
+	// can be checked with sha256sum -c in the clone.
+	checksumsFile = "SHA256SUMS"
+)
+
+// writeChecksums writes the SHA-256 of every file git tracks in the clone in
+// dir to .checksums/SHA256SUMS, and the commit and tree of HEAD to
+// .checksums/HEAD. Symbolic links and submodules are left out. Bare
+// repositories have no files to hash and only get HEAD.
+func writeChecksums(ctx context.Context, dir string) error {
+	commit, err := gitOutput(ctx, dir, "rev-parse", "--verify", "HEAD")
+	if err != nil {
+		return err
+	}
+	tree, err := gitOutput(ctx, dir, "rev-parse", "--verify", "HEAD^{tree}")
+	if err != nil {
+		return err
+	}
+
+	out := filepath.Join(dir, checksumsDir)
+	if isBareRepo(dir) {
+		out = filepath.Join(dir, "info", "checksums")
+	}
+	if err := os.MkdirAll(out, os.ModePerm); err != nil {
+		return err
+	}
+	if err := excludeFromGit(dir, "/"+checksumsDir+"/"); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(out, "HEAD"), []byte(fmt.Sprintf("commit %s\ntree %s\n", commit, tree)), 0o644); err != nil {
+		return err
+	}
+	if isBareRepo(dir) {
+		return nil
+	}
+
+	// ls-files -s shows the mode, which tells regular files from symbolic
+	// links (120000) and submodules (160000).
+	files, err := gitOutput(ctx, dir, "ls-files", "-s", "-z")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(out, checksumsFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	w := bufio.NewWriter(f)
+	for _, entry := range strings.Split(files, "\x00") {
+		info, name, ok := strings.Cut(entry, "\t")
+		if !ok || !strings.HasPrefix(info, "100") {
+			continue
+		}
+		sum, err := fileSHA256(filepath.Join(dir, filepath.FromSlash(name)))
+		if os.IsNotExist(err) {
+			// Deleted in the working tree, e.g. by a sparse checkout.
+			continue
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+		// sha256sum escapes names with a backslash or newline the same way.
+		prefix := ""
+		if strings.ContainsAny(name, "\\\n") {
+			prefix = "\\"
+			name = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(name)
+		}
+		fmt.Fprintf(w, "%s%s  %s\n", prefix, sum, name)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(out, checksumsFile))
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	adaptiveStartWorkers = 2
+	adaptiveMaxWorkers   = 16
+	adaptiveInterval     = 5 * time.Second
+	adaptiveMaxErrorRate = 0.25
+)
+
+// limiter is a counting semaphore whose capacity can be changed while in use.
+type limiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+}
+
+func newLimiter(limit int) *limiter {
+	l := &limiter{limit: limit}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *limiter) acquire() {
+	l.mu.Lock()
+	for l.active >= l.limit {
+		l.cond.Wait()
+	}
+	l.active++
+	l.mu.Unlock()
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+func (l *limiter) setLimit(limit int) {
+	if limit < 1 {
+		limit = 1
+	}
+	l.mu.Lock()
+	l.limit = limit
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+func (l *limiter) getLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// adaptiveTuner grows the limiter while throughput keeps improving and
+// shrinks it when throughput drops or too many clones and syncs fail.
+// Throughput is the bytes per second the clones and syncs that finished moved
+// while they ran, times the number of workers, so that neither a few large
+// repositories nor a burst of small ones make it jump. Windows in which
+// nothing finished leave the limit alone.
+type adaptiveTuner struct {
+	lim        *limiter
+	maxWorkers int
+
+	mu             sync.Mutex
+	completed      int
+	failed         int
+	bytes          int64
+	busy           time.Duration
+	prevThroughput float64
+
+	stop chan struct{}
+}
+
+func newAdaptiveTuner(lim *limiter, maxWorkers int) *adaptiveTuner {
+	if maxWorkers < 1 {
+		maxWorkers = adaptiveMaxWorkers
+	}
+	start := adaptiveStartWorkers
+	if start > maxWorkers {
+		start = maxWorkers
+	}
+	lim.setLimit(start)
+	return &adaptiveTuner{lim: lim, maxWorkers: maxWorkers, stop: make(chan struct{})}
+}
+
+// observe records a clone or sync that moved bytes in elapsed.
+func (t *adaptiveTuner) observe(err error, bytes int64, elapsed time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.completed++
+	if err != nil {
+		t.failed++
+		return
+	}
+	t.bytes += bytes
+	t.busy += elapsed
+}
+
+func (t *adaptiveTuner) run() {
+	ticker := time.NewTicker(adaptiveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			t.adjust()
+		}
+	}
+}
+
+func (t *adaptiveTuner) close() {
+	close(t.stop)
+}
+
+func (t *adaptiveTuner) adjust() {
+	current := t.lim.getLimit()
+	t.mu.Lock()
+	completed, failed, bytes, busy := t.completed, t.failed, t.bytes, t.busy
+	if completed == 0 {
+		// Idle, or every worker is busy with a long clone: nothing to
+		// judge the limit by.
+		t.mu.Unlock()
+		return
+	}
+	t.completed, t.failed, t.bytes, t.busy = 0, 0, 0, 0
+	prev := t.prevThroughput
+	throughput := prev
+	if busy > 0 {
+		throughput = float64(bytes) / busy.Seconds() * float64(current)
+		t.prevThroughput = throughput
+	}
+	t.mu.Unlock()
+
+	next := current
+	switch {
+	case float64(failed)/float64(completed) > adaptiveMaxErrorRate:
+		next = current / 2
+	case busy == 0:
+		// Only failures, but not enough to back off.
+	case throughput >= prev*0.9:
+		next = current + 1
+	case throughput < prev*0.75:
+		next = current - 1
+	}
+	if next > t.maxWorkers {
+		next = t.maxWorkers
+	}
+	if next < 1 {
+		next = 1
+	}
+	if next != current {
+		fmt.Printf("Adaptive concurrency: %d -> %d workers (%s/s, %d of %d failed)\n", current, next, formatSize(int64(throughput)), failed, completed)
+		t.lim.setLimit(next)
+	}
+}
+
+// ownerLimiter caps the number of concurrent clones per repository owner.
+type ownerLimiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active map[string]int
+}
+
+func newOwnerLimiter(limit int) *ownerLimiter {
+	o := &ownerLimiter{limit: limit, active: make(map[string]int)}
+	o.cond = sync.NewCond(&o.mu)
+	return o
+}
+
+// acquireAny blocks until the owner of one of the pending repositories has a
+// free slot, takes that slot and returns the repository's index. Repositories
+// are considered in order, so owners below their cap are never held up by a
+// saturated one.
+func (o *ownerLimiter) acquireAny(pending []Repository) int {
+	if o.limit <= 0 {
+		return 0
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for {
+		for i, repo := range pending {
+			owner := repoOwner(repo)
+			if o.active[owner] < o.limit {
+				o.active[owner]++
+				return i
+			}
+		}
+		o.cond.Wait()
+	}
+}
+
+func (o *ownerLimiter) release(owner string) {
+	if o.limit <= 0 {
+		return
+	}
+	o.mu.Lock()
+	o.active[owner]--
+	o.mu.Unlock()
+	o.cond.Broadcast()
+}
+
+// runPhase is a part of a run's repositories that is processed to the end
+// before the next part starts, with its own limit of concurrent work and,
+// with --adaptive, its own tuner of that limit: fetches and clones move data
+// at different rates.
+type runPhase struct {
+	repos []Repository
+	lim   *limiter
+	tuner *adaptiveTuner
+}
+
+// syncPhases splits repos into a phase that syncs the existing clones like
+// syncing and a phase that clones the others like cloning, leaving out empty
+// phases.
+func syncPhases(repos []Repository, syncing, cloning runPhase) []runPhase {
+	var existing, fresh []Repository
+	for _, repo := range repos {
+		if _, err := os.Stat(repoDir(repo)); err == nil {
+			existing = append(existing, repo)
+		} else {
+			fresh = append(fresh, repo)
+		}
+	}
+	var phases []runPhase
+	if len(existing) > 0 {
+		if limit := syncing.lim.getLimit(); limit < len(existing) {
+			fmt.Printf("Syncing %d existing repositories, %d at a time\n", len(existing), limit)
+		} else {
+			fmt.Printf("Syncing %d existing repositories\n", len(existing))
+		}
+		syncing.repos = existing
+		phases = append(phases, syncing)
+	}
+	if len(fresh) > 0 {
+		if len(existing) > 0 {
+			fmt.Printf("Then cloning %d new repositories\n", len(fresh))
+		}
+		cloning.repos = fresh
+		phases = append(phases, cloning)
+	}
+	return phases
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// utf8BOM starts files saved as "UTF-8 with BOM", as Notepad used to.
+const utf8BOM = "\ufeff"
+
+// configKey describes a key of config.env.
+type configKey struct {
+	required bool
+	// example is shown when a required value is missing.
+	example string
+	// check validates a non-empty value and returns it normalized.
+	check func(value string) (string, error)
+}
+
+// configKeys are the keys config.env may set.
+var configKeys = map[string]configKey{
+	"GITEA_HOST":                 {required: true, example: "https://gitea.example.com", check: parseHost},
+	"GITEA_ACCESS_TOKEN":         {},
+	"TARGET_DIR":                 {required: true, example: "./backup", check: checkDir},
+	"NOTIFY_WEBHOOK_URL":         {check: checkURL},
+	"NOTIFY_WEBHOOK_FAILURES":    {check: checkBool},
+	"RESTORE_GITEA_HOST":         {check: parseHost},
+	"RESTORE_GITEA_ACCESS_TOKEN": {},
+	"SIGNATURE_KEYRING":          {check: checkExists},
+	"SSH_KEY_FILE":               {check: checkExists},
+	"GITEA_RESOLVE":              {check: checkIP},
+	"REMOTE_NAME":                {check: func(v string) (string, error) { return v, checkRemoteName(v) }},
+	"S3_ENDPOINT":                {check: checkURL},
+	"S3_BUCKET":                  {},
+	"S3_REGION":                  {},
+	"S3_ACCESS_KEY":              {},
+	"S3_SECRET_KEY":              {},
+	"S3_PREFIX":                  {},
+	"S3_RETENTION":               {check: func(v string) (string, error) { _, err := parseRetention(v); return v, err }},
+	"DEFAULT_FLAGS":              {check: checkFlags},
+	"STATE_STORE":                {check: func(v string) (string, error) { _, err := parseStateStore(v); return v, err }},
+	"CONTROL_TOKEN":              {},
+}
+
+// validateConfig checks the values of a loaded config against configKeys and
+// normalizes them in place. lines has the line number of every key, for the
+// error messages.
+func validateConfig(path string, config map[string]string, lines map[string]int) error {
+	keys := make([]string, 0, len(configKeys))
+	for key := range configKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		spec, value := configKeys[key], config[key]
+		if value == "" {
+			if spec.required {
+				return fmt.Errorf("%s: %s is required, e.g. %s=%s", path, key, key, spec.example)
+			}
+			continue
+		}
+		if spec.check == nil {
+			continue
+		}
+		normalized, err := spec.check(value)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", path, lines[key], key, err)
+		}
+		config[key] = normalized
+	}
+	return nil
+}
+
+// unknownConfigKey describes a key that is not in configKeys, suggesting the
+// closest known one when it looks like a typo.
+func unknownConfigKey(key string) string {
+	best, bestDistance := "", 4
+	for known := range configKeys {
+		if d := editDistance(key, known); d < bestDistance || (d == bestDistance && known < best) {
+			best, bestDistance = known, d
+		}
+	}
+	if best == "" {
+		// GITEA_TOKEN for GITEA_ACCESS_TOKEN: every word of key is in one
+		// known key.
+		words := strings.Split(key, "_")
+		for known := range configKeys {
+			knownWords := strings.Split(known, "_")
+			matched := 0
+			for _, word := range words {
+				if contains(knownWords, word) {
+					matched++
+				}
+			}
+			if matched == len(words) && (best == "" || known < best) {
+				best = known
+			}
+		}
+	}
+	if best == "" {
+		return "unknown key " + key
+	}
+	return fmt.Sprintf("unknown key %s, did you mean %s?", key, best)
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = current[j-1] + 1
+			if d := previous[j] + 1; d < current[j] {
+				current[j] = d
+			}
+			if d := previous[j-1] + cost; d < current[j] {
+				current[j] = d
+			}
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func checkDir(value string) (string, error) {
+	return filepath.Clean(value), nil
+}
+
+func checkFlags(value string) (string, error) {
+	for _, field := range strings.Fields(value) {
+		if !strings.HasPrefix(field, "-") {
+			return "", fmt.Errorf("%q is no flag; the value of a flag has to follow it with =, e.g. --org=infra", field)
+		}
+	}
+	return value, nil
+}
+
+func checkURL(value string) (string, error) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid URL %q, expected http(s)://host/...", value)
+	}
+	return value, nil
+}
+
+func checkBool(value string) (string, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid value %q, use true or false", value)
+	}
+	return strconv.FormatBool(b), nil
+}
+
+func checkExists(value string) (string, error) {
+	if _, err := os.Stat(value); err != nil {
+		return "", fmt.Errorf("%s does not exist", value)
+	}
+	return value, nil
+}
+
+func checkIP(value string) (string, error) {
+	if net.ParseIP(value) == nil {
+		return "", fmt.Errorf("invalid IP address %q", value)
+	}
+	return value, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// controlHandler serves the daemon's control API:
+//
+//	POST   /sync                  trigger a run now
+//	GET    /repos                 result of every repository in the last run
+//	GET    /repos/{owner}/{name}  result of one repository
+//	GET    /excludes              excluded owner/name patterns
+//	POST   /excludes?pattern=p    exclude p from the next run on
+//	DELETE /excludes?pattern=p    stop excluding p
+//	POST   /drain                 exit after the current run
+//	POST   /stop                  cancel the current run and exit, leaving the work queue for --resume
+//
+// With a token, every request must send it as "Authorization: Bearer <token>".
+// Without one, requests must be addressed to a loopback host and come without
+// an Origin header. Requests that change something must be sent as JSON.
+func (s *daemonStatus) controlHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sync", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		select {
+		case s.trigger <- struct{}{}:
+		default:
+		}
+		s.mu.Lock()
+		running := s.Running
+		s.mu.Unlock()
+		if running {
+			writeControlJSON(w, http.StatusAccepted, map[string]string{"status": "queued after the current run"})
+			return
+		}
+		writeControlJSON(w, http.StatusAccepted, map[string]string{"status": "triggered"})
+	})
+	mux.HandleFunc("/repos", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		rows := []reportRow{}
+		if s.LastRun != nil && s.LastRun.Results != nil {
+			rows = s.LastRun.Results
+		}
+		writeControlJSON(w, http.StatusOK, rows)
+	})
+	mux.HandleFunc("/repos/", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/repos/")
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.LastRun != nil {
+			for _, row := range s.LastRun.Results {
+				if row.Repository == name {
+					writeControlJSON(w, http.StatusOK, row)
+					return
+				}
+			}
+		}
+		writeControlJSON(w, http.StatusNotFound, map[string]string{"error": "repository not in the last run"})
+	})
+	mux.HandleFunc("/excludes", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, http.MethodGet, http.MethodPost, http.MethodDelete) {
+			return
+		}
+		pattern := r.URL.Query().Get("pattern")
+		if r.Method != http.MethodGet {
+			if _, err := path.Match(pattern, ""); pattern == "" || err != nil {
+				writeControlJSON(w, http.StatusBadRequest, map[string]string{"error": "missing or invalid pattern"})
+				return
+			}
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch r.Method {
+		case http.MethodPost:
+			if !contains(s.Excludes, pattern) {
+				s.Excludes = append(s.Excludes, pattern)
+			}
+			audit("control", "exclude", pattern, nil)
+		case http.MethodDelete:
+			kept := s.Excludes[:0]
+			for _, p := range s.Excludes {
+				if p != pattern {
+					kept = append(kept, p)
+				}
+			}
+			s.Excludes = kept
+			audit("control", "include", pattern, nil)
+		}
+		writeControlJSON(w, http.StatusOK, s.Excludes)
+	})
+	mux.HandleFunc("/drain", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		s.mu.Lock()
+		s.Draining = true
+		running := s.Running
+		s.mu.Unlock()
+		audit("control", "drain", "", nil)
+		if !running {
+			writeControlJSON(w, http.StatusAccepted, map[string]string{"status": "exiting"})
+			s.stop()
+			return
+		}
+		writeControlJSON(w, http.StatusAccepted, map[string]string{"status": "exiting after the current run"})
+	})
+	mux.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		s.mu.Lock()
+		s.stopping = true
+		s.mu.Unlock()
+		audit("control", "stop", "", nil)
+		writeControlJSON(w, http.StatusAccepted, map[string]string{"status": "exiting once the running clones are canceled"})
+		s.stop()
+	})
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeControlJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or wrong CONTROL_TOKEN"})
+		case token == "" && (!isLoopbackHost(r.Host) || r.Header.Get("Origin") != ""):
+			// Without a token, a web page could otherwise drive the API
+			// through the browser, directly or by rebinding its own name to
+			// 127.0.0.1.
+			writeControlJSON(w, http.StatusForbidden, map[string]string{"error": "requests from browsers and through other host names need CONTROL_TOKEN"})
+		case r.Method != http.MethodGet && r.Method != http.MethodHead && !isJSONRequest(r):
+			// Browsers send other content types cross-origin only after
+			// asking with a preflight request, which this API does not answer.
+			writeControlJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "send Content-Type: application/json"})
+		default:
+			mux.ServeHTTP(w, r)
+		}
+	})
+}
+
+// isLoopbackHost reports whether the Host header of a request names this
+// machine.
+func isLoopbackHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// controlAddress returns the address to serve the control API on. Without a
+// token it may only be reached from this machine: a missing host means
+// 127.0.0.1, and other hosts than loopback addresses are refused.
+func controlAddress(addr, token string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid --control %q: %w", addr, err)
+	}
+	if token != "" {
+		return addr, nil
+	}
+	if host == "" {
+		return net.JoinHostPort("127.0.0.1", port), nil
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", fmt.Errorf("--control %s is reachable from other machines, set CONTROL_TOKEN in config.env or bind it to a loopback address such as 127.0.0.1:%s", addr, port)
+	}
+	return addr, nil
+}
+
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	if contains(methods, r.Method) {
+		return true
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeControlJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	return false
+}
+
+func writeControlJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestControlHandlerGuard(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		host   string
+		header map[string]string
+		want   int
+	}{
+		{name: "get", method: "GET", host: "127.0.0.1:8081", want: http.StatusOK},
+		{name: "localhost", method: "GET", host: "localhost:8081", want: http.StatusOK},
+		{name: "ipv6 loopback", method: "GET", host: "[::1]:8081", want: http.StatusOK},
+		{name: "post as json", method: "POST", host: "127.0.0.1:8081", header: map[string]string{"Content-Type": "application/json"}, want: http.StatusAccepted},
+		{name: "simple post", method: "POST", host: "127.0.0.1:8081", header: map[string]string{"Content-Type": "text/plain"}, want: http.StatusUnsupportedMediaType},
+		{name: "post without type", method: "POST", host: "127.0.0.1:8081", want: http.StatusUnsupportedMediaType},
+		{name: "rebound name", method: "GET", host: "evil.example.com:8081", want: http.StatusForbidden},
+		{name: "browser", method: "POST", host: "127.0.0.1:8081", header: map[string]string{"Origin": "https://evil.example.com", "Content-Type": "application/json"}, want: http.StatusForbidden},
+		{name: "token missing", token: "s3cret", method: "GET", host: "backup.example.com", want: http.StatusUnauthorized},
+		{name: "token wrong", token: "s3cret", method: "GET", host: "backup.example.com", header: map[string]string{"Authorization": "Bearer nope"}, want: http.StatusUnauthorized},
+		{name: "token", token: "s3cret", method: "GET", host: "backup.example.com", header: map[string]string{"Authorization": "Bearer s3cret"}, want: http.StatusOK},
+		{name: "token simple post", token: "s3cret", method: "POST", host: "backup.example.com", header: map[string]string{"Authorization": "Bearer s3cret"}, want: http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		status := &daemonStatus{Excludes: []string{}, trigger: make(chan struct{}, 1), stop: func() {}}
+		path := "/excludes"
+		if tt.method == "POST" {
+			path = "/sync"
+		}
+		req := httptest.NewRequest(tt.method, path, nil)
+		req.Host = tt.host
+		for key, value := range tt.header {
+			req.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		status.controlHandler(tt.token).ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+}
+
+func TestControlAddress(t *testing.T) {
+	tests := []struct {
+		addr, token string
+		want        string
+		wantErr     bool
+	}{
+		{addr: ":8081", want: "127.0.0.1:8081"},
+		{addr: "127.0.0.1:8081", want: "127.0.0.1:8081"},
+		{addr: "localhost:8081", want: "localhost:8081"},
+		{addr: "0.0.0.0:8081", wantErr: true},
+		{addr: "0.0.0.0:8081", token: "s3cret", want: "0.0.0.0:8081"},
+		{addr: "8081", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := controlAddress(tt.addr, tt.token)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("controlAddress(%q, %q) = %q, %v, want %q", tt.addr, tt.token, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// daemonStatus is what the daemon reports on /status.
+type daemonStatus struct {
+	mu           sync.Mutex
+	Running      bool        `json:"running"`
+	LastSync     *time.Time  `json:"last_sync,omitempty"`
+	LastError    string      `json:"last_error,omitempty"`
+	ReposTracked int         `json:"repos_tracked"`
+	Failures     []string    `json:"failures"`
+	LastRun      *runSummary `json:"last_run,omitempty"`
+	NextRun      *time.Time  `json:"next_run,omitempty"`
+	Draining     bool        `json:"draining,omitempty"`
+	Excludes     []string    `json:"excludes"`
+	// LastDeepVerify and VerifyProblems are the last --deep-verify-interval
+	// pass and the clones it found problems in.
+	LastDeepVerify *time.Time `json:"last_deep_verify,omitempty"`
+	VerifyProblems []string   `json:"verify_problems,omitempty"`
+
+	trigger chan struct{}
+	// stop cancels the current run, or the wait for the next one, and
+	// stopping tells that /stop asked for it rather than /drain.
+	stop     context.CancelFunc
+	stopping bool
+}
+
+// started marks a run as in progress and returns the excludes it should use.
+func (s *daemonStatus) started() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Running = true
+	s.NextRun = nil
+	return append([]string(nil), s.Excludes...)
+}
+
+// finished records the outcome of a run and reports whether the daemon was
+// asked to drain and should exit.
+func (s *daemonStatus) finished(summary runSummary, err error, next time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Running = false
+	s.NextRun = &next
+	if s.Draining {
+		s.NextRun = nil
+	}
+	if err != nil {
+		s.LastError = err.Error()
+		return s.Draining
+	}
+	s.LastError = ""
+	s.LastSync = &summary.Finished
+	s.LastRun = &summary
+	s.ReposTracked = summary.Repositories
+	s.Failures = summary.Failures
+	return s.Draining
+}
+
+// verifying runs the deep verification and records its outcome, reporting
+// problems to the webhook.
+func (s *daemonStatus) verifying(opts *options) {
+	jobs := opts.concurrency
+	if jobs < 1 {
+		jobs = runtime.NumCPU()
+	}
+	names, err := deepVerify(".", jobs)
+	if err != nil {
+		fmt.Printf("Error: deep verify: %v\n", err)
+	}
+	now := time.Now()
+	s.mu.Lock()
+	s.LastDeepVerify = &now
+	s.VerifyProblems = names
+	s.mu.Unlock()
+	if len(names) > 0 && opts.webhookURL != "" {
+		message := fmt.Sprintf("Deep verify of %s found problems in %d clones: %s", opts.giteaHost, len(names), strings.Join(names, ", "))
+		if err := postWebhook(opts.webhookURL, message); err != nil {
+			fmt.Printf("Warning: could not post to webhook: %v\n", err)
+		}
+	}
+}
+
+func (s *daemonStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// runDaemon repeats clone runs every opts.interval. When opts.listen is set it
+// serves /healthz and /status for orchestrators and monitoring, and when
+// opts.control is set it serves the control API.
+func runDaemon(opts *options) error {
+	var verifyInterval time.Duration
+	if opts.deepVerify != "" {
+		var err error
+		if verifyInterval, err = parseRetention(opts.deepVerify); err != nil || verifyInterval <= 0 {
+			return fmt.Errorf("invalid --deep-verify-interval %q", opts.deepVerify)
+		}
+		if !opts.syncRepos {
+			return errors.New("--deep-verify-interval needs --sync, so that the clones are up to date with the server")
+		}
+	}
+	stopCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	opts.stop = stopCtx
+	status := &daemonStatus{
+		Failures: []string{},
+		Excludes: append([]string{}, opts.exclude...),
+		trigger:  make(chan struct{}, 1),
+		stop:     stop,
+	}
+	if opts.control != "" {
+		addr, err := controlAddress(opts.control, opts.controlToken)
+		if err != nil {
+			return err
+		}
+		server := &http.Server{Addr: addr, Handler: status.controlHandler(opts.controlToken), ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := server.ListenAndServe(); err != nil {
+				fmt.Printf("Error serving control API: %v\n", err)
+			}
+		}()
+		fmt.Printf("Serving control API on %s\n", addr)
+	}
+	if opts.listen != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, "ok")
+		})
+		mux.Handle("/status", status)
+		server := &http.Server{Addr: opts.listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := server.ListenAndServe(); err != nil {
+				fmt.Printf("Error serving status endpoint: %v\n", err)
+			}
+		}()
+		fmt.Printf("Serving /healthz and /status on %s\n", opts.listen)
+	}
+
+	for {
+		opts.exclude = status.started()
+		summary, err := run(opts)
+		if stopCtx.Err() != nil {
+			return status.stopped()
+		}
+		if errors.Is(err, errInterrupted) || errors.Is(err, context.Canceled) {
+			// Interrupted, or interrupted while listing the repositories.
+			return err
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+		if verifyInterval > 0 && err == nil && deepVerifyDue(verifyInterval) {
+			status.verifying(opts)
+		}
+		next := time.Now().Add(opts.interval)
+		if status.finished(summary, err, next) {
+			fmt.Println("Drained, exiting")
+			return nil
+		}
+		fmt.Printf("Next run at %s\n", next.Format(time.RFC3339))
+		select {
+		case <-time.After(time.Until(next)):
+		case <-status.trigger:
+			fmt.Println("Sync triggered through the control API")
+		case <-stopCtx.Done():
+			return status.stopped()
+		}
+	}
+}
+
+// stopped reports that the control API stopped the daemon. By then the run
+// it canceled has finished, so the lock can be released.
+func (s *daemonStatus) stopped() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopping {
+		fmt.Println("Stopped through the control API, continue an interrupted run with --resume")
+	} else {
+		fmt.Println("Drained, exiting")
+	}
+	return nil
+}
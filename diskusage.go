@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// diskUsageHistory is how many samples the state keeps.
+const diskUsageHistory = 100
+
+// diskUsageSample is the disk usage of the clones after one run.
+type diskUsageSample struct {
+	Time   time.Time        `json:"time"`
+	Total  int64            `json:"total"`
+	Owners map[string]int64 `json:"owners"`
+}
+
+type repoUsage struct {
+	name string
+	size int64
+}
+
+// measureDiskUsage adds up the space every repository takes: its clone and,
+// depending on the mode, its bare repository in .store or its mirror for
+// bundles. The repositories are returned largest first.
+func measureDiskUsage(repos []Repository) (diskUsageSample, []repoUsage) {
+	sample := diskUsageSample{Time: time.Now(), Owners: make(map[string]int64)}
+	var usages []repoUsage
+	for _, repo := range repos {
+		var size int64
+		for _, dir := range []string{repoDir(repo), storePath(repo), mirrorPath(repo)} {
+			size += dirSize(dir)
+		}
+		sample.Total += size
+		sample.Owners[repoOwner(repo)] += size
+		usages = append(usages, repoUsage{repo.FullName, size})
+	}
+	sort.SliceStable(usages, func(i, j int) bool { return usages[i].size > usages[j].size })
+	return sample, usages
+}
+
+// dirSize returns the total size of the files below dir, 0 if it does not
+// exist.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// printDiskUsage prints the usage per owner and the top largest repositories,
+// with the change since previous when there is one.
+func printDiskUsage(sample diskUsageSample, usages []repoUsage, top int, previous *diskUsageSample) {
+	since := ""
+	if previous != nil {
+		since = fmt.Sprintf(" (%s since %s)", sizeChange(sample.Total, previous.Total), previous.Time.Format("2006-01-02 15:04"))
+	}
+	fmt.Printf("Disk usage: %s in %d repositories%s\n", formatSize(sample.Total), len(usages), since)
+
+	owners := make([]string, 0, len(sample.Owners))
+	for owner := range sample.Owners {
+		owners = append(owners, owner)
+	}
+	sort.Slice(owners, func(i, j int) bool { return sample.Owners[owners[i]] > sample.Owners[owners[j]] })
+	for _, owner := range owners {
+		change := ""
+		if previous != nil {
+			if before, ok := previous.Owners[owner]; ok {
+				change = " (" + sizeChange(sample.Owners[owner], before) + ")"
+			}
+		}
+		fmt.Printf("  %-30s %8s%s\n", owner, formatSize(sample.Owners[owner]), change)
+	}
+
+	if top > len(usages) {
+		top = len(usages)
+	}
+	fmt.Printf("Largest %d repositories:\n", top)
+	for _, u := range usages[:top] {
+		fmt.Printf("  %-40s %8s\n", u.name, formatSize(u.size))
+	}
+}
+
+// sizeChange renders the difference between two sizes, e.g. +1.5G.
+func sizeChange(now, before int64) string {
+	switch {
+	case now > before:
+		return "+" + formatSize(now-before)
+	case now < before:
+		return "-" + formatSize(before-now)
+	}
+	return "no change"
+}
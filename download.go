@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxDownloadResumes is how often a download that broke off is resumed within
+// one run. What was downloaded is kept for the next run either way.
+const maxDownloadResumes = 5
+
+// partialDownload is stored next to a .part file and records what it is a
+// part of, so that it is only resumed with the same content.
+type partialDownload struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// validator returns the value for If-Range, which takes a strong ETag or a
+// date, empty if the server sent neither.
+func (p partialDownload) validator() string {
+	if p.ETag != "" && !strings.HasPrefix(p.ETag, "W/") {
+		return p.ETag
+	}
+	return p.LastModified
+}
+
+// downloadFile downloads url to dest through a temporary file and, when
+// sha256Hex is given, verifies the content before moving it into place. A
+// download that breaks off continues where it stopped with an HTTP range
+// request, in this run or the next, as long as the server still has the same
+// content.
+func downloadFile(url, giteaAccessToken, dest, sha256Hex string) error {
+	tmp := dest + ".part"
+	for resumes := 0; ; resumes++ {
+		progress, err := downloadPart(url, giteaAccessToken, tmp)
+		if err == nil {
+			break
+		}
+		if !progress || resumes >= maxDownloadResumes {
+			return err
+		}
+		fmt.Printf("Download of %s broke off (%v), resuming\n", dest, err)
+	}
+
+	if sha256Hex != "" {
+		sum, err := fileSHA256(tmp)
+		if err != nil {
+			return err
+		}
+		if sum != sha256Hex {
+			removePart(tmp)
+			return fmt.Errorf("checksum mismatch for %s", dest)
+		}
+	}
+	err := os.Rename(tmp, dest)
+	audit("download", dest, url, err)
+	if err == nil {
+		os.Remove(tmp + ".json")
+	}
+	return err
+}
+
+// downloadPart downloads url into tmp, continuing a partial download when the
+// server supports ranges and the content did not change since. progress
+// reports whether trying again gets further.
+func downloadPart(url, giteaAccessToken, tmp string) (progress bool, err error) {
+	var part partialDownload
+	var offset int64
+	if info, err := os.Stat(tmp); err == nil && readJSONFile(tmp+".json", &part) == nil && part.URL == url && part.validator() != "" {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return false, err
+	}
+	setAuth(req, giteaAccessToken)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", part.validator())
+	}
+	response, err := doWithRetry(downloadClient, req)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	total := response.ContentLength
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		start, size, ok := parseContentRange(response.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			removePart(tmp)
+			return true, fmt.Errorf("the server resumed at %q instead of byte %d", response.Header.Get("Content-Range"), offset)
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+		total = size
+	case http.StatusOK:
+		// The server sends all of it when it cannot resume or the content
+		// changed.
+		offset = 0
+		part = partialDownload{URL: url, ETag: response.Header.Get("ETag"), LastModified: response.Header.Get("Last-Modified")}
+		if err := writeJSONFile(tmp+".json", part); err != nil {
+			return false, err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The part already has everything, or more than there is now.
+		if _, size, ok := parseContentRange(response.Header.Get("Content-Range")); ok && size == offset {
+			return false, nil
+		}
+		removePart(tmp)
+		return true, fmt.Errorf("the partial download of %s does not match the server's content", url)
+	default:
+		return false, fmt.Errorf("download failed with HTTP status code: %d", response.StatusCode)
+	}
+
+	out, err := os.OpenFile(tmp, flags, 0o644)
+	if err != nil {
+		return false, err
+	}
+	n, err := io.Copy(out, response.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && total >= 0 && offset+n != total {
+		err = fmt.Errorf("got %d of %d bytes: %w", offset+n, total, io.ErrUnexpectedEOF)
+	}
+	return n > 0, err
+}
+
+// parseContentRange parses a Content-Range header such as "bytes 100-199/1000"
+// or "bytes */1000" into the first byte and the complete size, which is -1
+// when unknown.
+func parseContentRange(header string) (start, size int64, ok bool) {
+	if !strings.HasPrefix(header, "bytes ") {
+		return 0, 0, false
+	}
+	byteRange, total, ok := strings.Cut(strings.TrimPrefix(header, "bytes "), "/")
+	if !ok {
+		return 0, 0, false
+	}
+	size = -1
+	if total != "*" {
+		var err error
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	if byteRange == "*" {
+		return 0, size, true
+	}
+	first, _, ok := strings.Cut(byteRange, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	return start, size, err == nil
+}
+
+func removePart(tmp string) {
+	os.Remove(tmp)
+	os.Remove(tmp + ".json")
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header      string
+		start, size int64
+		ok          bool
+	}{
+		{"bytes 100-199/1000", 100, 1000, true},
+		{"bytes 0-0/1", 0, 1, true},
+		{"bytes 100-199/*", 100, -1, true},
+		{"bytes */1000", 0, 1000, true},
+		{"bytes */*", 0, -1, true},
+		{"", 0, 0, false},
+		{"items 0-9/10", 0, 0, false},
+		{"bytes 100-199", 0, 0, false},
+		{"bytes 100/1000", 0, 0, false},
+		{"bytes x-199/1000", 0, 0, false},
+		{"bytes 100-199/many", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, size, ok := parseContentRange(tt.header)
+		if ok != tt.ok || ok && (start != tt.start || size != tt.size) {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v, want %d, %d, %v", tt.header, start, size, ok, tt.start, tt.size, tt.ok)
+		}
+	}
+}
+
+func TestPartialDownloadValidator(t *testing.T) {
+	const date = "Mon, 01 Jan 2024 00:00:00 GMT"
+	tests := []struct {
+		part partialDownload
+		want string
+	}{
+		{partialDownload{ETag: `"v1"`, LastModified: date}, `"v1"`},
+		{partialDownload{ETag: `W/"v1"`, LastModified: date}, date},
+		{partialDownload{ETag: `W/"v1"`}, ""},
+		{partialDownload{LastModified: date}, date},
+		{partialDownload{}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.part.validator(); got != tt.want {
+			t.Errorf("%+v.validator() = %q, want %q", tt.part, got, tt.want)
+		}
+	}
+}
+
+// TestDownloadPart resumes a download of content in the ways a server can
+// answer a range request.
+func TestDownloadPart(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	modified := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	date := modified.Format(http.TimeFormat)
+	// serve answers ranges like a server whose content has the ETag etag.
+	serve := func(etag string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if etag != "" {
+				w.Header().Set("ETag", etag)
+			}
+			http.ServeContent(w, r, "file", modified, bytes.NewReader(content))
+		}
+	}
+
+	tests := []struct {
+		name         string
+		part         string // the .part file, none if empty
+		etag, date   string // recorded with the part
+		handler      http.HandlerFunc
+		wantRange    string
+		wantIfRange  string
+		wantProgress bool
+		wantErr      bool
+		wantPart     string // the .part file afterwards, none if empty
+		wantETag     string // recorded with the part afterwards, not checked if empty
+	}{
+		{
+			name:         "fresh",
+			handler:      serve(`"v1"`),
+			wantProgress: true,
+			wantPart:     string(content),
+			wantETag:     `"v1"`,
+		},
+		{
+			// Without a strong ETag or a date the part cannot be told
+			// apart from changed content.
+			name:         "no validator",
+			part:         "01234XYZ",
+			etag:         `W/"v1"`,
+			handler:      serve(""),
+			wantProgress: true,
+			wantPart:     string(content),
+		},
+		{
+			name:         "resumed",
+			part:         "01234567",
+			etag:         `"v1"`,
+			handler:      serve(`"v1"`),
+			wantRange:    "bytes=8-",
+			wantIfRange:  `"v1"`,
+			wantProgress: true,
+			wantPart:     string(content),
+		},
+		{
+			name:         "resumed by date",
+			part:         "01234567",
+			etag:         `W/"v1"`,
+			date:         date,
+			handler:      serve(`W/"v1"`),
+			wantRange:    "bytes=8-",
+			wantIfRange:  date,
+			wantProgress: true,
+			wantPart:     string(content),
+		},
+		{
+			name:         "changed content restarts",
+			part:         "01234XYZ",
+			etag:         `"v0"`,
+			handler:      serve(`"v1"`),
+			wantRange:    "bytes=8-",
+			wantIfRange:  `"v0"`,
+			wantProgress: true,
+			wantPart:     string(content),
+			wantETag:     `"v1"`,
+		},
+		{
+			name: "resumed at the wrong offset",
+			part: "01234567",
+			etag: `"v1"`,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Range", "bytes 0-19/20")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(content)
+			},
+			wantRange:    "bytes=8-",
+			wantIfRange:  `"v1"`,
+			wantProgress: true,
+			wantErr:      true,
+		},
+		{
+			name:        "already complete",
+			part:        string(content),
+			etag:        `"v1"`,
+			handler:     serve(`"v1"`),
+			wantRange:   "bytes=20-",
+			wantIfRange: `"v1"`,
+			wantPart:    string(content),
+		},
+		{
+			name:         "longer than the content",
+			part:         string(content) + "klm",
+			etag:         `"v1"`,
+			handler:      serve(`"v1"`),
+			wantRange:    "bytes=23-",
+			wantIfRange:  `"v1"`,
+			wantProgress: true,
+			wantErr:      true,
+		},
+		{
+			name: "cut short",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "20")
+				w.Write(content[:8])
+			},
+			wantProgress: true,
+			wantErr:      true,
+			wantPart:     "01234567",
+		},
+		{
+			name:        "not found",
+			part:        "01234567",
+			etag:        `"v1"`,
+			handler:     http.NotFound,
+			wantRange:   "bytes=8-",
+			wantIfRange: `"v1"`,
+			wantErr:     true,
+			wantPart:    "01234567",
+		},
+	}
+	for _, tt := range tests {
+		var gotRange, gotIfRange string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotRange, gotIfRange = r.Header.Get("Range"), r.Header.Get("If-Range")
+			tt.handler(w, r)
+		}))
+		url := server.URL + "/file"
+		tmp := filepath.Join(t.TempDir(), "file.part")
+		if tt.part != "" {
+			if err := os.WriteFile(tmp, []byte(tt.part), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			meta := partialDownload{URL: url, ETag: tt.etag, LastModified: tt.date}
+			if err := writeJSONFile(tmp+".json", meta); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		progress, err := downloadPart(url, "", tmp)
+		server.Close()
+		if progress != tt.wantProgress || (err != nil) != tt.wantErr {
+			t.Errorf("%s: downloadPart = %v, %v, want %v and error %v", tt.name, progress, err, tt.wantProgress, tt.wantErr)
+		}
+		if gotRange != tt.wantRange || gotIfRange != tt.wantIfRange {
+			t.Errorf("%s: requested Range %q If-Range %q, want %q and %q", tt.name, gotRange, gotIfRange, tt.wantRange, tt.wantIfRange)
+		}
+		got, err := os.ReadFile(tmp)
+		if tt.wantPart == "" && !os.IsNotExist(err) {
+			t.Errorf("%s: the part %q was kept", tt.name, got)
+		} else if tt.wantPart != "" && string(got) != tt.wantPart {
+			t.Errorf("%s: the part is %q, want %q", tt.name, got, tt.wantPart)
+		}
+		var meta partialDownload
+		if tt.wantETag != "" && (readJSONFile(tmp+".json", &meta) != nil || meta.ETag != tt.wantETag) {
+			t.Errorf("%s: the part is recorded with ETag %q, want %q", tt.name, meta.ETag, tt.wantETag)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sagarishere/cloneAllGitea/events"
+)
+
+const progressEventInterval = time.Second
+
+// eventLog is the file of --events, nil without it.
+var eventLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// emitEvent sets the time of e and writes it to the event log as a line of
+// JSON.
+func emitEvent(e events.Event) {
+	eventLog.mu.Lock()
+	defer eventLog.mu.Unlock()
+	if eventLog.f == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	if line, err := json.Marshal(e); err == nil {
+		eventLog.f.Write(append(line, '\n'))
+	}
+}
+
+// emitProgress returns ctx set up to emit git's progress for repo, if events
+// are logged. Progress is emitted at most every progressEventInterval.
+func emitProgress(ctx context.Context, repo Repository) context.Context {
+	eventLog.mu.Lock()
+	logging := eventLog.f != nil
+	eventLog.mu.Unlock()
+	if !logging {
+		return ctx
+	}
+	var last time.Time
+	return withProgress(ctx, func(line string) {
+		if time.Since(last) < progressEventInterval {
+			return
+		}
+		last = time.Now()
+		emitEvent(events.Event{Type: events.Progress, Repository: repo.FullName, Detail: line})
+	})
+}
+
+// openEventLog writes every event to path as a line of JSON, so programs
+// driving cloneAllGitea can follow a run without parsing its output. path
+// can be a named pipe or /dev/fd/N.
+func openEventLog(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	eventLog.mu.Lock()
+	eventLog.f = f
+	eventLog.mu.Unlock()
+	return func() {
+		eventLog.mu.Lock()
+		defer eventLog.mu.Unlock()
+		eventLog.f = nil
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,54 @@
+// Package events has the lifecycle events of the repositories of a
+// cloneAllGitea run. A run started with --events writes them as JSON lines,
+// which applications running cloneAllGitea as a subprocess read with ReadLog.
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Types of events.
+const (
+	Discovered = "discovered"
+	Started    = "started"
+	Progress   = "progress"
+	Completed  = "completed"
+	Failed     = "failed"
+)
+
+// Event is one lifecycle event of a repository.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	Repository string    `json:"repository"`
+	// Detail is the action for completed and failed events and git's
+	// progress line for progress events.
+	Detail string `json:"detail,omitempty"`
+	Size   int64  `json:"size_bytes,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Class is the cause of a failure, e.g. auth, network or timeout.
+	Class string `json:"class,omitempty"`
+}
+
+// ReadLog calls fn with every event of a log written by --events, until r
+// ends, a line is not an event or fn returns an error.
+func ReadLog(r io.Reader, fn func(Event) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Failure classes, the cause of a failed repository as far as it can be told
+// from the error and git's output.
+const (
+	failureAuth       = "auth"
+	failureNetwork    = "network"
+	failureDisk       = "disk"
+	failureTimeout    = "timeout"
+	failureNotFound   = "repo-not-found"
+	failureCorruption = "git-corruption"
+	failureCanceled   = "canceled"
+	failureOther      = "other"
+)
+
+// failurePatterns map messages of git, curl, ssh and the API client to their
+// class. They are tried in order, so more specific ones come first: "Could not
+// read from remote repository" follows an authentication or a not found
+// message.
+var failurePatterns = []struct {
+	class    string
+	patterns []string
+}{
+	{failureAuth, []string{
+		"authentication failed", "could not read username", "could not read password",
+		"terminal prompts disabled", "permission denied (publickey", "host key verification failed",
+		"returned error: 401", "returned error: 403", "401 unauthorized", "403 forbidden",
+		"invalid credentials", "token is expired", "invalid token",
+	}},
+	{failureNotFound, []string{
+		"repository not found", "not found", "returned error: 404", "404 not found",
+		"does not appear to be a git repository", "does not exist",
+	}},
+	{failureDisk, []string{
+		"no space left on device", "disk quota exceeded", "read-only file system", "file name too long",
+		"cannot create directory", "could not create work tree", "unable to create", "unable to write",
+		"permission denied",
+	}},
+	{failureTimeout, []string{
+		"timed out", "timeout", "deadline exceeded",
+	}},
+	{failureNetwork, []string{
+		"could not resolve host", "failed to connect", "connection refused", "connection reset",
+		"no route to host", "network is unreachable", "the remote end hung up", "early eof",
+		"rpc failed", "ssl", "tls", "gnutls", "unexpected disconnect", "unable to access",
+		"502 bad gateway", "503 service unavailable", "504 gateway",
+	}},
+	{failureCorruption, []string{
+		"corrupt", "bad object", "missing blob", "missing tree", "missing commit", "broken link",
+		"index-pack failed", "invalid sha1", "bad index file", "unable to read", "fsck error",
+	}},
+}
+
+// classifyFailure returns the class of err, a repository's failure, given
+// the error output of the command that failed.
+func classifyFailure(err error, output string) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return failureTimeout
+	case errors.Is(err, context.Canceled):
+		return failureCanceled
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EROFS), errors.Is(err, os.ErrPermission):
+		return failureDisk
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return failureTimeout
+	case errors.As(err, &netErr):
+		return failureNetwork
+	}
+	message := strings.ToLower(err.Error() + "\n" + output)
+	for _, class := range failurePatterns {
+		for _, pattern := range class.patterns {
+			if strings.Contains(message, pattern) {
+				return class.class
+			}
+		}
+	}
+	return failureOther
+}
+
+// failureHints suggest what to do about a class of failures.
+var failureHints = map[string]string{
+	failureAuth:     "check that GITEA_ACCESS_TOKEN is valid and has not expired",
+	failureNotFound: "the repositories may have been deleted or renamed since they were listed",
+	failureDisk:     "check the free space and permissions of TARGET_DIR",
+	failureTimeout:  "large repositories may need a shorter history, see --shallow-since",
+}
+
+// printFailureClasses prints how many failures each class has, with a hint for
+// the classes that have one.
+func printFailureClasses(classes map[string]int) {
+	names := make([]string, 0, len(classes))
+	for class := range classes {
+		names = append(names, class)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if classes[names[i]] != classes[names[j]] {
+			return classes[names[i]] > classes[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	fmt.Println("Failures by cause:")
+	for _, class := range names {
+		if hint := failureHints[class]; hint != "" {
+			fmt.Printf("  %s: %d, %s\n", class, classes[class], hint)
+		} else {
+			fmt.Printf("  %s: %d\n", class, classes[class])
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// filterRepositories drops the repositories excluded by the client-side
+// filters of opts.
+func filterRepositories(repos []Repository, opts *options) []Repository {
+	kept := repos[:0]
+	for _, repo := range repos {
+		if opts.defaultBranch != "" && repo.DefaultBranch != opts.defaultBranch {
+			continue
+		}
+		if opts.skipMirrors && repo.Mirror {
+			continue
+		}
+		if len(opts.visibility) > 0 && !contains(opts.visibility, repo.visibility()) {
+			continue
+		}
+		if opts.minPermission != "" && repo.Permissions.level() < permissionLevels[opts.minPermission] {
+			continue
+		}
+		if !opts.shard.contains(repo) {
+			continue
+		}
+		if matchesAny(repo.FullName, opts.exclude) {
+			continue
+		}
+		kept = append(kept, repo)
+	}
+	return kept
+}
+
+// Visibilities of a repository. Internal repositories can be seen by every
+// signed-in user of the instance, but not anonymously.
+const (
+	visibilityPublic   = "public"
+	visibilityInternal = "internal"
+	visibilityPrivate  = "private"
+)
+
+func (repo Repository) visibility() string {
+	switch {
+	case repo.Private:
+		return visibilityPrivate
+	case repo.Internal:
+		return visibilityInternal
+	}
+	return visibilityPublic
+}
+
+// repoPermissions is the access of the token's user to a repository.
+type repoPermissions struct {
+	Admin bool `json:"admin"`
+	Push  bool `json:"push"`
+	Pull  bool `json:"pull"`
+}
+
+// permissionLevels ranks the values of --min-permission.
+var permissionLevels = map[string]int{"read": 1, "write": 2, "admin": 3}
+
+// level returns the rank of p in permissionLevels, 0 when the server did not
+// report permissions.
+func (p *repoPermissions) level() int {
+	switch {
+	case p == nil:
+		return 0
+	case p.Admin:
+		return permissionLevels["admin"]
+	case p.Push:
+		return permissionLevels["write"]
+	case p.Pull:
+		return permissionLevels["read"]
+	}
+	return 0
+}
+
+// dedupeRepositories drops repeated entries of the same repository, which
+// listings that overlap or shift between pages produce, so no two workers
+// clone into the same directory. Repositories are identified by their ID, or
+// by their full name in lists saved before IDs were recorded.
+func dedupeRepositories(repos []Repository) []Repository {
+	seen := make(map[string]bool, len(repos))
+	kept := repos[:0]
+	for _, repo := range repos {
+		key := strings.ToLower(repo.FullName)
+		if repo.ID != 0 {
+			key = fmt.Sprint(repo.ID)
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, repo)
+	}
+	return kept
+}
+
+// partialListing reports whether filters leave out repositories that still
+// exist on the server, in which case pruning would trash valid clones.
+func partialListing(opts *options) bool {
+	return opts.search != "" || opts.defaultBranch != "" || opts.maxTotalSize != "" || len(opts.exclude) > 0 || opts.skipMirrors || len(opts.visibility) > 0 || opts.minPermission != "" || opts.collaborations || opts.team != "" || opts.maxRepos > 0 || opts.shard.count > 1 || opts.reposFile != ""
+}
+
+// applySizeBudget orders repos by priority and keeps them until their total
+// size would exceed budget bytes. It returns the kept and the left out
+// repositories.
+func applySizeBudget(repos []Repository, budget int64, priority string) ([]Repository, []Repository, error) {
+	sorted := append([]Repository(nil), repos...)
+	switch priority {
+	case "smallest":
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Size < sorted[j].Size })
+	case "updated":
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].UpdatedAt.After(sorted[j].UpdatedAt) })
+	case "name":
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].FullName < sorted[j].FullName })
+	default:
+		return nil, nil, fmt.Errorf("unknown --size-priority %q, use smallest, updated or name", priority)
+	}
+
+	var kept, leftOut []Repository
+	var total int64
+	for _, repo := range sorted {
+		// The API reports sizes in KiB.
+		size := repo.Size * 1024
+		if total+size > budget {
+			leftOut = append(leftOut, repo)
+			continue
+		}
+		total += size
+		kept = append(kept, repo)
+	}
+	return kept, leftOut, nil
+}
+
+// orderRepositories sorts repos into the order they are handed to workers:
+// those matching an earlier pattern of priority first, then by order, which
+// is size-asc, size-desc, updated-desc, alpha or empty to keep the listing's
+// order.
+func orderRepositories(repos []Repository, order string, priority []string) error {
+	var less func(a, b Repository) bool
+	switch order {
+	case "":
+		less = func(a, b Repository) bool { return false }
+	case "size-asc":
+		less = func(a, b Repository) bool { return a.Size < b.Size }
+	case "size-desc":
+		less = func(a, b Repository) bool { return a.Size > b.Size }
+	case "updated-desc":
+		less = func(a, b Repository) bool { return a.UpdatedAt.After(b.UpdatedAt) }
+	case "alpha":
+		less = func(a, b Repository) bool { return strings.ToLower(a.FullName) < strings.ToLower(b.FullName) }
+	default:
+		return fmt.Errorf("unknown --order %q, use size-asc, size-desc, updated-desc or alpha", order)
+	}
+
+	rank := func(repo Repository) int {
+		for i, pattern := range priority {
+			if ok, _ := path.Match(pattern, repo.FullName); ok {
+				return i
+			}
+		}
+		return len(priority)
+	}
+	ranks := make(map[string]int, len(repos))
+	for _, repo := range repos {
+		ranks[repo.FullName] = rank(repo)
+	}
+	sort.SliceStable(repos, func(i, j int) bool {
+		if ri, rj := ranks[repos[i].FullName], ranks[repos[j].FullName]; ri != rj {
+			return ri < rj
+		}
+		return less(repos[i], repos[j])
+	})
+	return nil
+}
+
+// loadPriorityFile reads owner/name patterns, one per line, most important
+// first. Empty lines and lines starting with # are ignored.
+func loadPriorityFile(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := path.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid pattern %q", file, i+1, line)
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, nil
+}
+
+// matchesAny reports whether the owner/name of a repository matches one of the
+// glob patterns.
+func matchesAny(fullName string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, fullName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -1,3 +1,3 @@
 module github.com/sagarishere/cloneAllGitea
 
-go 1.18
+go 1.20
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// runGrep implements the grep subcommand: git grep in every clone, with
+// matches prefixed by the repository they were found in.
+func runGrep(args []string) error {
+	fs := flag.NewFlagSet("grep", flag.ExitOnError)
+	ignoreCase := fs.Bool("i", false, "Ignore case")
+	extended := fs.Bool("E", false, "Use extended regular expressions")
+	fixed := fs.Bool("F", false, "Match the pattern as a fixed string")
+	jobs := fs.Int("j", runtime.NumCPU(), "Number of repositories searched in parallel")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cloneAllGitea grep [flags] <pattern> [-- <pathspec>...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return errors.New("missing search pattern")
+	}
+
+	config, err := loadConfig("config.env")
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	root := config["TARGET_DIR"]
+
+	clones, err := findClones(root)
+	if err != nil {
+		return fmt.Errorf("scanning target directory: %w", err)
+	}
+
+	grepArgs := []string{"grep", "-n", "-I", "--no-color"}
+	if *ignoreCase {
+		grepArgs = append(grepArgs, "-i")
+	}
+	if *extended {
+		grepArgs = append(grepArgs, "-E")
+	}
+	if *fixed {
+		grepArgs = append(grepArgs, "-F")
+	}
+	grepArgs = append(grepArgs, "-e", fs.Arg(0))
+	if fs.NArg() > 1 {
+		grepArgs = append(grepArgs, "--")
+		grepArgs = append(grepArgs, fs.Args()[1:]...)
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	lim := newLimiter(*jobs)
+	for _, name := range clones {
+		lim.acquire()
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			defer lim.release()
+
+			cmd := gitCommand(append([]string{"-C", filepath.Join(root, name)}, grepArgs...)...)
+			out, err := cmd.Output()
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+				return
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: could not search %s: %v\n", name, err)
+				return
+			}
+
+			var b strings.Builder
+			for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+				b.WriteString(name + ":" + line + "\n")
+			}
+			mu.Lock()
+			fmt.Print(b.String())
+			mu.Unlock()
+		}(name)
+	}
+	wg.Wait()
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// repoHealth lists the problems found in one clone.
+type repoHealth struct {
+	Name         string `json:"name"`
+	LargestBlob  int64  `json:"largest_blob"`
+	LargeBlobs   int    `json:"large_blobs"`
+	DetachedHead bool   `json:"detached_head"`
+	// Pinned is the tag or commit --repos or --from-lockfile checked out,
+	// whose detached HEAD is no problem.
+	Pinned       string   `json:"pinned,omitempty"`
+	BehindAtSync int      `json:"behind_at_last_sync"`
+	Problems     []string `json:"problems"`
+}
+
+// runHealth implements the health subcommand, which scans the clones for very
+// large blobs, missing LFS objects, corruption, detached HEADs and branches
+// that were far behind their remote at the last sync.
+func runHealth(args []string) error {
+	fs := flag.NewFlagSet("health", flag.ExitOnError)
+	format := fs.String("format", "table", "Output format: table or json")
+	largeBlob := fs.String("large-blob", "50M", "Blobs larger than this are reported")
+	maxBehind := fs.Int("max-behind", 100, "Report checked out branches more than this many commits behind their remote branch as of the last sync")
+	all := fs.Bool("all", false, "List healthy repositories as well")
+	jobs := fs.Int("j", runtime.NumCPU(), "Number of repositories checked in parallel")
+	fs.Parse(args)
+
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format %q, expected table or json", *format)
+	}
+	threshold, err := parseSize(*largeBlob)
+	if err != nil {
+		return err
+	}
+
+	config, err := loadConfig("config.env")
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	root := config["TARGET_DIR"]
+	clones, err := findClones(root)
+	if err != nil {
+		return fmt.Errorf("scanning target directory: %w", err)
+	}
+
+	_, lfsErr := exec.LookPath("git-lfs")
+	report := make([]repoHealth, len(clones))
+	lim := newLimiter(*jobs)
+	var wg sync.WaitGroup
+	for i, name := range clones {
+		lim.acquire()
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			defer lim.release()
+			report[i] = checkHealth(filepath.Join(root, name), name, threshold, *maxBehind, lfsErr == nil)
+		}(i, name)
+	}
+	wg.Wait()
+
+	var shown []repoHealth
+	for _, h := range report {
+		if *all || len(h.Problems) > 0 {
+			shown = append(shown, h)
+		}
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(shown)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REPOSITORY\tLARGEST BLOB\tBEHIND AT SYNC\tPROBLEMS")
+	for _, h := range shown {
+		problems := strings.Join(h.Problems, "; ")
+		if problems == "" {
+			problems = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", h.Name, formatSize(h.LargestBlob), h.BehindAtSync, problems)
+	}
+	return w.Flush()
+}
+
+func checkHealth(dir, name string, largeBlob int64, maxBehind int, haveLFS bool) repoHealth {
+	ctx := context.Background()
+	h := repoHealth{Name: name, Problems: []string{}}
+
+	if out, err := commandCombinedOutput(ctx, gitCommand("-C", dir, "fsck", "--no-dangling", "--no-progress")); err != nil {
+		h.Problems = append(h.Problems, "corrupt: "+firstLine(string(out)))
+	}
+
+	if _, err := gitOutput(ctx, dir, "symbolic-ref", "-q", "HEAD"); err != nil {
+		h.DetachedHead = true
+		if h.Pinned, _ = gitOutput(ctx, dir, "config", "--get", pinnedKey); h.Pinned == "" {
+			h.Problems = append(h.Problems, "detached HEAD")
+		}
+	} else if behind, err := gitOutput(ctx, dir, "rev-list", "--count", "HEAD..@{u}"); err == nil {
+		// health does not fetch, so @{u} is the remote branch as of the
+		// last sync, not as it is on the server now.
+		h.BehindAtSync, _ = strconv.Atoi(behind)
+		if h.BehindAtSync > maxBehind {
+			h.Problems = append(h.Problems, fmt.Sprintf("%d commits behind remote at the last sync", h.BehindAtSync))
+		}
+	}
+
+	if err := scanBlobs(ctx, dir, largeBlob, &h); err != nil {
+		h.Problems = append(h.Problems, "could not scan objects: "+err.Error())
+	} else if h.LargeBlobs > 0 {
+		h.Problems = append(h.Problems, fmt.Sprintf("%d blob(s) larger than %s", h.LargeBlobs, formatSize(largeBlob)))
+	}
+
+	if usesLFS(dir) {
+		if !haveLFS {
+			h.Problems = append(h.Problems, "uses LFS but git-lfs is not installed")
+		} else if out, err := commandCombinedOutput(ctx, gitCommand("-C", dir, "lfs", "fsck")); err != nil {
+			h.Problems = append(h.Problems, "missing LFS objects: "+firstLine(string(out)))
+		}
+	}
+	return h
+}
+
+// scanBlobs records the largest blob of all objects in the clone and how many
+// exceed largeBlob.
+func scanBlobs(ctx context.Context, dir string, largeBlob int64, h *repoHealth) error {
+	cmd := gitCommand("-C", dir, "cat-file", "--batch-all-objects", "--batch-check=%(objecttype) %(objectsize)")
+	out, err := commandOutput(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		kind, size, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || kind != "blob" {
+			continue
+		}
+		n, _ := strconv.ParseInt(size, 10, 64)
+		if n > h.LargestBlob {
+			h.LargestBlob = n
+		}
+		if n > largeBlob {
+			h.LargeBlobs++
+		}
+	}
+	return scanner.Err()
+}
+
+func usesLFS(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, ".gitattributes"))
+	return err == nil && bytes.Contains(data, []byte("filter=lfs"))
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// runHistory is how many runs the state keeps.
+	runHistory = 200
+	// runGrowthTop is how many of the repositories that fetched the most a
+	// run record keeps.
+	runGrowthTop = 5
+)
+
+// runRecord holds the aggregates of one run for the history subcommand.
+type runRecord struct {
+	Started      time.Time `json:"started"`
+	Duration     float64   `json:"duration_seconds"`
+	Repositories int       `json:"repositories"`
+	Cloned       int       `json:"cloned"`
+	Synced       int       `json:"synced"`
+	Failed       int       `json:"failed"`
+	// Fetched is how much the object databases of the clones grew.
+	Fetched int64 `json:"fetched_bytes"`
+	// Growth has the repositories that fetched the most, largest first.
+	Growth []repoGrowth `json:"growth,omitempty"`
+}
+
+type repoGrowth struct {
+	Repository string  `json:"repository"`
+	Fetched    int64   `json:"fetched_bytes"`
+	Duration   float64 `json:"duration_seconds"`
+}
+
+// newRunRecord aggregates the results of a run.
+func newRunRecord(summary runSummary, results []Result) runRecord {
+	record := runRecord{
+		Started:      summary.Started,
+		Duration:     time.Since(summary.Started).Seconds(),
+		Repositories: summary.Repositories,
+		Failed:       summary.Failed,
+	}
+	for _, res := range results {
+		switch {
+		case res.Err != nil:
+			continue
+		case res.Action == "cloned":
+			record.Cloned++
+		case res.Action == "synced":
+			record.Synced++
+		}
+		record.Fetched += res.Fetched
+		if res.Fetched > 0 {
+			record.Growth = append(record.Growth, repoGrowth{res.RepoName, res.Fetched, res.Duration.Seconds()})
+		}
+	}
+	sort.Slice(record.Growth, func(i, j int) bool { return record.Growth[i].Fetched > record.Growth[j].Fetched })
+	if len(record.Growth) > runGrowthTop {
+		record.Growth = record.Growth[:runGrowthTop]
+	}
+	return record
+}
+
+// recordRun adds record to the state, which is written with the queue.
+func (q *workQueue) recordRun(record runRecord) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.state.Runs = append(q.state.Runs, record)
+	if len(q.state.Runs) > runHistory {
+		q.state.Runs = q.state.Runs[len(q.state.Runs)-runHistory:]
+	}
+	q.dirty = true
+}
+
+// objectsSize returns the size of the object database of the clone in dir,
+// or 0 when it cannot be determined.
+func objectsSize(ctx context.Context, dir string) int64 {
+	out, err := gitOutput(ctx, dir, "count-objects", "-v")
+	if err != nil {
+		return 0
+	}
+	var kib int64
+	for _, line := range strings.Split(out, "\n") {
+		key, value, _ := strings.Cut(line, ": ")
+		if key == "size" || key == "size-pack" {
+			n, _ := strconv.ParseInt(value, 10, 64)
+			kib += n
+		}
+	}
+	return kib * 1024
+}
+
+// runHistoryCommand implements the history subcommand, which shows the
+// recorded runs and how their duration and fetched bytes develop.
+func runHistoryCommand(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	last := fs.Int("n", 20, "Number of most recent runs to show")
+	format := fs.String("format", "table", "Output format: table or json")
+	fs.Parse(args)
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format %q, expected table or json", *format)
+	}
+
+	config, err := loadConfig("config.env")
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	// The shared stores key the state by the host as a run normalizes it.
+	giteaHost, err := parseHost(config["GITEA_HOST"])
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(config["STATE_STORE"], giteaHost, config["TARGET_DIR"])
+	if err != nil {
+		return err
+	}
+	state, err := store.Load()
+	if err != nil {
+		return err
+	}
+	if len(state.Runs) == 0 {
+		return fmt.Errorf("no runs recorded yet in %s", config["TARGET_DIR"])
+	}
+	runs := state.Runs
+	if *last > 0 && len(runs) > *last {
+		runs = runs[len(runs)-*last:]
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(runs)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tDURATION\tREPOS\tCLONED\tSYNCED\tFAILED\tFETCHED\tFETCHED MOST")
+	for _, r := range runs {
+		most := "-"
+		if len(r.Growth) > 0 {
+			most = fmt.Sprintf("%s (%s)", r.Growth[0].Repository, formatSize(r.Growth[0].Fetched))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\n", r.Started.Local().Format("2006-01-02 15:04"),
+			seconds(r.Duration), r.Repositories, r.Cloned, r.Synced, r.Failed, formatSize(r.Fetched), most)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	printTrends(runs)
+	return nil
+}
+
+// printTrends compares the older and the newer half of runs, and names the
+// repositories that keep fetching the most.
+func printTrends(runs []runRecord) {
+	if len(runs) < 4 {
+		return
+	}
+	older, newer := runs[:len(runs)/2], runs[len(runs)/2:]
+	average := func(runs []runRecord, value func(runRecord) float64) float64 {
+		var sum float64
+		for _, r := range runs {
+			sum += value(r)
+		}
+		return sum / float64(len(runs))
+	}
+	duration := func(r runRecord) float64 { return r.Duration }
+	fetched := func(r runRecord) float64 { return float64(r.Fetched) }
+	oldDuration, newDuration := average(older, duration), average(newer, duration)
+	oldFetched, newFetched := average(older, fetched), average(newer, fetched)
+	fmt.Println()
+	fmt.Printf("Duration: %s on average over the last %d runs, %s %s over the %d before\n",
+		seconds(newDuration), len(newer), change(oldDuration, newDuration), seconds(oldDuration), len(older))
+	fmt.Printf("Fetched: %s on average over the last %d runs, %s %s over the %d before\n",
+		formatSize(int64(newFetched)), len(newer), change(oldFetched, newFetched), formatSize(int64(oldFetched)), len(older))
+
+	// A repository among the largest fetchers of most runs grows faster than
+	// the others, e.g. because binaries or generated files are committed.
+	appearances := make(map[string]int)
+	totals := make(map[string]int64)
+	for _, r := range runs {
+		for _, g := range r.Growth {
+			appearances[g.Repository]++
+			totals[g.Repository] += g.Fetched
+		}
+	}
+	var growing []string
+	for name, n := range appearances {
+		if n >= 3 && n*2 >= len(runs) {
+			growing = append(growing, name)
+		}
+	}
+	sort.Slice(growing, func(i, j int) bool { return totals[growing[i]] > totals[growing[j]] })
+	for _, name := range growing {
+		fmt.Printf("%s was among the repositories fetching the most in %d of %d runs, %s in total\n", name, appearances[name], len(runs), formatSize(totals[name]))
+	}
+}
+
+func seconds(s float64) string {
+	d := time.Duration(s * float64(time.Second))
+	if d < time.Minute {
+		return d.Round(100 * time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+// change describes how now compares to before, e.g. "up 35% from", to be
+// followed by before.
+func change(before, now float64) string {
+	if before == 0 {
+		return "compared to"
+	}
+	percent := (now - before) / before * 100
+	switch {
+	case percent >= 1:
+		return fmt.Sprintf("up %.0f%% from", percent)
+	case percent <= -1:
+		return fmt.Sprintf("down %.0f%% from", -percent)
+	}
+	return "about the same as"
+}
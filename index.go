@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const (
+	// indexDir is the git repository --index-repo keeps the state of the
+	// mirror in.
+	indexDir = ".index"
+	// defaultIndexMessage is the template of the commit message of a run.
+	defaultIndexMessage = "Mirror run of {{.Started.Format \"2006-01-02 15:04\"}}: {{.Succeeded}} succeeded, {{.Failed}} failed"
+)
+
+// indexedRepo is an entry of the index's repositories.json.
+type indexedRepo struct {
+	Repository    string   `json:"repository"`
+	Path          string   `json:"path"`
+	DefaultBranch string   `json:"default_branch"`
+	Visibility    string   `json:"visibility"`
+	Toolchains    []string `json:"toolchains,omitempty"`
+	Fork          bool     `json:"fork,omitempty"`
+	Mirror        bool     `json:"mirror,omitempty"`
+	Size          int64    `json:"size_bytes"`
+	// Refs maps the branches and tags of the clone to their commits.
+	Refs map[string]string `json:"refs,omitempty"`
+}
+
+// parseIndexMessage parses the --index-message template, which is executed
+// with the runSummary of the run. It is tried on an empty summary, so that
+// mistakes show before the run rather than after it.
+func parseIndexMessage(text string) (*template.Template, error) {
+	tmpl, err := template.New("index-message").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return tmpl, tmpl.Execute(io.Discard, runSummary{})
+}
+
+// commitIndex writes the manifest of the mirrored repositories, a lockfile,
+// the run's report and summary and, with synced, its change report into the
+// index repository below the target directory and commits them, so the
+// history of the mirror itself can be browsed and diffed with git.
+func commitIndex(message *template.Template, giteaHost string, summary runSummary, results []Result, repos []Repository, synced bool, changed []*repoChanges) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err := os.Stat(filepath.Join(indexDir, ".git")); os.IsNotExist(err) {
+		if _, err := commandOutput(ctx, gitCommand("init", "--quiet", indexDir)); err != nil {
+			return fmt.Errorf("creating the index repository: %w", err)
+		}
+	}
+
+	toolchains := make(map[string][]string, len(results))
+	for _, res := range results {
+		toolchains[res.RepoName] = res.Toolchains
+	}
+	manifest := make([]indexedRepo, 0, len(repos))
+	for _, repo := range repos {
+		entry := indexedRepo{
+			Repository:    repo.FullName,
+			Path:          filepath.ToSlash(repoDir(repo)),
+			DefaultBranch: repo.DefaultBranch,
+			Visibility:    repo.visibility(),
+			Toolchains:    toolchains[repo.FullName],
+			Fork:          repo.Fork,
+			Mirror:        repo.Mirror,
+			Size:          repo.Size * 1024,
+		}
+		if refs, err := gitRefs(ctx, repoDir(repo)); err == nil && len(refs) > 0 {
+			entry.Refs = make(map[string]string, len(refs))
+			for ref, hash := range refs {
+				// Branches are recorded as the server names them.
+				if branch := strings.TrimPrefix(ref, branchRefs(repoDir(repo))); branch != ref {
+					ref = "refs/heads/" + branch
+				}
+				if !strings.HasSuffix(ref, "/HEAD") {
+					entry.Refs[ref] = hash
+				}
+			}
+		}
+		manifest = append(manifest, entry)
+	}
+	sort.Slice(manifest, func(i, j int) bool { return manifest[i].Repository < manifest[j].Repository })
+	if err := writeJSONFile(filepath.Join(indexDir, "repositories.json"), manifest); err != nil {
+		return err
+	}
+	if _, err := writeLockfile(filepath.Join(indexDir, "clone.lock"), giteaHost, summary.Shard, repos); err != nil {
+		return err
+	}
+	if err := writeJSONFile(filepath.Join(indexDir, "summary.json"), summary); err != nil {
+		return err
+	}
+	if err := writeReport(filepath.Join(indexDir, "report.json"), results); err != nil {
+		return err
+	}
+	if synced {
+		if err := writeChangeReport(filepath.Join(indexDir, "changes.md"), changed); err != nil {
+			return err
+		}
+	} else if err := os.Remove(filepath.Join(indexDir, "changes.md")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var text bytes.Buffer
+	if err := message.Execute(&text, summary); err != nil {
+		return fmt.Errorf("rendering --index-message: %w", err)
+	}
+	if _, err := gitOutput(ctx, indexDir, "add", "--all"); err != nil {
+		return err
+	}
+	// The identity is set for the commit only, so that it works without a
+	// configured user.
+	_, err := gitOutput(ctx, indexDir, "-c", "user.name=cloneAllGitea", "-c", "user.email=cloneAllGitea@localhost",
+		"commit", "--quiet", "--no-verify", "--allow-empty", "-m", text.String())
+	return err
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runInit implements the init subcommand, which asks for the server, the
+// token, the target directory and which repositories to mirror, checks each
+// answer against the server, and writes config.env.
+func runInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	output := fs.String("o", "config.env", "File to write the configuration to")
+	fs.Parse(args)
+
+	in := bufio.NewReader(os.Stdin)
+	if _, err := os.Stat(*output); err == nil {
+		overwrite, err := askYesNo(in, fmt.Sprintf("%s exists, overwrite it?", *output), false)
+		if err != nil || !overwrite {
+			return err
+		}
+	}
+
+	var host string
+	var server serverInfo
+	for {
+		answer, err := ask(in, "Gitea URL, e.g. https://gitea.example.com: ", "")
+		if err != nil {
+			return err
+		}
+		if host, err = parseHost(answer); err != nil {
+			fmt.Printf("  %v\n", err)
+			continue
+		}
+		if host, err = followHostRedirect(host); err != nil {
+			fmt.Printf("  %v\n", err)
+			continue
+		}
+		if server, err = fetchServerInfo(host, ""); err != nil {
+			fmt.Printf("  Cannot reach a Gitea server there: %v\n", err)
+			continue
+		}
+		fmt.Printf("  Found Gitea %s\n", server.Version)
+		break
+	}
+
+	var token string
+	var user giteaUser
+	for {
+		var err error
+		if token, err = askSecret(in, "Access token, empty for public repositories only: "); err != nil {
+			return err
+		}
+		if token == "" {
+			fmt.Println("  Without a token only public repositories are mirrored")
+			break
+		}
+		if user, err = fetchCurrentUser(context.Background(), host, token); err != nil {
+			fmt.Printf("  The server does not accept the token: %v\n", err)
+			continue
+		}
+		role := ""
+		if user.IsAdmin {
+			role = ", an administrator"
+		}
+		fmt.Printf("  Signed in as %s%s\n", user.Username, role)
+		break
+	}
+
+	var targetDir string
+	for {
+		answer, err := ask(in, "Directory to mirror into [./backup]: ", "./backup")
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(answer); err == nil && !info.IsDir() {
+			fmt.Printf("  %s is a file\n", answer)
+			continue
+		} else if os.IsNotExist(err) {
+			fmt.Printf("  %s will be created on the first run\n", answer)
+		}
+		targetDir = filepath.Clean(answer)
+		break
+	}
+
+	defaultFlags, err := askScope(in, host, token, user)
+	if err != nil {
+		return err
+	}
+	skipMirrors, err := askYesNo(in, "Leave out repositories the server mirrors from elsewhere?", false)
+	if err != nil {
+		return err
+	}
+	if skipMirrors {
+		defaultFlags = append(defaultFlags, "--skip-mirrors")
+	}
+
+	var b strings.Builder
+	b.WriteString("# written by init, see README.md for the other keys\n")
+	fmt.Fprintf(&b, "GITEA_HOST=%s\n", host)
+	if token != "" {
+		b.WriteString("# encrypt it with: go run . config encrypt\n")
+		fmt.Fprintf(&b, "GITEA_ACCESS_TOKEN=%s\n", token)
+	}
+	fmt.Fprintf(&b, "TARGET_DIR=%s\n", targetDir)
+	if len(defaultFlags) > 0 {
+		b.WriteString("# flags every run starts with, before those of the command line\n")
+		fmt.Fprintf(&b, "DEFAULT_FLAGS=%s\n", strings.Join(defaultFlags, " "))
+	}
+	if err := os.WriteFile(*output, []byte(b.String()), 0o600); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s, start mirroring with: go run .\n", *output)
+	return nil
+}
+
+// askScope asks which repositories to mirror and returns the flags that
+// select them.
+func askScope(in *bufio.Reader, host, token string, user giteaUser) ([]string, error) {
+	choices := []string{"every repository the token can see", "only the repositories the token's user owns", "the repositories of one organization"}
+	if token == "" {
+		choices = []string{"every public repository", "", "the public repositories of one organization"}
+	}
+	if user.IsAdmin {
+		choices = append(choices, "every repository on the instance, including those of other users")
+	}
+	fmt.Println("Which repositories should be mirrored?")
+	for i, choice := range choices {
+		if choice != "" {
+			fmt.Printf("  %d) %s\n", i+1, choice)
+		}
+	}
+	for {
+		answer, err := ask(in, "Choice [1]: ", "1")
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case answer == "1":
+			return nil, nil
+		case answer == "2" && choices[1] != "":
+			return []string{"--onlyme"}, nil
+		case answer == "3":
+			return askOrg(in, host, token)
+		case answer == "4" && len(choices) == 4:
+			return []string{"--all"}, nil
+		}
+		fmt.Printf("  Enter one of the numbers above\n")
+	}
+}
+
+func askOrg(in *bufio.Reader, host, token string) ([]string, error) {
+	for {
+		org, err := ask(in, "Organization: ", "")
+		if err != nil {
+			return nil, err
+		}
+		if org == "" || strings.ContainsAny(org, " /") {
+			fmt.Println("  Enter the name of the organization as in its URL")
+			continue
+		}
+		var found struct {
+			Name string `json:"username"`
+		}
+		if err := getJSON(host+"/api/v1/orgs/"+url.PathEscape(org), token, &found); isNotFound(err) {
+			fmt.Printf("  The server has no organization %s that the token can see\n", org)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return []string{"--org=" + org}, nil
+	}
+}
+
+// ask reads a line after prompt, returning def for an empty one.
+func ask(in *bufio.Reader, prompt, def string) (string, error) {
+	fmt.Print(prompt)
+	line, err := in.ReadString('\n')
+	if err == io.EOF && line == "" {
+		fmt.Println()
+		return "", errors.New("init needs answers on standard input")
+	}
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+func askYesNo(in *bufio.Reader, question string, def bool) (bool, error) {
+	hint := " [y/N] "
+	if def {
+		hint = " [Y/n] "
+	}
+	for {
+		answer, err := ask(in, question+hint, "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// askSecret reads a line without echoing it when standard input is a
+// terminal.
+func askSecret(in *bufio.Reader, prompt string) (string, error) {
+	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		if _, err := stty("-echo"); err == nil {
+			defer func() {
+				stty("echo")
+				fmt.Println()
+			}()
+		}
+	}
+	return ask(in, prompt, "")
+}
+
+// defaultFlags returns the DEFAULT_FLAGS of the config file at path, split at
+// white space. The file is read for this key only, so that a run does not ask
+// for the passphrase of encrypted values before the flags are parsed.
+func defaultFlags(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	for _, line := range strings.Split(strings.TrimPrefix(string(data), utf8BOM), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(stripYAMLComment(line)), "=")
+		if ok && strings.TrimSpace(key) == "DEFAULT_FLAGS" {
+			return strings.Fields(unquoteYAML(strings.TrimSpace(value)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const issuesDir = ".issues"
+
+type giteaLabel struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Color       string `json:"color"`
+	Description string `json:"description"`
+	Exclusive   bool   `json:"exclusive"`
+}
+
+type giteaMilestone struct {
+	ID          int64      `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	State       string     `json:"state"`
+	DueOn       *time.Time `json:"due_on"`
+}
+
+type issueComment struct {
+	ID        int64     `json:"id"`
+	Body      string    `json:"body"`
+	User      giteaUser `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type giteaIssue struct {
+	Number    int64           `json:"number"`
+	Title     string          `json:"title"`
+	Body      string          `json:"body"`
+	State     string          `json:"state"`
+	User      giteaUser       `json:"user"`
+	Labels    []giteaLabel    `json:"labels"`
+	Milestone *giteaMilestone `json:"milestone"`
+	CreatedAt time.Time       `json:"created_at"`
+	ClosedAt  *time.Time      `json:"closed_at"`
+	HTMLURL   string          `json:"html_url"`
+}
+
+// exportedIssue is an issue together with its comment thread, as stored in
+// .issues/issues.json.
+type exportedIssue struct {
+	giteaIssue
+	CommentList []issueComment `json:"comment_list"`
+}
+
+// exportIssues writes all issues of repo and their comments to
+// owner/name/.issues/issues.json and, if markdown is set, one readable
+// Markdown file per issue next to it.
+func exportIssues(giteaHost, giteaAccessToken string, repo Repository, markdown bool) error {
+	base := repoAPIURL(giteaHost, repo)
+	issues, err := getAllPages[giteaIssue](base+"/issues?state=all&type=issues", giteaAccessToken)
+	if err != nil {
+		return err
+	}
+
+	exported := make([]exportedIssue, 0, len(issues))
+	for _, issue := range issues {
+		// Gitea returns all comments of an issue at once, the endpoint has
+		// no pages.
+		var comments []issueComment
+		if err := getJSON(fmt.Sprintf("%s/issues/%d/comments", base, issue.Number), giteaAccessToken, &comments); err != nil {
+			return fmt.Errorf("fetching comments of #%d: %w", issue.Number, err)
+		}
+		exported = append(exported, exportedIssue{giteaIssue: issue, CommentList: comments})
+	}
+
+	dir := filepath.Join(repoDir(repo), issuesDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	if err := excludeFromGit(repoDir(repo), "/"+issuesDir+"/"); err != nil {
+		return err
+	}
+	if err := writeJSONFile(filepath.Join(dir, "issues.json"), exported); err != nil {
+		return err
+	}
+
+	if markdown {
+		for _, issue := range exported {
+			path := filepath.Join(dir, fmt.Sprintf("%d.md", issue.Number))
+			if err := os.WriteFile(path, []byte(renderIssueMarkdown(issue)), 0o644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func renderIssueMarkdown(issue exportedIssue) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# #%d %s\n\n", issue.Number, issue.Title)
+	fmt.Fprintf(&b, "- State: %s\n", issue.State)
+	fmt.Fprintf(&b, "- Author: @%s\n", issue.User.Username)
+	fmt.Fprintf(&b, "- Created: %s\n", issue.CreatedAt.Format(time.RFC1123))
+	if issue.ClosedAt != nil {
+		fmt.Fprintf(&b, "- Closed: %s\n", issue.ClosedAt.Format(time.RFC1123))
+	}
+	if len(issue.Labels) > 0 {
+		names := make([]string, len(issue.Labels))
+		for i, label := range issue.Labels {
+			names[i] = label.Name
+		}
+		fmt.Fprintf(&b, "- Labels: %s\n", strings.Join(names, ", "))
+	}
+	if issue.Milestone != nil {
+		fmt.Fprintf(&b, "- Milestone: %s\n", issue.Milestone.Title)
+	}
+	if issue.HTMLURL != "" {
+		fmt.Fprintf(&b, "- URL: %s\n", issue.HTMLURL)
+	}
+	if issue.Body != "" {
+		fmt.Fprintf(&b, "\n%s\n", issue.Body)
+	}
+	for _, comment := range issue.CommentList {
+		fmt.Fprintf(&b, "\n---\n\n**@%s** commented on %s:\n\n%s\n", comment.User.Username, comment.CreatedAt.Format(time.RFC1123), comment.Body)
+	}
+	return b.String()
+}
+
+func repoAPIURL(giteaHost string, repo Repository) string {
+	owner, name, _ := strings.Cut(repo.FullName, "/")
+	return fmt.Sprintf("%s/api/v1/repos/%s/%s", giteaHost, url.PathEscape(owner), url.PathEscape(name))
+}
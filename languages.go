@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// extensionLanguages is the small table used by local language detection.
+var extensionLanguages = map[string]string{
+	".go": "Go", ".py": "Python", ".js": "JavaScript", ".mjs": "JavaScript", ".jsx": "JavaScript",
+	".ts": "TypeScript", ".tsx": "TypeScript", ".rs": "Rust", ".java": "Java", ".kt": "Kotlin",
+	".c": "C", ".h": "C", ".cc": "C++", ".cpp": "C++", ".hpp": "C++", ".cs": "C#",
+	".rb": "Ruby", ".php": "PHP", ".swift": "Swift", ".scala": "Scala", ".sh": "Shell",
+	".bash": "Shell", ".html": "HTML", ".css": "CSS", ".scss": "SCSS", ".vue": "Vue",
+	".dart": "Dart", ".lua": "Lua", ".sql": "SQL", ".hs": "Haskell", ".ex": "Elixir",
+	".exs": "Elixir", ".erl": "Erlang", ".clj": "Clojure", ".r": "R", ".jl": "Julia",
+	".pl": "Perl", ".zig": "Zig", ".nim": "Nim", ".ml": "OCaml", ".tf": "HCL",
+}
+
+type languageTotal struct {
+	Language     string  `json:"language"`
+	Bytes        int64   `json:"bytes"`
+	Percent      float64 `json:"percent"`
+	Repositories int     `json:"repositories"`
+}
+
+// runLanguages implements the languages subcommand.
+func runLanguages(args []string) error {
+	fs := flag.NewFlagSet("languages", flag.ExitOnError)
+	format := fs.String("format", "table", "Output format: table or json")
+	local := fs.Bool("local", false, "Detect languages from file extensions in the clones instead of asking the Gitea API")
+	fs.Parse(args)
+
+	config, err := loadConfig("config.env")
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	root := config["TARGET_DIR"]
+	var giteaHost string
+	if !*local {
+		if giteaHost, err = parseHost(config["GITEA_HOST"]); err != nil {
+			return err
+		}
+	}
+
+	clones, err := findClones(root)
+	if err != nil {
+		return fmt.Errorf("scanning target directory: %w", err)
+	}
+
+	perRepo := make(map[string]map[string]int64)
+	for _, name := range clones {
+		var langs map[string]int64
+		if *local {
+			langs, err = detectLanguages(filepath.Join(root, name))
+		} else {
+			err = getJSON(fmt.Sprintf("%s/api/v1/repos/%s/languages", giteaHost, name), config["GITEA_ACCESS_TOKEN"], &langs)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not determine languages of %s: %v\n", name, err)
+			continue
+		}
+		perRepo[name] = langs
+	}
+
+	totals := aggregateLanguages(perRepo)
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(totals)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LANGUAGE\tBYTES\tPERCENT\tREPOSITORIES")
+	for _, t := range totals {
+		fmt.Fprintf(w, "%s\t%d\t%.1f%%\t%d\n", t.Language, t.Bytes, t.Percent, t.Repositories)
+	}
+	return w.Flush()
+}
+
+func aggregateLanguages(perRepo map[string]map[string]int64) []languageTotal {
+	byLanguage := make(map[string]*languageTotal)
+	var total int64
+	for _, langs := range perRepo {
+		for lang, n := range langs {
+			t, ok := byLanguage[lang]
+			if !ok {
+				t = &languageTotal{Language: lang}
+				byLanguage[lang] = t
+			}
+			t.Bytes += n
+			t.Repositories++
+			total += n
+		}
+	}
+
+	totals := make([]languageTotal, 0, len(byLanguage))
+	for _, t := range byLanguage {
+		if total > 0 {
+			t.Percent = float64(t.Bytes) * 100 / float64(total)
+		}
+		totals = append(totals, *t)
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Bytes > totals[j].Bytes })
+	return totals
+}
+
+// detectLanguages sums the sizes of the files tracked at HEAD by extension.
+func detectLanguages(dir string) (map[string]int64, error) {
+	out, err := gitOutput(context.Background(), dir, "ls-tree", "-r", "-l", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	langs := make(map[string]int64)
+	for _, line := range strings.Split(out, "\n") {
+		meta, file, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 4 || fields[1] != "blob" {
+			continue
+		}
+		lang, ok := extensionLanguages[strings.ToLower(path.Ext(file))]
+		if !ok {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			continue
+		}
+		langs[lang] += size
+	}
+	return langs, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	lockFile         = ".clonegitea/lock"
+	lockPollInterval = 5 * time.Second
+)
+
+var errLocked = errors.New("locked")
+
+// acquireLock takes the run lock in the target directory: an advisory lock
+// on the lock file, which the system releases when the process ends, however
+// it ends. If another process holds it, acquireLock fails unless wait is set,
+// in which case it polls until the lock is released. The file stays in place,
+// so that no run can lock a file another run just removed; it only names the
+// process holding the lock.
+func acquireLock(wait bool) (release func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(lockFile), os.ModePerm); err != nil {
+		return nil, err
+	}
+	announced := false
+	for {
+		f, err := tryLock()
+		if err == nil {
+			return func() { f.Close() }, nil
+		}
+		if !errors.Is(err, errLocked) {
+			return nil, err
+		}
+		if !wait {
+			return nil, fmt.Errorf("another run is in progress (%s); use --wait-lock to wait for it", lockOwner())
+		}
+		if !announced {
+			fmt.Printf("Waiting for another run to finish (%s)\n", lockOwner())
+			announced = true
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// tryLock locks the lock file and writes this process into it. The lock is
+// held until the returned file is closed.
+func tryLock() (*os.File, error) {
+	f, err := os.OpenFile(lockFile, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFileHandle(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(fmt.Sprintf("%d %s\n", os.Getpid(), time.Now().Format(time.RFC3339))), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func lockOwner() string {
+	data, err := os.ReadFile(lockFile)
+	if err != nil {
+		return "unknown process"
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return "unknown process"
+	}
+	return fmt.Sprintf("process %s, started %s", fields[0], fields[1])
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// lockfile pins the commit checked out in every clone, so the mirror as a
+// whole can be reproduced later.
+type lockfile struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Host        string    `json:"host"`
+	// Shard is the --shard the lockfile was written with. The lockfiles of
+	// all shards merge into one with the merge subcommand.
+	Shard        string       `json:"shard,omitempty"`
+	Repositories []lockedRepo `json:"repositories"`
+}
+
+type lockedRepo struct {
+	Repository string `json:"repository"`
+	CloneURL   string `json:"clone_url"`
+	Path       string `json:"path"`
+	// Branch is empty when the clone has a detached HEAD.
+	Branch string `json:"branch,omitempty"`
+	Commit string `json:"commit"`
+}
+
+// writeLockfile records the HEAD commit of the clone of every repository in
+// repos. Repositories without a usable clone are reported and left out.
+func writeLockfile(path, giteaHost, shard string, repos []Repository) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	lock := lockfile{GeneratedAt: time.Now().UTC(), Host: giteaHost, Shard: shard, Repositories: []lockedRepo{}}
+	for _, repo := range repos {
+		commit, err := gitOutput(ctx, repoDir(repo), "rev-parse", "--verify", "HEAD")
+		if err != nil {
+			fmt.Printf("Warning: not pinning %s, its clone has no commit checked out\n", repo.FullName)
+			continue
+		}
+		branch, _ := gitOutput(ctx, repoDir(repo), "symbolic-ref", "--quiet", "--short", "HEAD")
+		lock.Repositories = append(lock.Repositories, lockedRepo{
+			Repository: repo.FullName,
+			CloneURL:   repo.CloneURL,
+			Path:       repoDir(repo),
+			Branch:     branch,
+			Commit:     commit,
+		})
+	}
+	sort.Slice(lock.Repositories, func(i, j int) bool {
+		return lock.Repositories[i].Repository < lock.Repositories[j].Repository
+	})
+	return len(lock.Repositories), writeJSONFile(path, lock)
+}
+
+func loadLockfile(path string) (*lockfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lock lockfile
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &lock, nil
+}
+
+// checkoutLockfile clones or fetches every repository of the lockfile at path
+// and checks out its pinned commit with a detached HEAD. It returns the number
+// of repositories that could not be checked out.
+func checkoutLockfile(path string, jobs int) (int, error) {
+	lock, err := loadLockfile(path)
+	if err != nil {
+		return 0, err
+	}
+	fmt.Printf("Reproducing %d repositories from %s as of %s\n", len(lock.Repositories), path, lock.GeneratedAt.Format(time.RFC3339))
+
+	if jobs <= 0 {
+		jobs = defaultMaintenanceJobs
+	}
+	sem := make(chan struct{}, jobs)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := 0
+	for _, pinned := range lock.Repositories {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(pinned lockedRepo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := checkoutPinned(pinned); err != nil {
+				fmt.Printf("Error checking out %s at %.12s: %v\n", pinned.Repository, pinned.Commit, err)
+				mu.Lock()
+				failed++
+				mu.Unlock()
+				return
+			}
+			fmt.Printf("Checked out %s at %.12s\n", pinned.Repository, pinned.Commit)
+		}(pinned)
+	}
+	wg.Wait()
+	return failed, nil
+}
+
+func checkoutPinned(pinned lockedRepo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if _, err := os.Stat(pinned.Path); os.IsNotExist(err) {
+		if err := gitClone(ctx, pinned.CloneURL, pinned.Path, "--no-checkout"); err != nil {
+			return err
+		}
+	} else if err := renameRemote(ctx, pinned.Path); err != nil {
+		return err
+	} else if _, err := gitOutput(ctx, pinned.Path, "cat-file", "-e", pinned.Commit+"^{commit}"); err != nil {
+		args := append(append([]string{"fetch", "--tags"}, gitTransportArgs()...), remoteName)
+		_, err := gitOutput(ctx, pinned.Path, args...)
+		audit("fetch", pinned.Path, "", err)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := gitOutput(ctx, pinned.Path, "cat-file", "-e", pinned.Commit+"^{commit}"); err != nil {
+		// The commit is no longer reachable from any ref, ask for it directly.
+		args := append(append([]string{"fetch"}, gitTransportArgs()...), remoteName, pinned.Commit)
+		_, err := gitOutput(ctx, pinned.Path, args...)
+		audit("fetch", pinned.Path, pinned.Commit, err)
+		if err != nil {
+			return fmt.Errorf("commit not found on the server: %w", err)
+		}
+	}
+	if _, err := gitOutput(ctx, pinned.Path, "-c", "advice.detachedHead=false", "checkout", "--quiet", "--detach", pinned.Commit); err != nil {
+		return err
+	}
+	_, err := gitOutput(ctx, pinned.Path, "config", pinnedKey, pinned.Commit)
+	return err
+}
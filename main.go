@@ -3,42 +3,377 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
 	"time"
+
+	"github.com/sagarishere/cloneAllGitea/events"
 )
 
 const (
-	userReposEndpoint = "/api/v1/user/repos"
-	timeout           = 5 * time.Minute
-	userEndpoint      = "/api/v1/user"
+	userReposEndpoint   = "/api/v1/user/repos"
+	timeout             = 5 * time.Minute
+	userEndpoint        = "/api/v1/user"
+	searchReposEndpoint = "/api/v1/repos/search"
 )
 
 type Repository struct {
+	ID       int64  `json:"id"`
 	Name     string `json:"name"`
 	CloneURL string `json:"clone_url"`
+	SSHURL   string `json:"ssh_url"`
 	FullName string `json:"full_name"`
+	Size     int64  `json:"size"`
+	Private  bool   `json:"private"`
+	// Internal is set for repositories visible to every signed-in user, see
+	// visibility.
+	Internal bool `json:"internal"`
+	// Mirror is set for repositories Gitea itself mirrors from elsewhere.
+	Mirror bool `json:"mirror"`
+	// Archived is set for read-only repositories kept for reference.
+	Archived bool `json:"archived"`
+
+	DefaultBranch string    `json:"default_branch"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	Fork   bool `json:"fork"`
+	Parent *struct {
+		FullName string `json:"full_name"`
+	} `json:"parent,omitempty"`
+
+	// Permissions are those of the token's user; anonymous listings have none.
+	Permissions *repoPermissions `json:"permissions,omitempty"`
+
+	// Path is the local directory of the clone, see applyPathMap.
+	Path string `json:"-"`
+	// Topics are the repository's topics, for categories.yaml.
+	Topics []string `json:"topics,omitempty"`
+	// Category is the directory of the first matching category of
+	// categories.yaml, see categorize.
+	Category string `json:"-"`
+	// Snapshot is set for repositories stored as a tar.gz snapshot instead of
+	// a clone, see markSnapshots.
+	Snapshot bool `json:"-"`
+	// Ref is the branch, tag or commit the --repos file pins, if any.
+	Ref string `json:"ref,omitempty"`
+}
+
+// subcommands maps the first command line argument to a mode other than
+// cloning. Each receives the remaining arguments.
+var subcommands = map[string]func(args []string) error{
+	"stats":          runStats,
+	"languages":      runLanguages,
+	"grep":           runGrep,
+	"account-backup": runAccountBackup,
+	"restore":        runRestore,
+	"health":         runHealth,
+	"config":         runConfig,
+	"history":        runHistoryCommand,
+	"serve-mirror":   runServeMirror,
+	"merge":          runMerge,
+	"init":           runInit,
+}
+
+// options holds the command line flags and configuration of a clone run.
+type options struct {
+	onlyMe         bool
+	collaborations bool
+	users          []string
+	org            string
+	team           string
+	search         string
+	defaultBranch  string
+	skipMirrors    bool
+	minPermission  string
+	visibility     []string
+	shard          shard
+	maxTotalSize   string
+	pathsFile      string
+	reposFile      string
+	categoriesFile string
+	sizePriority   string
+	order          string
+	maxRepos       int
+	priorityFile   string
+	format         string
+	shallowSince   string
+	tagsOnly       []string
+	exclude        []string
+	pruneRefs      string
+	dirty          string
+	pullStrategy   string
+	reportFile     string
+	eventsFile     string
+	writeLockfile  string
+	indexRepo      bool
+	checksums      bool
+	indexMessage   string
+	archive        string
+	sharedObjects  bool
+	layout         string
+	bare           bool
+	dissociate     bool
+	archiveSum     bool
+	fromLockfile   string
+	gc             bool
+	maintenance    bool
+	ipFamily       string
+	resolveIP      string
+	httpMaxIdle    int
+	httpKeepAlive  time.Duration
+	http2          bool
+	requestTimeout time.Duration
+	concurrency    int
+	syncLimit      int
+	changedOnly    bool
+	snapArchived   bool
+	snapInactive   string
+	adaptive       bool
+	perOwner       int
+	noCache        bool
+	cached         bool
+	refresh        bool
+	all            bool
+	resume         bool
+	notify         bool
+	syncRepos      bool
+	changesFile    string
+	withPkgs       bool
+	withIssues     bool
+	issuesMarkdown bool
+	withPulls      bool
+	fetchPRRefs    bool
+	withPlanning   bool
+	withArtifacts  bool
+	withSettings   bool
+	prune          bool
+	quarantine     int
+	failFast       bool
+	maxFailures    int
+	breaker        float64
+	diskUsage      int
+	trashRetention string
+	waitLock       bool
+	daemon         bool
+	interval       time.Duration
+	deepVerify     string
+	listen         string
+	control        string
+	controlToken   string
+	tui            bool
+	verbose        bool
+	verifySigs     bool
+	ssh            bool
+	trustHostKeys  bool
+	sshKey         string
+	sshAgentOnly   bool
+
+	giteaHost        string
+	giteaAccessToken string
+	webhookURL       string
+	webhookFailures  bool
+	keyring          string
+	pathRules        []pathRule
+	categories       []category
+	s3               *s3Target
+	stop             context.Context
+}
+
+// runSummary describes the outcome of one run.
+type runSummary struct {
+	Started      time.Time `json:"started"`
+	Finished     time.Time `json:"finished"`
+	Repositories int       `json:"repositories"`
+	Succeeded    int       `json:"succeeded"`
+	Failed       int       `json:"failed"`
+	Failures     []string  `json:"failures,omitempty"`
+	// FailureClasses counts the failures by cause, see classifyFailure.
+	FailureClasses map[string]int `json:"failure_classes,omitempty"`
+	// Visibility counts the repositories by visibility.
+	Visibility map[string]int `json:"visibility,omitempty"`
+	// Shard is the --shard of the run, e.g. 2/4.
+	Shard string `json:"shard,omitempty"`
+	// Deferred is the number of repositories left for later by --max-repos.
+	Deferred int `json:"deferred,omitempty"`
+	// NotFastForward lists synced clones whose branch has diverged.
+	NotFastForward []string `json:"not_fast_forward,omitempty"`
+	// Results has the outcome of every repository, for the control API.
+	Results []reportRow `json:"-"`
 }
 
 type Result struct {
 	RepoName string
 	Err      error
+	Changes  *repoChanges
+	// Action is what was done: cloned, synced, bundled, downloaded or skipped.
+	Action   string
+	Duration time.Duration
+	Size     int64
+	// Output is the end of the error output of the git command that failed.
+	Output string
+	// Class is the cause of the failure, see classifyFailure.
+	Class string
+	// Visibility is public, internal or private.
+	Visibility string
+	// Fetched is how much the clone's object database grew.
+	Fetched int64
+	// Toolchains are those the clone's project files call for, see
+	// detectToolchains.
+	Toolchains []string
 }
 
 func main() {
-	var (
-		onlyMe bool
-		user   string
-	)
-	flag.BoolVar(&onlyMe, "onlyme", false, "Fetch repositories owned by the user only")
-	flag.StringVar(&user, "user", "", "Specify a username to fetch their repositories")
-	flag.Parse()
+	if runAskpass() {
+		return
+	}
+
+	// exitCode is set when the run fails; the deferred exit runs after all
+	// other deferred cleanup, such as releasing the lock.
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	if len(os.Args) > 1 {
+		if subcommand, ok := subcommands[os.Args[1]]; ok {
+			if err := subcommand(os.Args[2:]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	var opts options
+	flag.BoolVar(&opts.onlyMe, "onlyme", false, "Fetch repositories owned by the user only")
+	flag.BoolVar(&opts.collaborations, "collaborations", false, "Fetch only repositories the user collaborates on but does not own, neither personally nor through an organization")
+	flag.Func("user", "Fetch the repositories of this user; repeat it or separate names with commas for several users", func(value string) error {
+		opts.users = append(opts.users, splitList(value)...)
+		return nil
+	})
+	flag.StringVar(&opts.search, "search", "", "Only fetch repositories whose name or description contains this keyword")
+	flag.BoolVar(&opts.skipMirrors, "skip-mirrors", false, "Leave out repositories that the server mirrors from elsewhere")
+	flag.Func("visibility", "Only mirror repositories of these comma-separated visibilities: public, internal, private", func(value string) error {
+		for _, v := range splitList(value) {
+			if v != visibilityPublic && v != visibilityInternal && v != visibilityPrivate {
+				return fmt.Errorf("unknown visibility %q, use %s, %s or %s", v, visibilityPublic, visibilityInternal, visibilityPrivate)
+			}
+		}
+		opts.visibility = splitList(value)
+		return nil
+	})
+	flag.Func("shard", "Mirror only part i of n of the repositories, e.g. 2/4, so that n machines can each mirror a disjoint share", func(value string) (err error) {
+		opts.shard, err = parseShard(value)
+		return err
+	})
+	flag.StringVar(&opts.minPermission, "min-permission", "", "Only mirror repositories the token's user has at least this permission on: read, write or admin")
+	flag.StringVar(&opts.defaultBranch, "default-branch", "", "Only fetch repositories whose default branch has this name, e.g. master")
+	flag.StringVar(&opts.maxTotalSize, "max-total-size", "", "Stop scheduling repositories once their total size would exceed this, e.g. 200G")
+	flag.StringVar(&opts.sizePriority, "size-priority", "smallest", "Which repositories to keep first under --max-total-size: smallest, updated or name")
+	flag.StringVar(&opts.order, "order", "", "Order to clone repositories in: size-asc, size-desc, updated-desc or alpha (default is the server's order)")
+	flag.IntVar(&opts.maxRepos, "max-repos", 0, "Process at most this many repositories, in the order of --order and --priority, and defer the rest")
+	flag.StringVar(&opts.priorityFile, "priority", "", "File of owner/name patterns, one per line, whose repositories are cloned first, in the file's order")
+	flag.StringVar(&opts.org, "org", "", "Fetch the repositories of this organization only")
+	flag.StringVar(&opts.team, "team", "", "With --org, fetch only the repositories assigned to this team of the organization")
+	flag.IntVar(&opts.concurrency, "concurrency", 0, "Maximum number of concurrent clones (0 means one per repository)")
+	flag.BoolVar(&opts.adaptive, "adaptive", false, "Adapt the number of concurrent clones to throughput and error rate")
+	flag.BoolVar(&opts.all, "all", false, "Clone every repository on the instance (requires an admin token)")
+	flag.BoolVar(&opts.snapArchived, "snapshot-archived", false, "Store archived repositories as a tar.gz snapshot of their default branch instead of cloning them")
+	flag.StringVar(&opts.snapInactive, "snapshot-inactive", "", "Store repositories not updated for this long, e.g. 730d, as a tar.gz snapshot of their default branch instead of cloning them")
+	flag.BoolVar(&opts.changedOnly, "changed-only", false, "With --sync, only fetch clones whose repository had activity on the server since their last sync")
+	flag.IntVar(&opts.syncLimit, "sync-concurrency", 0, "With --sync, maximum number of concurrent fetches of existing clones, which run before new repositories are cloned (0 means the same as --concurrency)")
+	flag.IntVar(&opts.perOwner, "owner-concurrency", 0, "Maximum number of concurrent clones per owner (0 means no limit)")
+	flag.BoolVar(&opts.resume, "resume", false, "Continue an interrupted run from its saved work queue")
+	flag.BoolVar(&opts.notify, "notify", false, "Show a desktop notification when the run finishes")
+	flag.BoolVar(&opts.failFast, "fail-fast", false, "Cancel all outstanding work on the first failed repository")
+	flag.Float64Var(&opts.breaker, "circuit-breaker", 0, "Pause the run when this fraction of the last 10 repositories failed with network or authentication errors, e.g. 0.5, and resume once the server and token work again")
+	flag.IntVar(&opts.maxFailures, "max-failures", 0, "Cancel all outstanding work once this many repositories failed, e.g. because the token expired")
+	flag.IntVar(&opts.diskUsage, "disk-usage", 0, "After the run, report the disk usage per owner and of this many largest repositories, and record it for trends")
+	flag.BoolVar(&opts.syncRepos, "sync", false, "Fetch and fast-forward repositories that were already cloned instead of skipping them")
+	flag.StringVar(&opts.pruneRefs, "prune-refs", "all", "With --sync, remove branches and tags deleted on the server: all, branches or none")
+	flag.BoolVar(&opts.gc, "gc", false, "Run git gc --auto in every repository after the run")
+	flag.BoolVar(&opts.maintenance, "maintenance", false, "Run git maintenance run in every repository after the run")
+	flag.StringVar(&opts.dirty, "dirty", dirtySkip, "With --sync, what to do with clones that have local modifications: skip, stash or reset")
+	flag.StringVar(&opts.pullStrategy, "pull-strategy", pullFFOnly, "With --sync, how to update branches: ff-only, rebase or merge")
+	flag.StringVar(&opts.reportFile, "report", "", "Write the result of every repository to this file, as CSV, HTML or JSON depending on its extension")
+	flag.BoolVar(&opts.bare, "bare", false, "Keep bare repositories at owner/name.git with the server's branches as local branches, to serve them from disk")
+	flag.StringVar(&opts.layout, "layout", layoutClone, "How to lay out git clones: clone (a normal clone) or worktree (a bare repository in .store with a linked worktree)")
+	flag.BoolVar(&opts.sharedObjects, "shared-objects", false, "Store the history of forks and their parents once, in .clonegitea/objects.git, through git alternates")
+	flag.BoolVar(&opts.dissociate, "dissociate", false, "With --shared-objects, copy the shared objects into every clone instead of borrowing them")
+	flag.StringVar(&opts.archive, "archive", "", "After cloning or syncing, also pack every repository as a bare repository into .archives: tar.zst or tar.gz")
+	flag.BoolVar(&opts.archiveSum, "archive-checksum", false, "With --archive, write a .sha256 file next to every archive")
+	flag.StringVar(&opts.writeLockfile, "write-lockfile", "", "Record the commit checked out in every clone into this file, e.g. clone.lock")
+	flag.StringVar(&opts.fromLockfile, "from-lockfile", "", "Instead of a normal run, clone or fetch every repository of this lockfile and check out its pinned commit")
+	flag.StringVar(&opts.eventsFile, "events", "", "Append repository lifecycle events to this file as JSON lines, e.g. /dev/fd/3")
+	flag.BoolVar(&opts.checksums, "checksums", false, "After cloning or syncing, write the SHA-256 of every tracked file of a clone into owner/name/.checksums/SHA256SUMS")
+	flag.BoolVar(&opts.indexRepo, "index-repo", false, "Commit the manifest, a lockfile and the reports of every run into the git repository TARGET_DIR/.index")
+	flag.StringVar(&opts.indexMessage, "index-message", defaultIndexMessage, "Go template of the --index-repo commit message, executed with the run summary")
+	flag.StringVar(&opts.changesFile, "changes-report", "", "Write a Markdown report of what changed in synced repositories to this file")
+	flag.BoolVar(&opts.withPkgs, "with-packages", false, "Also back up the package registry of every owner into .packages")
+	flag.BoolVar(&opts.withIssues, "with-issues", false, "Export the issues and their comments of every repository into owner/name/.issues")
+	flag.BoolVar(&opts.issuesMarkdown, "issues-markdown", false, "With --with-issues, also render every issue as a Markdown file")
+	flag.BoolVar(&opts.fetchPRRefs, "fetch-pr-refs", false, "Also fetch the head of every pull request into each clone, as remote branches pull/<number>")
+	flag.BoolVar(&opts.withPulls, "with-pulls", false, "Export pull requests with their reviews and diffs into owner/name/.pulls")
+	flag.BoolVar(&opts.withArtifacts, "with-artifacts", false, "Download the unexpired Actions artifacts and the recent workflow runs of every repository into owner/name/.artifacts")
+	flag.BoolVar(&opts.withSettings, "with-settings", false, "Export the settings, branch protections, webhooks and deploy keys of every repository into owner/name/.settings")
+	flag.BoolVar(&opts.withPlanning, "with-planning", false, "Export labels and milestones of every repository into owner/name/.planning")
+	flag.BoolVar(&opts.prune, "prune", false, "Move clones of repositories that no longer exist on the server into .trash")
+	flag.IntVar(&opts.quarantine, "quarantine-after", 0, "Move clones that matched no repository on the server for this many consecutive runs into .quarantine")
+	flag.StringVar(&opts.trashRetention, "trash-retention", "30d", "How long pruned clones are kept in .trash before they are deleted")
+	flag.BoolVar(&opts.waitLock, "wait-lock", false, "Wait for another run on the same target directory to finish instead of exiting")
+	flag.BoolVar(&opts.daemon, "daemon", false, "Keep running and repeat the run every --interval")
+	flag.DurationVar(&opts.interval, "interval", time.Hour, "Time between runs in daemon mode")
+	flag.StringVar(&opts.deepVerify, "deep-verify-interval", "", "In daemon mode with --sync, run git fsck on every clone and compare its refs with the server this often, e.g. 7d")
+	flag.BoolVar(&opts.verbose, "verbose", false, "Print the messages of git commands as they run, prefixed with the repository")
+	flag.BoolVar(&opts.tui, "tui", false, "Show a full-screen dashboard of the run, with keys to pause, resume and skip repositories")
+	flag.StringVar(&opts.listen, "listen", "", "Address to serve /healthz and /status on in daemon mode, e.g. :8080")
+	flag.StringVar(&opts.control, "control", "", "Address to serve the control API on in daemon mode, e.g. 127.0.0.1:8081")
+	flag.BoolVar(&opts.verifySigs, "verify-signatures", false, "Verify the signature of every default branch tip and report unsigned or badly signed ones")
+	flag.BoolVar(&opts.ssh, "ssh", false, "Clone over SSH instead of HTTPS, checking host keys against .clonegitea/known_hosts")
+	flag.BoolVar(&opts.trustHostKeys, "trust-host-keys", false, "With --ssh, add unknown server host keys to known_hosts without asking")
+	flag.StringVar(&opts.sshKey, "ssh-key", "", "Private key to clone with over SSH, implies --ssh (default SSH_KEY_FILE)")
+	flag.BoolVar(&opts.sshAgentOnly, "ssh-agent-only", false, "Authenticate over SSH with ssh-agent keys only, implies --ssh")
+	flag.StringVar(&opts.shallowSince, "shallow-since", "", "Only keep history after this date, e.g. 2023-01-01 (passed to git clone and fetch)")
+	flag.Func("tags-only", "Comma-separated owner/name patterns, e.g. 'deps/*', of repositories to fetch tags of but no branches", func(value string) error {
+		opts.tagsOnly = append(opts.tagsOnly, splitList(value)...)
+		return nil
+	})
+	flag.Func("exclude", "Comma-separated owner/name patterns, e.g. 'archive/*', of repositories to leave out", func(value string) error {
+		opts.exclude = append(opts.exclude, splitList(value)...)
+		return nil
+	})
+	flag.StringVar(&opts.pathsFile, "paths", defaultPathsFile, "YAML file mapping owner/name patterns to custom local paths")
+	flag.StringVar(&opts.categoriesFile, "categories", defaultCategoriesFile, "YAML file of category directories and the files, topics and names of the repositories that go into them")
+	flag.StringVar(&opts.reposFile, "repos", "", "Manage exactly the repositories of this YAML file of \"owner/name: ref\" lines instead of listing them, checking out the optional branch, tag or commit")
+	flag.StringVar(&opts.format, "format", formatGit, "How to back up repositories: git (clone), bundle (git bundles, incremental after the first run) or tar.gz (default branch snapshot without history)")
+	flag.StringVar(&opts.ipFamily, "ip-family", "", "Connect over IPv4 (4) or IPv6 (6) only")
+	flag.StringVar(&opts.resolveIP, "resolve", "", "Connect to GITEA_HOST at this IP address instead of resolving it (default GITEA_RESOLVE)")
+	flag.IntVar(&opts.httpMaxIdle, "http-max-idle", 32, "Maximum number of idle API connections kept open for reuse")
+	flag.DurationVar(&opts.httpKeepAlive, "http-keep-alive", 90*time.Second, "How long idle API connections are kept open, 0 disables keep-alive")
+	flag.BoolVar(&opts.http2, "http2", true, "Use HTTP/2 for the API when the server supports it")
+	flag.DurationVar(&opts.requestTimeout, "request-timeout", time.Minute, "Timeout of a single API request, 0 means none")
+	flag.DurationVar(&opts.requestTimeout, "api-timeout", time.Minute, "Same as --request-timeout")
+	flag.BoolVar(&opts.cached, "cached", false, "Reuse the repository list saved by the previous run instead of asking the API")
+	flag.BoolVar(&opts.refresh, "refresh", false, "Fetch the repository list again even with --cached")
+	flag.BoolVar(&opts.noCache, "no-cache", false, "Do not use or update the on-disk API response cache")
+	// The DEFAULT_FLAGS of the config come first, so that the command line
+	// overrides them.
+	flag.CommandLine.Parse(append(defaultFlags("config.env"), os.Args[1:]...))
 
 	config, err := loadConfig("config.env")
 	if err != nil {
@@ -46,8 +381,59 @@ func main() {
 		return
 	}
 
-	giteaHost := config["GITEA_HOST"]
-	giteaAccessToken := config["GITEA_ACCESS_TOKEN"]
+	opts.giteaHost, err = parseHost(config["GITEA_HOST"])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	opts.giteaAccessToken = config["GITEA_ACCESS_TOKEN"]
+	if name := config["REMOTE_NAME"]; name != "" {
+		if err := checkRemoteName(name); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		remoteName = name
+	}
+	configureTransport(opts.httpMaxIdle, opts.httpKeepAlive, opts.http2, opts.requestTimeout)
+	if err := configureNetwork(opts.giteaHost, opts.ipFamily, firstNonEmpty(opts.resolveIP, config["GITEA_RESOLVE"])); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	opts.webhookURL = config["NOTIFY_WEBHOOK_URL"]
+	opts.webhookFailures = config["NOTIFY_WEBHOOK_FAILURES"] == "true"
+	opts.controlToken = config["CONTROL_TOKEN"]
+	opts.keyring = config["SIGNATURE_KEYRING"]
+	if opts.sshKey != "" && opts.sshAgentOnly {
+		fmt.Println("Error: --ssh-key and --ssh-agent-only cannot be combined")
+		return
+	}
+	if opts.sshKey != "" || opts.sshAgentOnly {
+		opts.ssh = true
+	}
+	if opts.sshKey == "" && !opts.sshAgentOnly {
+		opts.sshKey = config["SSH_KEY_FILE"]
+	}
+	if opts.giteaAccessToken != "" && !opts.ssh {
+		if err := configureAskpass(opts.giteaHost, opts.giteaAccessToken); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+	}
+	opts.s3, err = loadS3Target(config)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	opts.pathRules, err = loadPathMap(opts.pathsFile, opts.pathsFile == defaultPathsFile)
+	if err != nil {
+		fmt.Printf("Error loading path mapping: %v\n", err)
+		return
+	}
+	opts.categories, err = loadCategories(opts.categoriesFile, opts.categoriesFile == defaultCategoriesFile)
+	if err != nil {
+		fmt.Printf("Error loading categories: %v\n", err)
+		return
+	}
 	targetDir := config["TARGET_DIR"]
 
 	if _, err := os.Stat(targetDir); os.IsNotExist(err) {
@@ -55,114 +441,1186 @@ func main() {
 		os.MkdirAll(targetDir, os.ModePerm)
 	}
 
+	if opts.changesFile != "" {
+		opts.changesFile, _ = filepath.Abs(opts.changesFile)
+	}
+	if opts.reportFile != "" {
+		opts.reportFile, _ = filepath.Abs(opts.reportFile)
+	}
+	if opts.eventsFile != "" {
+		opts.eventsFile, _ = filepath.Abs(opts.eventsFile)
+	}
+	if opts.writeLockfile != "" {
+		opts.writeLockfile, _ = filepath.Abs(opts.writeLockfile)
+	}
+	if opts.fromLockfile != "" {
+		opts.fromLockfile, _ = filepath.Abs(opts.fromLockfile)
+	}
+	if opts.keyring != "" {
+		opts.keyring, _ = filepath.Abs(opts.keyring)
+	}
+	if opts.priorityFile != "" {
+		opts.priorityFile, _ = filepath.Abs(opts.priorityFile)
+	}
+	if opts.reposFile != "" {
+		opts.reposFile, _ = filepath.Abs(opts.reposFile)
+	}
+	if opts.sshKey != "" {
+		opts.sshKey, _ = filepath.Abs(opts.sshKey)
+	}
+
 	os.Chdir(targetDir)
 
-	var username string
-	if onlyMe {
-		username, err = fetchUsername(giteaHost, giteaAccessToken)
+	releaseLock, err := acquireLock(opts.waitLock)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	defer releaseLock()
+
+	if stateStore, err = openStateStore(config["STATE_STORE"], opts.giteaHost, ""); err != nil {
+		fmt.Printf("Error opening state store: %v\n", err)
+		return
+	}
+
+	closeAudit, err := openAuditLog(".")
+	if err != nil {
+		fmt.Printf("Error opening audit log: %v\n", err)
+		return
+	}
+	defer closeAudit()
+
+	defer watchPauseSignals(dispatchPause)()
+
+	if opts.eventsFile != "" {
+		closeEvents, err := openEventLog(opts.eventsFile)
 		if err != nil {
-			fmt.Printf("Error fetching user details: %v\n", err)
+			fmt.Printf("Error opening event log: %v\n", err)
 			return
 		}
-	} else if user != "" {
-		username = user
+		defer closeEvents()
 	}
 
-	repos, err := fetchRepositories(giteaHost, giteaAccessToken, username, onlyMe || user != "")
+	if opts.daemon && opts.tui {
+		err = errors.New("--tui cannot be used with --daemon")
+	} else if opts.deepVerify != "" && !opts.daemon {
+		err = errors.New("--deep-verify-interval needs --daemon, use the health subcommand for a single check")
+	} else if opts.fromLockfile != "" {
+		var failed int
+		if failed, err = checkoutLockfile(opts.fromLockfile, opts.concurrency); err == nil && failed > 0 {
+			err = fmt.Errorf("%d repositories could not be checked out", failed)
+		}
+	} else if opts.daemon {
+		err = runDaemon(&opts)
+	} else {
+		_, err = run(&opts)
+	}
+	if errors.Is(err, errInterrupted) {
+		fmt.Println("Interrupted, continue with --resume")
+		exitCode = 130
+	} else if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		exitCode = 1
+	}
+}
+
+// errInterrupted is returned by a run stopped by an interrupt.
+var errInterrupted = errors.New("interrupted")
+
+// run performs one clone run: it lists the repositories, clones or syncs them
+// and runs the optional exports, returning what happened.
+func run(opts *options) (runSummary, error) {
+	summary := runSummary{Started: time.Now(), Shard: opts.shard.String()}
+
+	host, err := followHostRedirect(opts.giteaHost)
 	if err != nil {
-		fmt.Printf("Error fetching repositories: %v\n", err)
-		return
+		return summary, err
+	}
+	if host != opts.giteaHost && askpassEnv != nil {
+		if err := configureAskpass(host, opts.giteaAccessToken); err != nil {
+			return summary, err
+		}
+	}
+	opts.giteaHost = host
+	server, err := fetchServerInfo(opts.giteaHost, opts.giteaAccessToken)
+	if err != nil {
+		return summary, fmt.Errorf("checking Gitea server: %w", err)
+	}
+	fmt.Printf("Gitea server version %s\n", server.Version)
+
+	anonymous := opts.giteaAccessToken == ""
+	if anonymous {
+		if opts.onlyMe || opts.all || opts.collaborations {
+			return summary, errors.New("--onlyme, --all and --collaborations need an access token")
+		}
+		fmt.Println("No access token configured, mirroring public repositories only")
+	}
+
+	if opts.minPermission != "" {
+		if anonymous {
+			return summary, errors.New("--min-permission needs an access token")
+		}
+		if permissionLevels[opts.minPermission] == 0 {
+			return summary, fmt.Errorf("invalid --min-permission %q, use read, write or admin", opts.minPermission)
+		}
+	}
+
+	if opts.collaborations && (opts.onlyMe || opts.all || opts.org != "" || len(opts.users) > 0) {
+		return summary, errors.New("--collaborations cannot be combined with --onlyme, --all, --org or --user")
+	}
+
+	if opts.team != "" && (opts.org == "" || anonymous || opts.search != "") {
+		return summary, errors.New("--team needs --org and an access token, and cannot be combined with --search")
+	}
+
+	var allowlist []allowedRepo
+	if opts.reposFile != "" {
+		if opts.all || opts.onlyMe || opts.collaborations || opts.org != "" || opts.team != "" || opts.search != "" || len(opts.users) > 0 {
+			return summary, errors.New("--repos lists the repositories itself, it cannot be combined with --all, --onlyme, --collaborations, --org, --team, --search or --user")
+		}
+		if allowlist, err = loadAllowlist(opts.reposFile); err != nil {
+			return summary, fmt.Errorf("loading --repos: %w", err)
+		}
+	}
+
+	// interruptCtx is canceled on an interrupt or by q in the --tui
+	// dashboard. It stops listing the repositories, which otherwise is not
+	// noticed until the request in flight times out, and stops the clones
+	// like --fail-fast. A second interrupt ends the program at once.
+	stop := opts.stop
+	if stop == nil {
+		stop = context.Background()
+	}
+	interruptCtx, quit := context.WithCancel(stop)
+	defer quit()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	returned := make(chan struct{})
+	defer close(returned)
+	go func() {
+		select {
+		case <-signals:
+			quit()
+		case <-interruptCtx.Done():
+		case <-returned:
+			return
+		}
+		// The commands run in process groups of their own, which the
+		// terminal does not interrupt.
+		select {
+		case <-signals:
+			killRunningCommands()
+			fmt.Fprintln(os.Stderr, "Interrupted, continue with --resume")
+			os.Exit(130)
+		case <-returned:
+		}
+	}()
+
+	// accounts are the owners whose repositories are listed, none for
+	// everything the token can see.
+	var accounts []string
+	if opts.org != "" {
+		accounts = []string{opts.org}
+	} else if opts.onlyMe {
+		username, err := fetchUsername(interruptCtx, opts.giteaHost, opts.giteaAccessToken)
+		if err != nil {
+			return summary, fmt.Errorf("fetching user details: %w", err)
+		}
+		accounts = []string{username}
+	} else if len(opts.users) > 0 {
+		accounts = opts.users
+	}
+
+	if opts.format != formatGit && opts.format != formatBundle && opts.format != formatTarGz {
+		return summary, fmt.Errorf("unknown --format %q, use %s, %s or %s", opts.format, formatGit, formatBundle, formatTarGz)
+	}
+	if opts.archive != "" {
+		if opts.format != formatGit {
+			return summary, errors.New("--archive needs git clones, it cannot be combined with --format " + opts.format)
+		}
+		if err := checkArchiveFormat(opts.archive); err != nil {
+			return summary, err
+		}
+	}
+	if opts.layout != layoutClone && opts.layout != layoutWorktree {
+		return summary, fmt.Errorf("invalid --layout %q, use %s or %s", opts.layout, layoutClone, layoutWorktree)
+	}
+	if opts.layout == layoutWorktree && (opts.format != formatGit || len(opts.tagsOnly) > 0) {
+		return summary, errors.New("--layout worktree needs git clones with branches, it cannot be combined with --format " + opts.format + " or --tags-only")
+	}
+	if opts.bare && (opts.format != formatGit || opts.layout != layoutClone || len(opts.tagsOnly) > 0 || opts.archive != "" || opts.fetchPRRefs) {
+		return summary, errors.New("--bare cannot be combined with --format " + formatBundle + " or " + formatTarGz + ", --layout worktree, --tags-only, --archive or --fetch-pr-refs")
+	}
+	for _, entry := range allowlist {
+		if entry.ref != "" && (opts.format != formatGit || opts.layout != layoutClone || opts.bare) {
+			return summary, errors.New("the refs of --repos need git clones with a working tree, they cannot be combined with --format " + opts.format + ", --layout worktree or --bare")
+		}
+	}
+	if opts.sharedObjects && (opts.format != formatGit || opts.shallowSince != "") {
+		return summary, errors.New("--shared-objects needs full git clones, it cannot be combined with --format " + opts.format + " or --shallow-since")
+	}
+	if opts.fetchPRRefs && opts.format != formatGit {
+		return summary, errors.New("--fetch-pr-refs needs git clones, it cannot be combined with --format " + opts.format)
+	}
+	if opts.writeLockfile != "" && opts.format != formatGit {
+		return summary, errors.New("--write-lockfile needs git clones, it cannot be combined with --format " + opts.format)
+	}
+	var snapshotInactive time.Duration
+	if opts.snapInactive != "" {
+		if snapshotInactive, err = parseRetention(opts.snapInactive); err != nil {
+			return summary, fmt.Errorf("parsing --snapshot-inactive: %w", err)
+		}
+	}
+	if (opts.snapArchived || snapshotInactive > 0) && (opts.format != formatGit || opts.layout != layoutClone) {
+		return summary, errors.New("--snapshot-archived and --snapshot-inactive choose between clones and snapshots, they need --format git and cannot be combined with --layout worktree")
+	}
+	if opts.checksums && opts.format != formatGit {
+		return summary, errors.New("--checksums needs git clones, it cannot be combined with --format " + opts.format)
+	}
+	var indexMessage *template.Template
+	if opts.indexRepo {
+		if opts.format != formatGit {
+			return summary, errors.New("--index-repo needs git clones, it cannot be combined with --format " + opts.format)
+		}
+		if indexMessage, err = parseIndexMessage(opts.indexMessage); err != nil {
+			return summary, fmt.Errorf("parsing --index-message: %w", err)
+		}
 	}
 
-	fmt.Printf("Found %d repositories\n", len(repos))
+	var priority []string
+	if opts.priorityFile != "" {
+		if priority, err = loadPriorityFile(opts.priorityFile); err != nil {
+			return summary, fmt.Errorf("loading --priority: %w", err)
+		}
+	}
 
+	retention, err := parseRetention(opts.trashRetention)
+	if err != nil {
+		return summary, fmt.Errorf("parsing --trash-retention: %w", err)
+	}
+	if opts.withArtifacts && !server.atLeast(artifactsVersion) {
+		return summary, fmt.Errorf("--with-artifacts needs Gitea %s or later, the server runs %s", artifactsVersion, server.Version)
+	}
+	if opts.breaker < 0 || opts.breaker > 1 {
+		return summary, fmt.Errorf("invalid --circuit-breaker %v, use a fraction between 0 and 1", opts.breaker)
+	}
+	if opts.changedOnly && !opts.syncRepos {
+		return summary, errors.New("--changed-only needs --sync")
+	}
+	if opts.quarantine > 0 && (opts.prune || opts.resume || partialListing(opts)) {
+		return summary, errors.New("--quarantine-after cannot be combined with --prune, --resume or filters that leave out existing repositories")
+	}
+	if opts.prune && opts.resume {
+		return summary, errors.New("--prune cannot be combined with --resume, which only knows part of the repositories")
+	}
+	if opts.prune && partialListing(opts) {
+		return summary, errors.New("--prune cannot be combined with filters that leave out existing repositories")
+	}
+
+	state, err := loadState()
+	if err != nil {
+		return summary, fmt.Errorf("loading state: %w", err)
+	}
+
+	listKey := repoListKey(opts, accounts)
+	var cachedList *repoList
+	if opts.cached && !opts.refresh {
+		cachedList = loadRepoList(listKey)
+	}
+
+	var repos []Repository
+	if opts.resume {
+		if len(state.Queue) == 0 {
+			fmt.Println("No interrupted run to resume")
+			return summary, nil
+		}
+		fmt.Printf("Resuming interrupted run with %d queued repositories\n", len(state.Queue))
+		repos = state.Queue
+	} else if opts.reposFile != "" {
+		repos, err = fetchAllowedRepositories(interruptCtx, opts.giteaHost, opts.giteaAccessToken, opts.reposFile, allowlist)
+	} else if cachedList != nil {
+		fmt.Printf("Using repository list cached at %s, pass --refresh to fetch it again\n", cachedList.FetchedAt.Format(time.RFC3339))
+		repos = cachedList.Repos
+	} else if opts.all {
+		var currentUser giteaUser
+		currentUser, err = fetchCurrentUser(interruptCtx, opts.giteaHost, opts.giteaAccessToken)
+		if err != nil {
+			return summary, fmt.Errorf("fetching user details: %w", err)
+		}
+		if !currentUser.IsAdmin {
+			return summary, fmt.Errorf("the --all flag requires an admin token, but %s is not an admin", currentUser.Username)
+		}
+		repos, err = searchRepositories(interruptCtx, opts.giteaHost, opts.giteaAccessToken, 0, opts.search, !opts.noCache, server.MaxPageSize)
+	} else if opts.team != "" {
+		repos, err = fetchTeamRepositories(interruptCtx, opts.giteaHost, opts.giteaAccessToken, opts.org, opts.team, !opts.noCache, server.MaxPageSize)
+	} else if anonymous || opts.org != "" || opts.search != "" {
+		if len(accounts) == 0 {
+			repos, err = fetchOwnerRepositories(interruptCtx, opts.giteaHost, opts.giteaAccessToken, "", opts.search, !opts.noCache, server.MaxPageSize)
+		}
+		for _, owner := range accounts {
+			var ownerRepos []Repository
+			if ownerRepos, err = fetchOwnerRepositories(interruptCtx, opts.giteaHost, opts.giteaAccessToken, owner, opts.search, !opts.noCache, server.MaxPageSize); err != nil {
+				break
+			}
+			repos = append(repos, ownerRepos...)
+		}
+	} else if opts.collaborations {
+		repos, err = fetchCollaborations(interruptCtx, opts.giteaHost, opts.giteaAccessToken, !opts.noCache, server.MaxPageSize)
+	} else {
+		repos, err = fetchRepositories(interruptCtx, opts.giteaHost, opts.giteaAccessToken, accounts, !opts.noCache, server.MaxPageSize)
+	}
+	if err != nil {
+		return summary, fmt.Errorf("fetching repositories: %w", err)
+	}
+	repos = dedupeRepositories(repos)
+	if !opts.resume && cachedList == nil && opts.reposFile == "" {
+		if err := saveRepoList(listKey, repos); err != nil {
+			fmt.Printf("Warning: could not save repository list: %v\n", err)
+		}
+	}
+	if !opts.resume {
+		repos = filterRepositories(repos, opts)
+	}
+	if !opts.resume && opts.maxTotalSize != "" {
+		budget, err := parseSize(opts.maxTotalSize)
+		if err != nil {
+			return summary, fmt.Errorf("parsing --max-total-size: %w", err)
+		}
+		var leftOut []Repository
+		repos, leftOut, err = applySizeBudget(repos, budget, opts.sizePriority)
+		if err != nil {
+			return summary, err
+		}
+		if len(leftOut) > 0 {
+			fmt.Printf("Leaving out %d repositories to stay within %s:\n", len(leftOut), opts.maxTotalSize)
+			for _, repo := range leftOut {
+				fmt.Printf("  %s (%s)\n", repo.FullName, formatSize(repo.Size*1024))
+			}
+		}
+	}
+
+	if err := orderRepositories(repos, opts.order, priority); err != nil {
+		return summary, err
+	}
+	if opts.maxRepos > 0 && len(repos) > opts.maxRepos {
+		deferred := repos[opts.maxRepos:]
+		repos = repos[:opts.maxRepos]
+		summary.Deferred = len(deferred)
+		fmt.Printf("Deferring %d repositories beyond --max-repos %d:\n", len(deferred), opts.maxRepos)
+		for _, repo := range deferred {
+			fmt.Printf("  %s\n", repo.FullName)
+		}
+	}
+	if len(opts.categories) > 0 {
+		if err := categorize(opts.giteaHost, opts.giteaAccessToken, repos, opts.categories); err != nil {
+			return summary, err
+		}
+	}
+	applyPathMap(repos, opts.pathRules)
+	suffix := ""
+	if opts.bare {
+		suffix = bareSuffix
+		for i := range repos {
+			repos[i].Path += bareSuffix
+		}
+	}
+	if len(opts.categories) > 0 && opts.format == formatGit && opts.layout == layoutClone {
+		if err := relocateClones(repos, opts.categories, suffix); err != nil {
+			return summary, err
+		}
+	}
+	if opts.snapArchived || snapshotInactive > 0 {
+		if n := markSnapshots(repos, opts.snapArchived, snapshotInactive); n > 0 {
+			fmt.Printf("Storing %d archived or inactive repositories as tar.gz snapshots\n", n)
+		}
+	}
+	summary.Visibility = make(map[string]int)
+	for _, repo := range repos {
+		summary.Visibility[repo.visibility()]++
+	}
+	fmt.Printf("Found %d repositories (%d public, %d internal, %d private)\n", len(repos),
+		summary.Visibility[visibilityPublic], summary.Visibility[visibilityInternal], summary.Visibility[visibilityPrivate])
+	for _, repo := range repos {
+		emitEvent(events.Event{Type: events.Discovered, Repository: repo.FullName, Size: repo.Size * 1024})
+	}
+
+	if opts.ssh && len(repos) > 0 {
+		if repos[0].SSHURL == "" {
+			return summary, errors.New("the server does not report SSH clone URLs, is SSH disabled?")
+		}
+		if err := setupSSH(repos[0].SSHURL, opts.trustHostKeys, opts.sshKey, opts.sshAgentOnly); err != nil {
+			return summary, err
+		}
+	}
+
+	queue, err := newWorkQueue(state, repos)
+	if err != nil {
+		return summary, fmt.Errorf("saving work queue: %w", err)
+	}
+	defer queue.close()
+
+	prog := newProgress(repos)
 	resultsCh := make(chan Result, len(repos))
 	var wg sync.WaitGroup
 
-	for _, repo := range repos {
-		wg.Add(1)
-		go func(repo Repository) {
-			defer wg.Done()
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
-			defer cancel()
-
-			if _, err := os.Stat(repo.FullName); !os.IsNotExist(err) {
-				fmt.Printf("Repo %s already exists, skipping.\n", repo.FullName)
-				resultsCh <- Result{RepoName: repo.FullName, Err: nil}
-				return
+	lim := newLimiter(len(repos))
+	if opts.concurrency > 0 {
+		lim.setLimit(opts.concurrency)
+	}
+
+	var historyArgs []string
+	if opts.shallowSince != "" {
+		historyArgs = append(historyArgs, "--shallow-since="+opts.shallowSince)
+	}
+	syncOpts := syncOptions{fetchArgs: historyArgs, dirty: opts.dirty, pull: opts.pullStrategy}
+	switch opts.pruneRefs {
+	case "all":
+		syncOpts.fetchArgs = append(syncOpts.fetchArgs, "--prune", "--prune-tags")
+	case "branches":
+		syncOpts.fetchArgs = append(syncOpts.fetchArgs, "--prune")
+	case "none":
+	default:
+		return summary, fmt.Errorf("invalid --prune-refs %q, use all, branches or none", opts.pruneRefs)
+	}
+	if opts.dirty != dirtySkip && opts.dirty != dirtyStash && opts.dirty != dirtyReset {
+		return summary, fmt.Errorf("invalid --dirty %q, use %s, %s or %s", opts.dirty, dirtySkip, dirtyStash, dirtyReset)
+	}
+	if opts.pullStrategy != pullFFOnly && opts.pullStrategy != pullRebase && opts.pullStrategy != pullMerge {
+		return summary, fmt.Errorf("invalid --pull-strategy %q, use %s, %s or %s", opts.pullStrategy, pullFFOnly, pullRebase, pullMerge)
+	}
+
+	var dash *dashboard
+	if opts.tui {
+		if dash, err = startDashboard(repos, quit); err != nil {
+			return summary, err
+		}
+	}
+
+	shared := forkNetworks(repos)
+	owners := newOwnerLimiter(opts.perOwner)
+	// runCtx is canceled by --fail-fast, --max-failures, interrupts and
+	// opts.stop, which stops running clones and keeps the pending ones from
+	// starting.
+	runCtx, abort := context.WithCancel(interruptCtx)
+	defer abort()
+	var failures int32
+	var abortOnce sync.Once
+	aborted := ""
+	breaker := newCircuitBreaker(opts.breaker, opts.giteaHost, opts.giteaAccessToken, func(reason string) {
+		abortOnce.Do(func() {
+			aborted = reason
+			fmt.Printf("Error: %s, canceling outstanding work\n", aborted)
+			abort()
+		})
+	})
+
+	// With --sync, existing clones are fetched before the new repositories
+	// are cloned, each phase with its own limit: fetches are light, clones
+	// heavy.
+	clone := runPhase{repos: repos, lim: lim}
+	if opts.adaptive {
+		clone.tuner = newAdaptiveTuner(lim, opts.concurrency)
+	}
+	phases := []runPhase{clone}
+	if opts.syncRepos && opts.format == formatGit {
+		syncing := runPhase{lim: newLimiter(len(repos))}
+		syncLimit := opts.syncLimit
+		if syncLimit <= 0 {
+			syncLimit = opts.concurrency
+		}
+		if syncLimit > 0 {
+			syncing.lim.setLimit(syncLimit)
+		}
+		if opts.adaptive {
+			syncing.tuner = newAdaptiveTuner(syncing.lim, syncLimit)
+		}
+		phases = syncPhases(repos, syncing, clone)
+	}
+	for _, phase := range phases {
+		if phase.tuner != nil {
+			go phase.tuner.run()
+			defer phase.tuner.close()
+		}
+	}
+
+dispatch:
+	for _, phase := range phases {
+		var phaseWg sync.WaitGroup
+		pending := append([]Repository(nil), phase.repos...)
+		for len(pending) > 0 {
+			dash.waitIfPaused()
+			phase.lim.acquire()
+			// A pause that came while waiting for a free slot holds back
+			// the repository the slot was for.
+			dispatchPause.wait()
+			if runCtx.Err() != nil {
+				phase.lim.release()
+				break dispatch
 			}
+			i := owners.acquireAny(pending)
+			repo := pending[i]
+			pending = append(pending[:i], pending[i+1:]...)
+			wg.Add(1)
+			phaseWg.Add(1)
+			go func(repo Repository, lim *limiter, tuner *adaptiveTuner) {
+				defer wg.Done()
+				defer phaseWg.Done()
+				defer lim.release()
+				defer owners.release(repoOwner(repo))
+				// Another node sharing the state store may be on it already.
+				// When the store cannot be reached, working on a repository
+				// twice beats not working on it at all.
+				if claimed, err := stateStore.Claim(repo.FullName, claimTTL); err != nil {
+					fmt.Printf("Warning: cannot claim %s in the state store, mirroring it anyway: %v\n", repo.FullName, err)
+				} else if !claimed {
+					fmt.Printf("%s is being mirrored by another node, skipping\n", repo.FullName)
+					prog.skip(repo)
+					resultsCh <- Result{RepoName: repo.FullName, Action: "skipped", Visibility: repo.visibility()}
+					return
+				} else {
+					defer stateStore.Release(repo.FullName)
+				}
+				ctx, cancel := context.WithTimeout(runCtx, timeout)
+				defer cancel()
+				ctx = dash.begin(ctx, repo)
+				ctx = emitProgress(ctx, repo)
+				if opts.verbose {
+					ctx = withVerbose(ctx, repo.FullName)
+				}
+				emitEvent(events.Event{Type: events.Started, Repository: repo.FullName})
 
-			fmt.Printf("Cloning %s from %s\n", repo.Name, repo.CloneURL)
-			err := gitClone(ctx, repo.CloneURL, repo.FullName)
-			resultsCh <- Result{RepoName: repo.FullName, Err: err}
-		}(repo)
+				res := Result{RepoName: repo.FullName, Size: repo.Size * 1024, Visibility: repo.visibility()}
+				format := opts.format
+				if repo.Snapshot {
+					format = formatTarGz
+				}
+				started := time.Now()
+				path := repoDir(repo)
+				if format == formatTarGz {
+					path = archivePath(repo)
+				}
+				_, statErr := os.Stat(path)
+				exists := !os.IsNotExist(statErr)
+				var restoreExports func() error
+				if exists && format == formatGit && hasSnapshotOnly(repo) {
+					if restoreExports, res.Err = setAside(path); res.Err == nil {
+						exists = false
+					}
+				}
+				var sizeBefore int64
+				if exists && opts.syncRepos && format == formatGit {
+					sizeBefore = objectsSize(ctx, path)
+				}
+				// The activity is looked up before fetching, so that a push
+				// during the fetch is picked up by the next run.
+				var activity time.Time
+				unchanged := false
+				if opts.changedOnly && format == formatGit {
+					activity = latestActivity(opts.giteaHost, opts.giteaAccessToken, server, repo)
+					last, ok := queue.lastActivity(repo.FullName)
+					unchanged = exists && ok && !activity.After(last)
+				}
+				switch {
+				case format == formatBundle:
+					cloneURL := repo.CloneURL
+					if opts.ssh {
+						cloneURL = repo.SSHURL
+					}
+					res.Action = "bundled"
+					var bundle string
+					if bundle, res.Err = updateBundle(ctx, cloneURL, repo); res.Err == nil && bundle == "" {
+						fmt.Printf("No changes in %s since its last bundle\n", repo.FullName)
+						res.Action = "skipped"
+					} else if res.Err == nil {
+						fmt.Printf("Bundled %s into %s\n", repo.FullName, bundle)
+					}
+					prog.complete(repo)
+				case repo.Snapshot && exists && snapshotCurrent(repo):
+					fmt.Printf("No changes in %s since its snapshot\n", repo.FullName)
+					res.Action = "skipped"
+					prog.skip(repo)
+				case format == formatTarGz && (!exists || opts.syncRepos || repo.Snapshot):
+					fmt.Printf("Downloading %s snapshot of %s\n", repo.DefaultBranch, repo.FullName)
+					res.Action = "downloaded"
+					res.Err = downloadArchive(opts.giteaHost, opts.giteaAccessToken, repo)
+					prog.complete(repo)
+				case exists && opts.syncRepos && unchanged:
+					fmt.Printf("No activity in %s since its last sync, skipping\n", repo.FullName)
+					res.Action = "skipped"
+					prog.skip(repo)
+				case exists && opts.syncRepos:
+					fmt.Printf("Syncing %s\n", repo.FullName)
+					res.Action = "synced"
+					res.Changes, res.Err = gitSync(ctx, repoDir(repo), syncOpts)
+					if res.Changes != nil {
+						res.Changes.RepoName = repo.FullName
+					}
+					prog.skip(repo)
+				case exists:
+					fmt.Printf("Repo %s already exists, skipping.\n", repo.FullName)
+					res.Action = "skipped"
+					prog.skip(repo)
+				default:
+					cloneURL := repo.CloneURL
+					if opts.ssh {
+						cloneURL = repo.SSHURL
+					}
+					fmt.Printf("Cloning %s from %s\n", repo.Name, cloneURL)
+					res.Action = "cloned"
+					if matchesAny(repo.FullName, opts.tagsOnly) {
+						res.Err = gitCloneTags(ctx, cloneURL, repoDir(repo), historyArgs...)
+					} else {
+						cloneArgs := historyArgs
+						if opts.sharedObjects && shared[repo.FullName] {
+							if pool, err := fetchSharedObjects(ctx, cloneURL, repo); err != nil {
+								fmt.Printf("Warning: not sharing objects of %s: %v\n", repo.FullName, err)
+							} else if opts.dissociate {
+								cloneArgs = []string{"--reference-if-able", pool, "--dissociate"}
+							} else {
+								cloneArgs = []string{"--reference-if-able", pool}
+							}
+						}
+						if opts.layout == layoutWorktree {
+							res.Err = gitCloneWorktree(ctx, cloneURL, repo, cloneArgs...)
+						} else if opts.bare {
+							res.Err = gitCloneBare(ctx, cloneURL, repoDir(repo), cloneArgs...)
+						} else {
+							res.Err = gitClone(ctx, cloneURL, repoDir(repo), cloneArgs...)
+						}
+					}
+					// A repository that became active again leaves its
+					// snapshot behind.
+					if restoreExports != nil {
+						if err := restoreExports(); err != nil && res.Err == nil {
+							res.Err = err
+						}
+					}
+					if res.Err == nil {
+						if err := os.Remove(archivePath(repo)); err == nil {
+							fmt.Printf("Replaced the snapshot of %s with a clone\n", repo.FullName)
+						}
+					}
+					prog.complete(repo)
+				}
+				if res.Err == nil && (res.Action == "cloned" || res.Action == "synced") && !activity.IsZero() {
+					queue.recordActivity(repo.FullName, activity)
+				}
+				if res.Err == nil && (res.Action == "cloned" || res.Action == "synced") {
+					if res.Fetched = objectsSize(ctx, repoDir(repo)) - sizeBefore; res.Fetched < 0 {
+						res.Fetched = 0
+					}
+				}
+				if tuner != nil && (res.Action == "cloned" || res.Action == "synced") {
+					tuner.observe(res.Err, res.Fetched, time.Since(started))
+				}
+				if dash.finish(res) {
+					fmt.Printf("Skipped %s\n", repo.FullName)
+					if res.Action == "cloned" {
+						os.RemoveAll(repoDir(repo))
+					}
+					res.Action, res.Err, res.Duration = "skipped", nil, time.Since(started)
+					resultsCh <- res
+					return
+				}
+
+				runPostCloneSteps(ctx, &repoJob{opts: opts, server: server, repo: repo, format: format, res: &res})
+				if !errors.Is(res.Err, errNotFastForward) && runCtx.Err() == nil {
+					cloneURL := repo.CloneURL
+					if opts.ssh {
+						cloneURL = repo.SSHURL
+					}
+					breaker.observe(res.Err, cloneURL)
+				}
+				if res.Err == nil {
+					queue.complete(repo.FullName)
+				} else if !errors.Is(res.Err, errNotFastForward) && runCtx.Err() == nil {
+					n := int(atomic.AddInt32(&failures, 1))
+					if opts.failFast || (opts.maxFailures > 0 && n >= opts.maxFailures) {
+						abortOnce.Do(func() {
+							aborted = fmt.Sprintf("aborted after %d failure(s), the last in %s: %v", n, repo.FullName, res.Err)
+							fmt.Printf("Error: %s, canceling outstanding work\n", aborted)
+							abort()
+						})
+					}
+				}
+				res.Duration = time.Since(started)
+				resultsCh <- res
+			}(repo, phase.lim, phase.tuner)
+		}
+		phaseWg.Wait()
 	}
 
 	go func() {
 		wg.Wait()
+		dash.close()
 		close(resultsCh)
 	}()
 
+	summary.Repositories = len(repos)
+	var changed []*repoChanges
+	var results []Result
+	failed := make(map[string]bool)
 	for res := range resultsCh {
-		if res.Err != nil {
-			fmt.Printf("Error cloning repository %s: %v\n", res.RepoName, res.Err)
+		res.Output = commandStderr(res.Err)
+		if res.Err != nil && !errors.Is(res.Err, errNotFastForward) {
+			res.Class = classifyFailure(res.Err, res.Output)
+		}
+		results = append(results, res)
+		if res.Class != "" {
+			emitEvent(events.Event{Type: events.Failed, Repository: res.RepoName, Detail: res.Action, Error: res.Err.Error(), Class: res.Class})
+		} else {
+			emitEvent(events.Event{Type: events.Completed, Repository: res.RepoName, Detail: res.Action, Size: res.Size})
+		}
+		if res.Changes != nil && !res.Changes.empty() {
+			changed = append(changed, res.Changes)
+		}
+		if errors.Is(res.Err, errNotFastForward) {
+			summary.Succeeded++
+			summary.NotFastForward = append(summary.NotFastForward, res.RepoName)
+		} else if res.Err != nil {
+			summary.Failed++
+			summary.Failures = append(summary.Failures, res.RepoName)
+			if summary.FailureClasses == nil {
+				summary.FailureClasses = make(map[string]int)
+			}
+			summary.FailureClasses[res.Class]++
+			failed[res.RepoName] = true
+			fmt.Printf("Error processing repository %s (%s): %v\n", res.RepoName, res.Class, res.Err)
+			if opts.webhookURL != "" && opts.webhookFailures {
+				if err := postWebhook(opts.webhookURL, fmt.Sprintf("Failed to clone %s: %v", res.RepoName, res.Err)); err != nil {
+					fmt.Printf("Warning: could not post to webhook: %v\n", err)
+				}
+			}
+		} else {
+			summary.Succeeded++
 		}
 	}
-}
 
-func fetchRepositories(giteaHost, giteaAccessToken, username string, filterByUsername bool) ([]Repository, error) {
-	var allRepos []Repository
-	client := &http.Client{}
-	page := 1
-	for {
-		req, err := http.NewRequest("GET", fmt.Sprintf("%s%s?page=%d", giteaHost, userReposEndpoint, page), nil)
+	if len(summary.FailureClasses) > 0 {
+		printFailureClasses(summary.FailureClasses)
+	}
+
+	if len(summary.NotFastForward) > 0 {
+		sort.Strings(summary.NotFastForward)
+		fmt.Printf("Fetched but could not fast-forward %d repositories, their branch has diverged from the server:\n", len(summary.NotFastForward))
+		for _, name := range summary.NotFastForward {
+			fmt.Printf("  %s\n", name)
+		}
+	}
+
+	// The report also lists the clones that match no repository.
+	reported := results
+	if opts.quarantine > 0 {
+		stale, err := queue.quarantineStale(".", repos, accounts, opts.quarantine)
 		if err != nil {
-			return nil, err
+			summary.Failed++
+			fmt.Printf("Error quarantining stale clones: %v\n", err)
+		}
+		reported = append(reported, stale...)
+	}
+
+	summary.Results = reportRows(reported)
+	if opts.reportFile != "" {
+		if err := writeReport(opts.reportFile, reported); err != nil {
+			fmt.Printf("Warning: could not write report: %v\n", err)
 		}
+	}
 
-		req.Header.Add("Authorization", "token "+giteaAccessToken)
-		response, err := client.Do(req)
+	if opts.syncRepos {
+		sort.Slice(changed, func(i, j int) bool { return changed[i].RepoName < changed[j].RepoName })
+		for _, c := range changed {
+			fmt.Printf("Changed %s: %d new commit(s), %d new branch(es), %d new tag(s)\n", c.RepoName, c.NewCommits, len(c.NewBranches), len(c.NewTags))
+		}
+		if opts.changesFile != "" {
+			if err := writeChangeReport(opts.changesFile, changed); err != nil {
+				fmt.Printf("Warning: could not write change report: %v\n", err)
+			}
+		}
+	}
+
+	if opts.gc || opts.maintenance {
+		args := []string{"gc", "--auto"}
+		if opts.maintenance {
+			args = []string{"maintenance", "run"}
+		}
+		var dirs []string
+		for _, repo := range repos {
+			if !failed[repo.FullName] && !repo.Snapshot {
+				dirs = append(dirs, repoDir(repo))
+			}
+		}
+		fmt.Printf("Running git %s in %d repositories\n", args[0], len(dirs))
+		if n := runMaintenance(dirs, args, opts.concurrency); n > 0 {
+			fmt.Printf("git %s failed in %d repositories\n", args[0], n)
+		}
+	}
+
+	if opts.verifySigs {
+		var cloned []Repository
+		for _, repo := range repos {
+			if !failed[repo.FullName] && !repo.Snapshot {
+				cloned = append(cloned, repo)
+			}
+		}
+		unverified, err := verifySignatures(opts.giteaHost, opts.giteaAccessToken, opts.keyring, cloned)
 		if err != nil {
-			return nil, err
+			fmt.Printf("Error verifying signatures: %v\n", err)
 		}
-		defer response.Body.Close()
+		fmt.Printf("%d of %d branch tips have no valid signature, see %s\n", unverified, len(cloned), signatureReportFile)
+	}
 
-		if response.StatusCode != 200 {
-			return nil, fmt.Errorf("API request failed with HTTP status code: %d", response.StatusCode)
+	if opts.writeLockfile != "" {
+		var cloned []Repository
+		for _, repo := range repos {
+			if !failed[repo.FullName] && !repo.Snapshot {
+				cloned = append(cloned, repo)
+			}
 		}
+		if n, err := writeLockfile(opts.writeLockfile, opts.giteaHost, opts.shard.String(), cloned); err != nil {
+			fmt.Printf("Error writing lockfile: %v\n", err)
+		} else {
+			fmt.Printf("Pinned %d repositories in %s\n", n, opts.writeLockfile)
+		}
+	}
+
+	if opts.withPkgs {
+		owners := map[string]bool{}
+		if me, err := fetchUsername(context.Background(), opts.giteaHost, opts.giteaAccessToken); err == nil {
+			owners[me] = true
+		}
+		for _, repo := range repos {
+			owners[repoOwner(repo)] = true
+		}
+		for owner := range owners {
+			if err := backupPackages(opts.giteaHost, opts.giteaAccessToken, owner); err != nil {
+				summary.Failed++
+				fmt.Printf("Error backing up packages of %s: %v\n", owner, err)
+			}
+		}
+	}
 
-		body, err := io.ReadAll(response.Body)
+	if opts.prune {
+		pruned, err := pruneClones(".", repos, accounts)
+		for _, name := range pruned {
+			fmt.Printf("Moved %s to %s\n", name, trashDir)
+		}
 		if err != nil {
-			return nil, err
+			summary.Failed++
+			fmt.Printf("Error pruning: %v\n", err)
+		}
+		if err := emptyTrash(".", retention); err != nil {
+			fmt.Printf("Error emptying trash: %v\n", err)
 		}
+	}
+
+	if opts.diskUsage > 0 {
+		sample, usages := measureDiskUsage(repos)
+		var previous *diskUsageSample
+		if n := len(state.DiskUsage); n > 0 {
+			previous = &state.DiskUsage[n-1]
+		}
+		printDiskUsage(sample, usages, opts.diskUsage, previous)
+		queue.recordDiskUsage(sample)
+	}
+
+	summary.Finished = time.Now()
+	queue.recordRun(newRunRecord(summary, results))
+	if opts.indexRepo {
+		var mirrored []Repository
+		for _, repo := range repos {
+			if _, err := os.Stat(repoDir(repo)); err == nil {
+				mirrored = append(mirrored, repo)
+			}
+		}
+		if err := commitIndex(indexMessage, opts.giteaHost, summary, reported, mirrored, opts.syncRepos, changed); err != nil {
+			fmt.Printf("Error committing the index repository: %v\n", err)
+		}
+	}
+	if opts.s3 != nil {
+		if err := opts.s3.uploadBackups(repos, opts.archive, summary, !partialListing(opts) && !opts.resume, accounts); err != nil {
+			summary.Failed++
+			fmt.Printf("Error uploading to S3: %v\n", err)
+		}
+	}
+	counts := fmt.Sprintf("%d succeeded, %d failed", summary.Succeeded, summary.Failed)
+	if summary.Deferred > 0 {
+		counts += fmt.Sprintf(", %d deferred", summary.Deferred)
+	}
+	if notStarted := len(repos) - len(results); notStarted > 0 {
+		counts += fmt.Sprintf(", %d not started (continue with --resume)", notStarted)
+	}
+	fmt.Printf("Done: %s\n", counts)
+	if opts.webhookURL != "" {
+		if err := postWebhook(opts.webhookURL, fmt.Sprintf("Gitea backup of %s finished: %s", opts.giteaHost, counts)); err != nil {
+			fmt.Printf("Warning: could not post to webhook: %v\n", err)
+		}
+	}
+	if opts.notify {
+		if err := desktopNotify("Gitea backup finished", counts); err != nil {
+			fmt.Printf("Warning: could not show desktop notification: %v\n", err)
+		}
+	}
+
+	if aborted != "" {
+		return summary, errors.New(aborted)
+	}
+	if interruptCtx.Err() != nil && stop.Err() == nil {
+		return summary, errInterrupted
+	}
+	return summary, nil
+}
 
+// fetchRepositories lists the repositories visible to the token, limited to
+// those of owners if any are given.
+func fetchRepositories(ctx context.Context, giteaHost, giteaAccessToken string, owners []string, useCache bool, pageSize int) ([]Repository, error) {
+	pageURL := func(page int) string {
+		u := fmt.Sprintf("%s%s?page=%d", giteaHost, userReposEndpoint, page)
+		if pageSize > 0 {
+			u += fmt.Sprintf("&limit=%d", pageSize)
+		}
+		return u
+	}
+	repos, err := fetchRepositoryPages(ctx, pageURL, giteaAccessToken, useCache, func(body []byte) ([]Repository, error) {
 		var repos []Repository
 		json.Unmarshal(body, &repos)
+		return repos, nil
+	})
+	if err != nil || len(owners) == 0 {
+		return repos, err
+	}
 
-		if len(repos) == 0 {
-			break
+	var allRepos []Repository
+	for _, repo := range repos {
+		if contains(owners, repoOwner(repo)) {
+			allRepos = append(allRepos, repo)
 		}
+	}
+	return allRepos, nil
+}
 
-		if filterByUsername && username != "" {
-			for _, repo := range repos {
-				if strings.Split(repo.FullName, "/")[0] == username {
-					allRepos = append(allRepos, repo)
-				}
+// fetchCollaborations lists the repositories the token's user can see as a
+// collaborator: those neither owned by the user nor by one of their
+// organizations.
+func fetchCollaborations(ctx context.Context, giteaHost, giteaAccessToken string, useCache bool, pageSize int) ([]Repository, error) {
+	username, err := fetchUsername(ctx, giteaHost, giteaAccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("fetching user details: %w", err)
+	}
+	orgs, err := fetchOrganizations(ctx, giteaHost, giteaAccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("fetching organizations: %w", err)
+	}
+	repos, err := fetchRepositories(ctx, giteaHost, giteaAccessToken, nil, useCache, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	var collaborations []Repository
+	for _, repo := range repos {
+		if owner := repoOwner(repo); owner != username && !contains(orgs, owner) {
+			collaborations = append(collaborations, repo)
+		}
+	}
+	return collaborations, nil
+}
+
+// fetchOrganizations returns the names of the organizations the token's user
+// is a member of.
+func fetchOrganizations(ctx context.Context, giteaHost, giteaAccessToken string) ([]string, error) {
+	var names []string
+	for page := 1; ; page++ {
+		var orgs []struct {
+			Name string `json:"name"`
+			// Username is the name in Gitea versions before 1.20.
+			Username string `json:"username"`
+		}
+		if err := getJSONContext(ctx, fmt.Sprintf("%s/api/v1/user/orgs?page=%d&limit=50", giteaHost, page), giteaAccessToken, &orgs); err != nil {
+			return nil, err
+		}
+		if len(orgs) == 0 {
+			return names, nil
+		}
+		for _, org := range orgs {
+			names = append(names, firstNonEmpty(org.Name, org.Username))
+		}
+	}
+}
+
+// fetchTeamRepositories lists the repositories assigned to the team of org
+// with the given name.
+func fetchTeamRepositories(ctx context.Context, giteaHost, giteaAccessToken, org, team string, useCache bool, pageSize int) ([]Repository, error) {
+	var teamID int64
+	for page := 1; teamID == 0; page++ {
+		var teams []struct {
+			ID   int64  `json:"id"`
+			Name string `json:"name"`
+		}
+		if err := getJSONContext(ctx, fmt.Sprintf("%s/api/v1/orgs/%s/teams?page=%d&limit=50", giteaHost, url.PathEscape(org), page), giteaAccessToken, &teams); err != nil {
+			return nil, fmt.Errorf("listing teams of %s: %w", org, err)
+		}
+		if len(teams) == 0 {
+			return nil, fmt.Errorf("%s has no team named %s", org, team)
+		}
+		for _, t := range teams {
+			if strings.EqualFold(t.Name, team) {
+				teamID = t.ID
 			}
-		} else {
-			allRepos = append(allRepos, repos...)
 		}
+	}
 
-		page++
+	pageURL := func(page int) string {
+		u := fmt.Sprintf("%s/api/v1/teams/%d/repos?page=%d", giteaHost, teamID, page)
+		if pageSize > 0 {
+			u += fmt.Sprintf("&limit=%d", pageSize)
+		}
+		return u
 	}
-	return allRepos, nil
+	return fetchRepositoryPages(ctx, pageURL, giteaAccessToken, useCache, func(body []byte) ([]Repository, error) {
+		var repos []Repository
+		err := json.Unmarshal(body, &repos)
+		return repos, err
+	})
+}
+
+// searchRepositories enumerates repositories through the search API, limited to
+// those owned by ownerID when it is not zero and to those whose name or
+// description contains keyword when it is set. It only returns every
+// repository on the instance when the token belongs to an admin, and only
+// public ones without a token.
+func searchRepositories(ctx context.Context, giteaHost, giteaAccessToken string, ownerID int64, keyword string, useCache bool, pageSize int) ([]Repository, error) {
+	pageURL := func(page int) string {
+		u := fmt.Sprintf("%s%s?page=%d", giteaHost, searchReposEndpoint, page)
+		if ownerID != 0 {
+			u += fmt.Sprintf("&uid=%d&exclusive=true", ownerID)
+		}
+		if keyword != "" {
+			u += "&includeDesc=true&q=" + url.QueryEscape(keyword)
+		}
+		if pageSize > 0 {
+			u += fmt.Sprintf("&limit=%d", pageSize)
+		}
+		return u
+	}
+	return fetchRepositoryPages(ctx, pageURL, giteaAccessToken, useCache, func(body []byte) ([]Repository, error) {
+		var result struct {
+			Data []Repository `json:"data"`
+		}
+		err := json.Unmarshal(body, &result)
+		return result.Data, err
+	})
+}
+
+// fetchOwnerRepositories lists the repositories of a user or organization
+// through the search API, which also works without a token. Without an owner
+// every repository visible to the token is listed. Anonymous listings are
+// restricted to public repositories.
+func fetchOwnerRepositories(ctx context.Context, giteaHost, giteaAccessToken, owner, keyword string, useCache bool, pageSize int) ([]Repository, error) {
+	var ownerID int64
+	if owner != "" {
+		var account giteaUser
+		if err := getJSONContext(ctx, giteaHost+"/api/v1/users/"+url.PathEscape(owner), giteaAccessToken, &account); err != nil {
+			return nil, fmt.Errorf("looking up %s: %w", owner, err)
+		}
+		ownerID = account.ID
+	}
+	repos, err := searchRepositories(ctx, giteaHost, giteaAccessToken, ownerID, keyword, useCache, pageSize)
+	if err != nil || giteaAccessToken != "" {
+		return repos, err
+	}
+	public := repos[:0]
+	for _, repo := range repos {
+		if repo.visibility() == visibilityPublic {
+			public = append(public, repo)
+		}
+	}
+	return public, nil
+}
+
+func repoOwner(repo Repository) string {
+	return strings.Split(repo.FullName, "/")[0]
 }
 
-func gitClone(ctx context.Context, cloneURL, addrToSave string) error {
-	cmd := exec.CommandContext(ctx, "git", "clone", cloneURL, addrToSave)
-	return cmd.Run()
+// findClones lists the clones below root as "owner/name" paths.
+func findClones(root string) ([]string, error) {
+	var clones []string
+	err := findClonesIn(root, "", &clones)
+	return clones, err
+}
+
+// maxCloneDepth bounds how deep findClones looks for clones: owner/name below
+// a category of categories.yaml, which may have a subdirectory itself.
+const maxCloneDepth = 4
+
+// findClonesIn adds the clones below root/dir to clones. Clones are usually
+// owner/name, but categories.yaml puts them deeper, so directories that are
+// not clones are searched in turn.
+func findClonesIn(root, dir string, clones *[]string) error {
+	if strings.Count(dir, "/") >= maxCloneDepth-1 {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Join(root, dir))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		name := path.Join(dir, entry.Name())
+		if dir != "" && isClone(filepath.Join(root, name)) {
+			*clones = append(*clones, name)
+		} else if err := findClonesIn(root, name, clones); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func gitClone(ctx context.Context, cloneURL, addrToSave string, extraArgs ...string) error {
+	args := append(append(append([]string{"clone"}, gitTransportArgs()...), longPathArgs()...), extraArgs...)
+	if remoteName != "origin" {
+		args = append(args, "--origin", remoteName)
+	}
+	progress := progressWriter(ctx)
+	if progress != nil {
+		args = append(args, "--progress")
+		defer progress.Close()
+	}
+	cmd := gitCommand(append(args, cloneURL, addrToSave)...)
+	if progress != nil {
+		cmd.Stderr = progress
+	}
+	err := runCommand(ctx, cmd)
+	audit("clone", cloneURL, addrToSave, err)
+	return err
+}
+
+// gitCloneTags creates a clone that only fetches tags, no branches. Later
+// syncs keep fetching tags only, as the refspec is stored in its config.
+func gitCloneTags(ctx context.Context, cloneURL, addrToSave string, extraArgs ...string) error {
+	steps := [][]string{
+		{"init", "--quiet", addrToSave},
+		{"-C", addrToSave, "remote", "add", remoteName, cloneURL},
+		{"-C", addrToSave, "config", "remote." + remoteName + ".fetch", "+refs/tags/*:refs/tags/*"},
+		append(append([]string{"-C", addrToSave, "fetch", "--tags"}, gitTransportArgs()...), append(extraArgs, remoteName)...),
+	}
+	if len(longPathArgs()) > 0 {
+		steps = append(steps, []string{"-C", addrToSave, "config", "core.longpaths", "true"})
+	}
+	var err error
+	for _, args := range steps {
+		if err = runCommand(ctx, gitCommand(args...)); err != nil {
+			os.RemoveAll(addrToSave)
+			break
+		}
+	}
+	audit("clone", cloneURL, addrToSave+" (tags only)", err)
+	return err
 }
 
+// loadConfig reads KEY=value lines from path. Values may be quoted and
+// followed by a # comment. Encrypted values are decrypted, and all values are
+// validated, see configKeys.
 func loadConfig(path string) (map[string]string, error) {
 	configFile, err := os.ReadFile(path)
 	if err != nil {
@@ -170,47 +1628,81 @@ func loadConfig(path string) (map[string]string, error) {
 	}
 
 	config := make(map[string]string)
-	lines := strings.Split(string(configFile), "\n")
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+	lineOf := make(map[string]int)
+	// Notepad may save the file with a byte order mark and CRLF line endings.
+	lines := strings.Split(strings.TrimPrefix(string(configFile), utf8BOM), "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(stripYAMLComment(line))
+		if line == "" {
 			continue
 		}
 
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			return nil, fmt.Errorf("bad line in config file: %s", line)
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=value, got %q", path, i+1, line)
+		}
+		if _, known := configKeys[key]; !known {
+			return nil, fmt.Errorf("%s:%d: %s", path, i+1, unknownConfigKey(key))
+		}
+		if first, ok := lineOf[key]; ok {
+			return nil, fmt.Errorf("%s:%d: %s is already set on line %d", path, i+1, key, first)
+		}
+		config[key] = unquoteYAML(strings.TrimSpace(value))
+		lineOf[key] = i + 1
+	}
+
+	for key, value := range config {
+		if !strings.HasPrefix(value, encryptedPrefix) {
+			continue
+		}
+		pass, err := configPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		if config[key], err = decryptValue(value, pass); err != nil {
+			return nil, fmt.Errorf("decrypting %s: %w", key, err)
 		}
-		config[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
 	}
 
+	if err := validateConfig(path, config, lineOf); err != nil {
+		return nil, err
+	}
 	return config, nil
 }
 
-func fetchUsername(giteaHost, giteaAccessToken string) (string, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s%s", giteaHost, userEndpoint), nil)
+type giteaUser struct {
+	ID       int64  `json:"id"`
+	Username string `json:"login"`
+	IsAdmin  bool   `json:"is_admin"`
+}
+
+func fetchUsername(ctx context.Context, giteaHost, giteaAccessToken string) (string, error) {
+	user, err := fetchCurrentUser(ctx, giteaHost, giteaAccessToken)
 	if err != nil {
 		return "", err
 	}
+	return user.Username, nil
+}
 
-	req.Header.Add("Authorization", "token "+giteaAccessToken)
-	response, err := client.Do(req)
+func fetchCurrentUser(ctx context.Context, giteaHost, giteaAccessToken string) (giteaUser, error) {
+	var user giteaUser
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s%s", giteaHost, userEndpoint), nil)
 	if err != nil {
-		return "", err
+		return user, err
 	}
-	defer response.Body.Close()
 
-	if response.StatusCode != 200 {
-		return "", fmt.Errorf("failed to fetch user details with status code: %d", response.StatusCode)
+	setAuth(req, giteaAccessToken)
+	response, err := doWithRetry(apiClient, req)
+	if err != nil {
+		return user, err
 	}
+	defer response.Body.Close()
 
-	var userDetails struct {
-		Username string `json:"login"`
-	}
-	err = json.NewDecoder(response.Body).Decode(&userDetails)
-	if err != nil {
-		return "", err
+	if response.StatusCode != 200 {
+		return user, fmt.Errorf("failed to fetch user details with status code: %d", response.StatusCode)
 	}
 
-	return userDetails.Username, nil
+	err = json.NewDecoder(response.Body).Decode(&user)
+	return user, err
 }
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadConfigCRLF reads a config.env saved by Notepad, with a byte order
+// mark and CRLF line endings.
+func TestLoadConfigCRLF(t *testing.T) {
+	config, err := loadConfig(filepath.Join("testdata", "crlf.env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"GITEA_HOST":         "https://gitea.example.com",
+		"GITEA_ACCESS_TOKEN": "abc def",
+		"TARGET_DIR":         `C:\Backups\gitea`,
+		"REMOTE_NAME":        "origin",
+	}
+	if len(config) != len(want) {
+		t.Errorf("loadConfig returned %d keys, want %d: %q", len(config), len(want), config)
+	}
+	for key, value := range want {
+		if config[key] != value {
+			t.Errorf("%s = %q, want %q", key, config[key], value)
+		}
+	}
+}
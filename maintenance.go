@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+const defaultMaintenanceJobs = 4
+
+// runMaintenance runs git with args, e.g. gc --auto, in every clone in dirs,
+// at most jobs at a time. It returns the number of clones it failed for.
+func runMaintenance(dirs []string, args []string, jobs int) int {
+	if jobs <= 0 {
+		jobs = defaultMaintenanceJobs
+	}
+	sem := make(chan struct{}, jobs)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := 0
+	for _, dir := range dirs {
+		if !isClone(dir) {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(dir string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			_, err := gitOutput(ctx, dir, args...)
+			audit("maintenance", dir, args[0], err)
+			if err != nil {
+				fmt.Printf("Error running git %s in %s: %v\n", args[0], dir, err)
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}(dir)
+	}
+	wg.Wait()
+	return failed
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runMerge implements the merge subcommand, which merges the reports or the
+// lockfiles that the machines of a --shard run wrote into one.
+func runMerge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	output := fs.String("o", "", "File to write the merged report or lockfile to; reports are written as CSV, HTML or JSON depending on its extension")
+	fs.Parse(args)
+	if *output == "" || fs.NArg() == 0 {
+		return errors.New("usage: merge -o OUTPUT FILE...")
+	}
+
+	var locks []*lockfile
+	var rows []reportRow
+	for _, path := range fs.Args() {
+		if lock, err := loadLockfile(path); err == nil && lock.Host != "" {
+			locks = append(locks, lock)
+			continue
+		}
+		fileRows, err := readReport(path)
+		if err != nil {
+			return fmt.Errorf("%s is neither a lockfile nor a JSON or CSV report: %w", path, err)
+		}
+		rows = append(rows, fileRows...)
+	}
+	if len(locks) > 0 && len(rows) > 0 {
+		return errors.New("cannot merge lockfiles with reports")
+	}
+	if len(locks) > 0 {
+		merged, err := mergeLockfiles(locks)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Merged %d lockfiles with %d repositories into %s\n", len(locks), len(merged.Repositories), *output)
+		return writeJSONFile(*output, merged)
+	}
+
+	seen := make(map[string]bool, len(rows))
+	results := make([]Result, 0, len(rows))
+	for _, row := range rows {
+		if seen[row.Repository] {
+			return fmt.Errorf("%s is in more than one report, were the shards run with different counts?", row.Repository)
+		}
+		seen[row.Repository] = true
+		results = append(results, row.result())
+	}
+	fmt.Printf("Merged %d reports with %d repositories into %s\n", fs.NArg(), len(results), *output)
+	return writeReport(*output, results)
+}
+
+// mergeLockfiles merges the lockfiles of the shards of one server. It warns
+// about missing shards, since the merged lockfile would then not cover the
+// whole instance.
+func mergeLockfiles(locks []*lockfile) (*lockfile, error) {
+	merged := &lockfile{Host: locks[0].Host}
+	seen := map[string]bool{}
+	shards := map[string]bool{}
+	count := 0
+	for _, lock := range locks {
+		if lock.Host != merged.Host {
+			return nil, fmt.Errorf("cannot merge lockfiles of %s and %s", merged.Host, lock.Host)
+		}
+		if lock.GeneratedAt.After(merged.GeneratedAt) {
+			merged.GeneratedAt = lock.GeneratedAt
+		}
+		if lock.Shard != "" {
+			s, err := parseShard(lock.Shard)
+			if err != nil {
+				return nil, err
+			}
+			if count != 0 && s.count != count {
+				return nil, fmt.Errorf("cannot merge shards of %d and %d machines", count, s.count)
+			}
+			count = s.count
+			shards[lock.Shard] = true
+		}
+		for _, repo := range lock.Repositories {
+			if seen[repo.Repository] {
+				return nil, fmt.Errorf("%s is in more than one lockfile", repo.Repository)
+			}
+			seen[repo.Repository] = true
+			merged.Repositories = append(merged.Repositories, repo)
+		}
+	}
+	var missing []string
+	for i := 1; i <= count; i++ {
+		if s := (shard{index: i, count: count}).String(); !shards[s] {
+			missing = append(missing, s)
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Printf("Warning: the lockfiles of shards %s are missing\n", strings.Join(missing, ", "))
+	}
+	sort.Slice(merged.Repositories, func(i, j int) bool {
+		return merged.Repositories[i].Repository < merged.Repositories[j].Repository
+	})
+	return merged, nil
+}
+
+// readReport reads a report that --report wrote as JSON or CSV.
+func readReport(path string) ([]reportRow, error) {
+	if strings.ToLower(filepath.Ext(path)) != ".csv" {
+		var rows []reportRow
+		return rows, readJSONFile(path, &rows)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	if _, ok := columns["repository"]; !ok {
+		return nil, errors.New("no repository column")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	var rows []reportRow
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		duration, _ := strconv.ParseFloat(field(record, "duration_seconds"), 64)
+		size, _ := strconv.ParseInt(field(record, "size_bytes"), 10, 64)
+		rows = append(rows, reportRow{
+			Repository: field(record, "repository"),
+			Status:     field(record, "status"),
+			Duration:   duration,
+			Size:       size,
+			Visibility: field(record, "visibility"),
+			Toolchains: strings.Fields(field(record, "toolchains")),
+			Error:      field(record, "error"),
+			Class:      field(record, "class"),
+			Output:     field(record, "output"),
+		})
+	}
+}
+
+// result turns a row back into the Result it was written from.
+func (row reportRow) result() Result {
+	res := Result{
+		RepoName:   row.Repository,
+		Action:     row.Status,
+		Duration:   time.Duration(row.Duration * float64(time.Second)),
+		Size:       row.Size,
+		Visibility: row.Visibility,
+		Toolchains: row.Toolchains,
+		Output:     row.Output,
+		Class:      row.Class,
+	}
+	switch {
+	case row.Status == "not fast-forward":
+		res.Err = errNotFastForward
+	case row.Error != "":
+		res.Err = errors.New(row.Error)
+	}
+	return res
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ipFamily is "4" or "6" when connections are restricted to IPv4 or IPv6.
+var ipFamily string
+
+var (
+	apiTransport = http.DefaultTransport.(*http.Transport).Clone()
+	// apiClient is shared by all API requests, so connections are reused
+	// across the hundreds of pages a large instance can have.
+	apiClient = &http.Client{Transport: apiTransport}
+	// downloadClient shares the transport but has no overall timeout, as
+	// packages and archives can take long to download.
+	downloadClient = &http.Client{Transport: apiTransport}
+)
+
+// configureTransport tunes the HTTP transport used for the API.
+func configureTransport(maxIdle int, keepAlive time.Duration, http2 bool, requestTimeout time.Duration) {
+	apiTransport.MaxIdleConns = maxIdle
+	apiTransport.MaxIdleConnsPerHost = maxIdle
+	apiTransport.IdleConnTimeout = keepAlive
+	apiTransport.DisableKeepAlives = keepAlive == 0
+	if !http2 {
+		apiTransport.ForceAttemptHTTP2 = false
+		apiTransport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	apiClient.Timeout = requestTimeout
+}
+
+// configureNetwork restricts connections of the API client and git to one
+// address family and, when resolveIP is set, connects to giteaHost at that
+// address instead of resolving it through DNS.
+func configureNetwork(giteaHost, family, resolveIP string) error {
+	if family != "" && family != "4" && family != "6" {
+		return fmt.Errorf("invalid IP family %q, use 4 or 6", family)
+	}
+	ipFamily = family
+
+	var host, port string
+	if resolveIP != "" {
+		if net.ParseIP(resolveIP) == nil {
+			return fmt.Errorf("invalid IP address %q", resolveIP)
+		}
+		u, err := url.Parse(giteaHost)
+		if err != nil {
+			return err
+		}
+		host, port = u.Hostname(), u.Port()
+		if port == "" {
+			port = "443"
+			if u.Scheme == "http" {
+				port = "80"
+			}
+		}
+		// git's HTTP client reads overrides in curl's --resolve format.
+		for _, kv := range gitConfigEnv("http.curloptResolve", fmt.Sprintf("%s:%s:%s", host, port, resolveIP)) {
+			k, v, _ := strings.Cut(kv, "=")
+			os.Setenv(k, v)
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	apiTransport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if family != "" {
+			network = "tcp" + family
+		}
+		if h, p, err := net.SplitHostPort(addr); err == nil && h == host && p == port {
+			addr = net.JoinHostPort(resolveIP, port)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return nil
+}
+
+// gitTransportArgs are the options restricting git clone, fetch and push to
+// the configured address family.
+func gitTransportArgs() []string {
+	if ipFamily == "" {
+		return nil
+	}
+	return []string{"--ipv" + ipFamily}
+}
+
+// gitConfigEnv returns GIT_CONFIG_* environment variables that add the given
+// key, value pairs to git's configuration, after any already set in the
+// environment.
+func gitConfigEnv(pairs ...string) []string {
+	n, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	var env []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", n, pairs[i]), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", n, pairs[i+1]))
+		n++
+	}
+	return append(env, "GIT_CONFIG_COUNT="+strconv.Itoa(n))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const windowsToastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode($env:NOTIFY_TITLE)) | Out-Null
+$text.Item(1).AppendChild($template.CreateTextNode($env:NOTIFY_MESSAGE)) | Out-Null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($template)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('cloneAllGitea').Show($toast)
+`
+
+// desktopNotify shows a desktop notification using the notifier native to the
+// current platform.
+func desktopNotify(title, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("notify-send", title, message)
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptQuote(message), appleScriptQuote(title))
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", windowsToastScript)
+		cmd.Env = append(os.Environ(), "NOTIFY_TITLE="+title, "NOTIFY_MESSAGE="+message)
+	default:
+		return fmt.Errorf("desktop notifications are not supported on %s", runtime.GOOS)
+	}
+	err := cmd.Run()
+	audit("hook", cmd.Path, "desktop notification", err)
+	return err
+}
+
+func appleScriptQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// postWebhook sends message to a Slack, Discord or Matrix (hookshot) compatible
+// incoming webhook. Slack and Matrix read "text", Discord reads "content".
+func postWebhook(webhookURL, message string) error {
+	payload, err := json.Marshal(map[string]string{"text": message, "content": message})
+	if err != nil {
+		return err
+	}
+
+	err = sendWebhook(webhookURL, payload)
+	// Webhook URLs embed their secret in the path, so only the host is logged.
+	if u, parseErr := url.Parse(webhookURL); parseErr == nil {
+		audit("hook", u.Host, "webhook", err)
+	}
+	return err
+}
+
+func sendWebhook(webhookURL string, payload []byte) error {
+	response, err := apiClient.Post(webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook request failed with HTTP status code: %d", response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// archivesDir holds the archives written by --archive.
+	archivesDir   = ".archives"
+	archiveTarZst = "tar.zst"
+	archiveTarGz  = "tar.gz"
+)
+
+// packManifest is stored as manifest.json next to the bare repository in
+// every archive.
+type packManifest struct {
+	Repository    string            `json:"repository"`
+	CloneURL      string            `json:"clone_url"`
+	DefaultBranch string            `json:"default_branch"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Refs          map[string]string `json:"refs"`
+}
+
+// checkArchiveFormat validates --archive and makes sure its compressor exists.
+func checkArchiveFormat(format string) error {
+	switch format {
+	case archiveTarGz:
+		return nil
+	case archiveTarZst:
+		if _, err := exec.LookPath("zstd"); err != nil {
+			return fmt.Errorf("--archive %s needs the zstd command: %w", format, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid --archive %q, use %s or %s", format, archiveTarZst, archiveTarGz)
+}
+
+// archiveFile is where repo is packed to in the given format.
+func archiveFile(repo Repository, format string) string {
+	return filepath.Join(archivesDir, sanitizePath(repo.FullName)+"."+format)
+}
+
+// packRepository packs the branches and tags of repo's clone as a bare
+// repository, with a manifest, into a compressed archive below .archives.
+// With checksum set a sha256sum compatible file is written next to it.
+func packRepository(ctx context.Context, repo Repository, format string, checksum bool) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(stateFile), os.ModePerm); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(stateFile), "pack-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	clone, err := filepath.Abs(repoDir(repo))
+	if err != nil {
+		return "", err
+	}
+	bare := filepath.Join(tmp, repo.Name+".git")
+	steps := [][]string{
+		{"init", "--quiet", "--bare", bare},
+		{"-C", bare, "fetch", "--quiet", clone, "+refs/remotes/" + remoteName + "/*:refs/heads/*", "+refs/tags/*:refs/tags/*", "^refs/remotes/" + remoteName + "/HEAD"},
+		{"-C", bare, "symbolic-ref", "HEAD", "refs/heads/" + repo.DefaultBranch},
+	}
+	for _, args := range steps {
+		if out, err := commandCombinedOutput(ctx, gitCommand(args...)); err != nil {
+			return "", fmt.Errorf("creating bare repository: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	os.Remove(filepath.Join(bare, "FETCH_HEAD"))
+
+	manifest := packManifest{
+		Repository:    repo.FullName,
+		CloneURL:      repo.CloneURL,
+		DefaultBranch: repo.DefaultBranch,
+		CreatedAt:     time.Now().UTC(),
+		Refs:          map[string]string{},
+	}
+	refs, err := gitOutput(ctx, bare, "for-each-ref", "--format=%(refname) %(objectname)")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(refs, "\n") {
+		if name, sha, ok := strings.Cut(line, " "); ok {
+			manifest.Refs[name] = sha
+		}
+	}
+	if err := writeJSONFile(filepath.Join(tmp, "manifest.json"), manifest); err != nil {
+		return "", err
+	}
+
+	dest := archiveFile(repo, format)
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return "", err
+	}
+	sum, err := writeArchive(ctx, tmp, dest+".tmp", format)
+	if err != nil {
+		os.Remove(dest + ".tmp")
+		return "", err
+	}
+	if err := os.Rename(dest+".tmp", dest); err != nil {
+		return "", err
+	}
+	if checksum {
+		line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(dest))
+		if err := os.WriteFile(dest+".sha256", []byte(line), 0o644); err != nil {
+			return "", err
+		}
+	}
+	return dest, nil
+}
+
+// writeArchive writes the contents of dir as a compressed tarball to dest and
+// returns its SHA-256.
+func writeArchive(ctx context.Context, dir, dest, format string) (string, error) {
+	f, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	out := io.MultiWriter(f, hash)
+
+	var compressed io.WriteCloser
+	var zstd *exec.Cmd
+	if format == archiveTarZst {
+		zstd = exec.CommandContext(ctx, "zstd", "--quiet", "--stdout", "-T0")
+		zstd.Stdout = out
+		if compressed, err = zstd.StdinPipe(); err != nil {
+			return "", err
+		}
+		if err := zstd.Start(); err != nil {
+			return "", err
+		}
+	} else {
+		compressed = gzip.NewWriter(out)
+	}
+
+	tw := tar.NewWriter(compressed)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if closeErr := compressed.Close(); err == nil {
+		err = closeErr
+	}
+	if zstd != nil {
+		if waitErr := zstd.Wait(); err == nil {
+			err = waitErr
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), f.Sync()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+const packagesDir = ".packages"
+
+type giteaPackage struct {
+	ID      int64  `json:"id"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Owner   struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+}
+
+type packageFile struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// backupPackages stores the metadata of every package of owner under
+// .packages/owner/type/name/version and downloads the package files where
+// the registry has a predictable download URL (currently generic packages).
+func backupPackages(giteaHost, giteaAccessToken, owner string) error {
+	packages, err := getAllPages[giteaPackage](fmt.Sprintf("%s/api/v1/packages/%s", giteaHost, url.PathEscape(owner)), giteaAccessToken)
+	if err != nil {
+		return err
+	}
+
+	for _, pkg := range packages {
+		dir := filepath.Join(packagesDir, owner, pkg.Type, pkg.Name, pkg.Version)
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+
+		filesURL := fmt.Sprintf("%s/api/v1/packages/%s/%s/%s/%s/files", giteaHost,
+			url.PathEscape(owner), url.PathEscape(pkg.Type), url.PathEscape(pkg.Name), url.PathEscape(pkg.Version))
+		var files []packageFile
+		if err := getJSON(filesURL, giteaAccessToken, &files); err != nil {
+			return fmt.Errorf("listing files of %s/%s %s: %w", owner, pkg.Name, pkg.Version, err)
+		}
+
+		metadata, err := json.MarshalIndent(struct {
+			Package giteaPackage  `json:"package"`
+			Files   []packageFile `json:"files"`
+		}{pkg, files}, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, "package.json"), metadata, 0o644); err != nil {
+			return err
+		}
+
+		if pkg.Type != "generic" {
+			continue
+		}
+		for _, file := range files {
+			dest := filepath.Join(dir, filepath.Base(file.Name))
+			if _, err := os.Stat(dest); err == nil {
+				continue
+			}
+			fileURL := fmt.Sprintf("%s/api/packages/%s/generic/%s/%s/%s", giteaHost,
+				url.PathEscape(owner), url.PathEscape(pkg.Name), url.PathEscape(pkg.Version), url.PathEscape(file.Name))
+			fmt.Printf("Downloading package file %s/%s/%s\n", owner, pkg.Name, file.Name)
+			if err := downloadFile(fileURL, giteaAccessToken, dest, file.SHA256); err != nil {
+				return fmt.Errorf("downloading %s: %w", file.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const defaultPathsFile = "paths.yaml"
+
+// pathRule routes the repositories matching pattern to dest.
+type pathRule struct {
+	pattern string
+	dest    string
+}
+
+// loadPathMap reads a mapping of owner/name patterns to local paths from a
+// YAML file of "pattern: path" lines, e.g.
+//
+//	infra/*: ~/work/infra/
+//	alice/notes: /srv/notes
+//
+// Rules are tried in order. A missing file is not an error when optional is
+// set.
+func loadPathMap(file string, optional bool) ([]pathRule, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) && optional {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	home, _ := os.UserHomeDir()
+
+	var rules []pathRule
+	for i, line := range strings.Split(string(data), "\n") {
+		line = stripYAMLComment(line)
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		key, value, ok := splitYAMLPair(line)
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("%s:%d: expected \"pattern: path\"", file, i+1)
+		}
+		if _, err := path.Match(key, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid pattern %q", file, i+1, key)
+		}
+		if value == "~" || strings.HasPrefix(value, "~/") {
+			trailingSlash := strings.HasSuffix(value, "/")
+			value = filepath.Join(home, value[1:])
+			if trailingSlash {
+				value += "/"
+			}
+		}
+		rules = append(rules, pathRule{pattern: key, dest: value})
+	}
+	return rules, nil
+}
+
+// applyPathMap sets the local path of every repository: the destination of
+// the first matching rule, or owner/name below the target directory or the
+// directory of its category. A
+// destination ending in a slash, or one matched by a glob, is a parent
+// directory the repository's name is appended to. Names are sanitized and
+// colliding paths resolved, see sanitizeName and resolvePathCollisions.
+func applyPathMap(repos []Repository, rules []pathRule) {
+	for i := range repos {
+		repos[i].Path = filepath.Join(repos[i].Category, sanitizePath(repos[i].FullName))
+		for _, rule := range rules {
+			if ok, _ := path.Match(rule.pattern, repos[i].FullName); !ok {
+				continue
+			}
+			if strings.HasSuffix(rule.dest, "/") || rule.pattern != repos[i].FullName {
+				repos[i].Path = filepath.Join(rule.dest, sanitizeName(repos[i].Name))
+			} else {
+				repos[i].Path = filepath.Clean(rule.dest)
+			}
+			break
+		}
+	}
+	resolvePathCollisions(repos)
+}
+
+// repoDir is the local directory of repo's clone.
+func repoDir(repo Repository) string {
+	if repo.Path != "" {
+		return repo.Path
+	}
+	return sanitizePath(repo.FullName)
+}
+
+// stripYAMLComment removes a # comment that is not inside quotes.
+func stripYAMLComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitYAMLPair splits a "key: value" line, unquoting both sides.
+func splitYAMLPair(line string) (string, string, bool) {
+	line = strings.TrimSpace(line)
+	var key, rest string
+	if q := line[0]; q == '"' || q == '\'' {
+		end := strings.IndexByte(line[1:], q)
+		if end < 0 {
+			return "", "", false
+		}
+		key, rest = line[1:end+1], strings.TrimSpace(line[end+2:])
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		rest = rest[1:]
+	} else {
+		i := strings.Index(line, ": ")
+		if i < 0 {
+			if !strings.HasSuffix(line, ":") {
+				return "", "", false
+			}
+			i = len(line) - 1
+		}
+		key, rest = strings.TrimSpace(line[:i]), line[i+1:]
+	}
+	return key, unquoteYAML(strings.TrimSpace(rest)), true
+}
+
+func unquoteYAML(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// dispatchPause holds back new repositories while it is paused, by signal on
+// Unix, see watchPauseSignals. Repositories already running carry on.
+var dispatchPause = newPauseGate()
+
+type pauseGate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+}
+
+func newPauseGate() *pauseGate {
+	g := &pauseGate{}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// set pauses or resumes the gate and prints the change, if it is one and a
+// reason is given.
+func (g *pauseGate) set(paused bool, reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused == paused {
+		return
+	}
+	g.paused = paused
+	switch {
+	case reason == "":
+	case paused:
+		fmt.Printf("Paused starting new repositories (%s), running ones carry on\n", reason)
+	default:
+		fmt.Printf("Resumed starting new repositories (%s)\n", reason)
+	}
+	g.cond.Broadcast()
+}
+
+func (g *pauseGate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// wait blocks while the gate is paused.
+func (g *pauseGate) wait() {
+	g.mu.Lock()
+	for g.paused {
+		g.cond.Wait()
+	}
+	g.mu.Unlock()
+}
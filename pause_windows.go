@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+// watchPauseSignals does nothing on Windows, which has no SIGUSR1 and
+// SIGUSR2; the --tui dashboard can pause runs there.
+func watchPauseSignals(g *pauseGate) func() {
+	return func() {}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const planningDir = ".planning"
+
+// exportPlanning writes the labels and milestones of repo to
+// owner/name/.planning. Project boards are not exposed by the Gitea API and
+// can therefore not be exported.
+func exportPlanning(giteaHost, giteaAccessToken string, repo Repository) error {
+	base := repoAPIURL(giteaHost, repo)
+	labels, err := getAllPages[giteaLabel](base+"/labels", giteaAccessToken)
+	if err != nil {
+		return err
+	}
+	milestones, err := getAllPages[giteaMilestone](base+"/milestones?state=all", giteaAccessToken)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(repoDir(repo), planningDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	if err := excludeFromGit(repoDir(repo), "/"+planningDir+"/"); err != nil {
+		return err
+	}
+	if err := writeJSONFile(filepath.Join(dir, "labels.json"), labels); err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(dir, "milestones.json"), milestones)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stderrLimit is how much of the end of a command's error output a failure
+// keeps. git prints the reason last.
+const stderrLimit = 4096
+
+// commandError is the error of a command that failed, with the end of its
+// error output.
+type commandError struct {
+	err    error
+	stderr string
+}
+
+// Error adds the last line of the output, e.g. "fatal: repository not found",
+// which usually says what went wrong.
+func (e *commandError) Error() string {
+	lines := strings.Split(e.stderr, "\n")
+	return e.err.Error() + ": " + lines[len(lines)-1]
+}
+
+func (e *commandError) Unwrap() error {
+	return e.err
+}
+
+// commandStderr returns the error output attached to err by runCommand.
+func commandStderr(err error) string {
+	var cmdErr *commandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.stderr
+	}
+	return ""
+}
+
+// tailBuffer keeps the last stderrLimit bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > stderrLimit {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-stderrLimit:]...)
+	}
+	return len(p), nil
+}
+
+// String returns the complete lines kept, without progress redrawn with
+// carriage returns.
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var lines []string
+	for _, line := range strings.Split(string(t.buf), "\n") {
+		if i := strings.LastIndexByte(strings.TrimRight(line, "\r"), '\r'); i >= 0 {
+			line = line[i+1:]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+type verboseKey struct{}
+
+// withVerbose makes commands run with ctx print their error output as it
+// comes, each line prefixed with name.
+func withVerbose(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, verboseKey{}, name)
+}
+
+// verboseWriter prints complete lines with a prefix and drops progress lines
+// that git redraws with carriage returns.
+type verboseWriter struct {
+	prefix string
+	line   []byte
+}
+
+func (w *verboseWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		switch b {
+		case '\r':
+			w.line = w.line[:0]
+		case '\n':
+			w.Close()
+		default:
+			w.line = append(w.line, b)
+		}
+	}
+	return len(p), nil
+}
+
+func (w *verboseWriter) Close() error {
+	if line := strings.TrimSpace(string(w.line)); line != "" {
+		fmt.Printf("%s: %s\n", w.prefix, line)
+	}
+	w.line = w.line[:0]
+	return nil
+}
+
+// waitDelay is how long runCommand waits for the output of a command that
+// exited, which a child that outlived it may still hold open.
+const waitDelay = 5 * time.Second
+
+// running are the processes started by runCommand that have not exited yet.
+var running struct {
+	mu    sync.Mutex
+	procs map[*os.Process]struct{}
+}
+
+// killRunningCommands kills the commands started by runCommand and their
+// children, for a program that exits without waiting for them.
+func killRunningCommands() {
+	running.mu.Lock()
+	defer running.mu.Unlock()
+	for p := range running.procs {
+		killProcessTree(p)
+	}
+}
+
+// runCommand runs cmd, which must not be created with exec.CommandContext,
+// until it exits or ctx is done. Unlike exec.CommandContext, cancelling kills
+// the children of cmd too: git leaves the transport to a child process such as
+// git-remote-https, which keeps running when only git is killed and holds
+// cmd's output open, so that waiting for cmd never returns.
+//
+// When cmd fails, the end of its error output is part of the error, unless
+// the error output goes to stdout as well, which the caller then has.
+func runCommand(ctx context.Context, cmd *exec.Cmd) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var stderr *tailBuffer
+	if cmd.Stderr == nil || cmd.Stderr != cmd.Stdout {
+		stderr = &tailBuffer{}
+		writers := []io.Writer{stderr}
+		if cmd.Stderr != nil {
+			writers = append(writers, cmd.Stderr)
+		}
+		if name, _ := ctx.Value(verboseKey{}).(string); name != "" {
+			verbose := &verboseWriter{prefix: name}
+			defer verbose.Close()
+			writers = append(writers, verbose)
+		}
+		cmd.Stderr = io.MultiWriter(writers...)
+	}
+	cmd.WaitDelay = waitDelay
+	startProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	running.mu.Lock()
+	if running.procs == nil {
+		running.procs = make(map[*os.Process]struct{})
+	}
+	running.procs[cmd.Process] = struct{}{}
+	running.mu.Unlock()
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessTree(cmd.Process)
+		case <-exited:
+		}
+	}()
+	err := cmd.Wait()
+	close(exited)
+	running.mu.Lock()
+	delete(running.procs, cmd.Process)
+	running.mu.Unlock()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// cmd succeeded, a child it left behind kept the output open.
+		err = nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil && err != nil {
+		// "signal: killed" does not tell that the command took too long.
+		return fmt.Errorf("%w (%v)", ctxErr, err)
+	}
+	var exitErr *exec.ExitError
+	if stderr != nil && errors.As(err, &exitErr) {
+		if output := stderr.String(); output != "" {
+			return &commandError{err: err, stderr: output}
+		}
+	}
+	return err
+}
+
+// commandOutput runs cmd like runCommand and returns its stdout.
+func commandOutput(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := runCommand(ctx, cmd)
+	return stdout.Bytes(), err
+}
+
+// commandCombinedOutput runs cmd like runCommand and returns its stdout and
+// stderr.
+func commandCombinedOutput(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := runCommand(ctx, cmd)
+	return output.Bytes(), err
+}
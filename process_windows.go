@@ -0,0 +1,20 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// startProcessGroup does nothing on Windows, where killProcessTree finds the
+// children of a process by their parent.
+func startProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessTree kills p and all processes it started. taskkill finds the
+// children by their parent, so p must still be running while it does.
+func killProcessTree(p *os.Process) {
+	exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(p.Pid)).Run()
+	p.Kill()
+}
@@ -0,0 +1,69 @@
+//go:build windows
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestRunCommandKillsGrandchild cancels a command that started a child of its
+// own, like git starting git-remote-https. Both hold the write end of a pipe,
+// which is closed once taskkill has ended both.
+func TestRunCommandKillsGrandchild(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	cmd := exec.Command("cmd")
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: "/c start /b ping -n 60 127.0.0.1 & echo started& ping -n 60 127.0.0.1"}
+	cmd.Stdout = w
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- runCommand(ctx, cmd) }()
+
+	output := bufio.NewReader(r)
+	for {
+		line, err := output.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the command's output: %v", err)
+		}
+		if strings.TrimSpace(line) == "started" {
+			break
+		}
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("runCommand returned no error after cancelling")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("runCommand did not return after cancelling")
+	}
+	w.Close()
+
+	closed := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, output)
+		closed <- err
+	}()
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the child of the command is still running")
+	}
+}
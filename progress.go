@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// progress estimates throughput and remaining time from the repository sizes
+// reported by the API (in kilobytes) and the clones completed so far.
+type progress struct {
+	mu         sync.Mutex
+	start      time.Time
+	total      int
+	done       int
+	totalBytes int64
+	doneBytes  int64
+}
+
+func newProgress(repos []Repository) *progress {
+	p := &progress{start: time.Now(), total: len(repos)}
+	for _, repo := range repos {
+		p.totalBytes += repo.Size * 1024
+	}
+	return p
+}
+
+// skip accounts for a repository that did not need to be transferred, so it
+// does not distort the throughput.
+func (p *progress) skip(repo Repository) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.totalBytes -= repo.Size * 1024
+}
+
+// complete records a finished clone and prints the updated estimate.
+func (p *progress) complete(repo Repository) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.doneBytes += repo.Size * 1024
+
+	elapsed := time.Since(p.start)
+	rate := float64(p.doneBytes) / elapsed.Seconds()
+	eta := "unknown"
+	if rate > 0 {
+		remaining := time.Duration(float64(p.totalBytes-p.doneBytes)/rate) * time.Second
+		eta = remaining.Round(time.Second).String()
+	}
+	fmt.Printf("[%d/%d] %.2f MB/s, ETA %s\n", p.done, p.total, rate/(1024*1024), eta)
+}
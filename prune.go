@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	trashDir        = ".trash"
+	trashDateLayout = "2006-01-02"
+)
+
+// pruneClones moves clones below root that are no longer part of repos into
+// .trash/<date>/owner/name. When owners are given only their clones are
+// considered, because the listing was filtered to them.
+func pruneClones(root string, repos []Repository, owners []string) ([]string, error) {
+	if len(repos) == 0 {
+		return nil, fmt.Errorf("refusing to prune: the server returned no repositories, check the token's scope")
+	}
+	clones, err := unmatchedClones(root, repos, owners)
+	if err != nil {
+		return nil, err
+	}
+
+	today := filepath.Join(root, trashDir, time.Now().Format(trashDateLayout))
+	var pruned []string
+	for _, name := range clones {
+		if err := moveClone(root, name, today, "prune"); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, name)
+	}
+	return pruned, nil
+}
+
+// moveClone moves the clone name below root to the same path below dir,
+// recording action in the audit log. The owner's directory is removed when it
+// is left empty.
+func moveClone(root, name, dir, action string) error {
+	dest := filepath.Join(dir, name)
+	if _, err := os.Stat(dest); err == nil {
+		dest += "." + strconv.FormatInt(time.Now().Unix(), 10)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return err
+	}
+	err := os.Rename(filepath.Join(root, name), dest)
+	audit(action, name, dest, err)
+	if err != nil {
+		return err
+	}
+	// The bare repository of --layout worktree goes along with it.
+	store := filepath.Join(root, storeDir, name+".git")
+	if _, err := os.Stat(store); err == nil {
+		err := os.Rename(store, dest+".git")
+		audit(action, store, dest+".git", err)
+		if err != nil {
+			return err
+		}
+	}
+	os.Remove(filepath.Join(root, filepath.Dir(name)))
+	return nil
+}
+
+// unmatchedClones returns the clones below root that belong to none of repos.
+// When owners are given only their clones are considered.
+func unmatchedClones(root string, repos []Repository, owners []string) ([]string, error) {
+	keep := make(map[string]bool, len(repos))
+	for _, repo := range repos {
+		keep[filepath.ToSlash(repoDir(repo))] = true
+	}
+	clones, err := findClones(root)
+	if err != nil {
+		return nil, err
+	}
+	var unmatched []string
+	for _, name := range clones {
+		if !keep[name] && (len(owners) == 0 || contains(owners, path.Base(path.Dir(name)))) {
+			unmatched = append(unmatched, name)
+		}
+	}
+	return unmatched, nil
+}
+
+// emptyTrash permanently deletes trash days older than retention.
+func emptyTrash(root string, retention time.Duration) error {
+	days, err := os.ReadDir(filepath.Join(root, trashDir))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-retention)
+	for _, day := range days {
+		date, err := time.ParseInLocation(trashDateLayout, day.Name(), time.Local)
+		if err != nil || !date.Before(cutoff) {
+			continue
+		}
+		fmt.Printf("Deleting trash from %s\n", day.Name())
+		err = os.RemoveAll(filepath.Join(root, trashDir, day.Name()))
+		audit("delete", filepath.Join(trashDir, day.Name()), "", err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseRetention parses a duration that may also be given in days, e.g. "30d".
+func parseRetention(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil || n < 0 || int64(n) > math.MaxInt64/int64(24*time.Hour) {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err == nil && d < 0 {
+		return 0, fmt.Errorf("invalid duration %q, it is negative", value)
+	}
+	return d, err
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "30d", want: 30 * 24 * time.Hour},
+		{value: "0d", want: 0},
+		{value: "36h", want: 36 * time.Hour},
+		{value: "90m", want: 90 * time.Minute},
+		{value: "0", want: 0},
+		{value: "", wantErr: true},
+		{value: "d", wantErr: true},
+		{value: "30", wantErr: true},
+		{value: "1.5d", wantErr: true},
+		{value: "30days", wantErr: true},
+		{value: "-1d", wantErr: true},
+		{value: "-1h", wantErr: true},
+		{value: "200000d", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseRetention(tt.value)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseRetention(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
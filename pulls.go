@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const pullsDir = ".pulls"
+
+type pullBranch struct {
+	Ref string `json:"ref"`
+	SHA string `json:"sha"`
+}
+
+type giteaPull struct {
+	Number    int64        `json:"number"`
+	Title     string       `json:"title"`
+	Body      string       `json:"body"`
+	State     string       `json:"state"`
+	User      giteaUser    `json:"user"`
+	Labels    []giteaLabel `json:"labels"`
+	Head      pullBranch   `json:"head"`
+	Base      pullBranch   `json:"base"`
+	Merged    bool         `json:"merged"`
+	CreatedAt time.Time    `json:"created_at"`
+	ClosedAt  *time.Time   `json:"closed_at"`
+	HTMLURL   string       `json:"html_url"`
+}
+
+type reviewComment struct {
+	ID        int64     `json:"id"`
+	Body      string    `json:"body"`
+	Path      string    `json:"path"`
+	DiffHunk  string    `json:"diff_hunk"`
+	Position  int       `json:"position"`
+	User      giteaUser `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type pullReview struct {
+	ID          int64           `json:"id"`
+	Body        string          `json:"body"`
+	State       string          `json:"state"`
+	User        giteaUser       `json:"user"`
+	CommitID    string          `json:"commit_id"`
+	SubmittedAt time.Time       `json:"submitted_at"`
+	Comments    []reviewComment `json:"comment_list"`
+}
+
+// exportedPull is a pull request with its reviews and their inline comments,
+// as stored in .pulls/pulls.json.
+type exportedPull struct {
+	giteaPull
+	Reviews []pullReview `json:"reviews"`
+}
+
+// exportPulls writes the pull requests of repo, their reviews and review
+// comments to owner/name/.pulls/pulls.json and the patch of every pull
+// request to owner/name/.pulls/<number>.diff.
+func exportPulls(giteaHost, giteaAccessToken string, repo Repository) error {
+	base := repoAPIURL(giteaHost, repo)
+	pulls, err := getAllPages[giteaPull](base+"/pulls?state=all", giteaAccessToken)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(repoDir(repo), pullsDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	if err := excludeFromGit(repoDir(repo), "/"+pullsDir+"/"); err != nil {
+		return err
+	}
+
+	exported := make([]exportedPull, 0, len(pulls))
+	for _, pull := range pulls {
+		reviews, err := getAllPages[pullReview](fmt.Sprintf("%s/pulls/%d/reviews", base, pull.Number), giteaAccessToken)
+		if err != nil {
+			return fmt.Errorf("fetching reviews of #%d: %w", pull.Number, err)
+		}
+		for i := range reviews {
+			commentsURL := fmt.Sprintf("%s/pulls/%d/reviews/%d/comments", base, pull.Number, reviews[i].ID)
+			if err := getJSON(commentsURL, giteaAccessToken, &reviews[i].Comments); err != nil {
+				return fmt.Errorf("fetching review comments of #%d: %w", pull.Number, err)
+			}
+		}
+		exported = append(exported, exportedPull{giteaPull: pull, Reviews: reviews})
+
+		diffURL := fmt.Sprintf("%s/pulls/%d.diff", base, pull.Number)
+		if err := downloadText(diffURL, giteaAccessToken, filepath.Join(dir, fmt.Sprintf("%d.diff", pull.Number))); err != nil {
+			return fmt.Errorf("fetching diff of #%d: %w", pull.Number, err)
+		}
+	}
+
+	return writeJSONFile(filepath.Join(dir, "pulls.json"), exported)
+}
+
+// downloadText saves a small non-JSON API response, such as a diff, to path.
+func downloadText(url, giteaAccessToken, path string) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	setAuth(req, giteaAccessToken)
+	response, err := doWithRetry(apiClient, req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return fmt.Errorf("API request failed with HTTP status code: %d", response.StatusCode)
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, body, 0o644)
+}
+
+// fetchPullRefs configures the clone in dir to also fetch the head of every
+// pull request, into refs/remotes/<remote>/pull/<number>, and fetches them
+// once. Later syncs keep them up to date through the stored refspec, so pull
+// requests stay in the clone after their source branch is deleted.
+func fetchPullRefs(ctx context.Context, dir string) error {
+	refspec := "+refs/pull/*/head:refs/remotes/" + remoteName + "/pull/*"
+	specs, _ := gitOutput(ctx, dir, "config", "--get-all", "remote."+remoteName+".fetch")
+	for _, spec := range strings.Split(specs, "\n") {
+		if spec == refspec {
+			return nil
+		}
+	}
+	if _, err := gitOutput(ctx, dir, "config", "--add", "remote."+remoteName+".fetch", refspec); err != nil {
+		return err
+	}
+	args := append(append([]string{"fetch", "--quiet"}, gitTransportArgs()...), remoteName, refspec)
+	_, err := gitOutput(ctx, dir, args...)
+	audit("fetch", dir, "pull request refs", err)
+	return err
+}
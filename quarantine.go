@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// quarantineDir holds the clones --quarantine-after moved away.
+const quarantineDir = ".quarantine"
+
+// quarantineStale counts, for every clone below root that belongs to none of
+// repos, the consecutive runs it has not matched a repository, and moves the
+// clones that reached after runs to .quarantine/owner/name. Clones that match
+// again start over. It returns a result per unmatched clone, for the report.
+func (q *workQueue) quarantineStale(root string, repos []Repository, owners []string, after int) ([]Result, error) {
+	if len(repos) == 0 {
+		return nil, fmt.Errorf("refusing to quarantine: the server returned no repositories, check the token's scope")
+	}
+	clones, err := unmatchedClones(root, repos, owners)
+	if err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	counts := make(map[string]int, len(clones))
+	var results []Result
+	for _, name := range clones {
+		counts[name] = q.state.Unmatched[name] + 1
+		if counts[name] < after {
+			fmt.Printf("%s has matched no repository on the server for %d of %d runs before quarantine\n", name, counts[name], after)
+			results = append(results, Result{RepoName: name, Action: "stale"})
+			continue
+		}
+		if err := moveClone(root, name, filepath.Join(root, quarantineDir), "quarantine"); err != nil {
+			return results, err
+		}
+		fmt.Printf("Quarantined %s after %d runs without a matching repository\n", name, counts[name])
+		delete(counts, name)
+		results = append(results, Result{RepoName: name, Action: "quarantined"})
+	}
+	q.state.Unmatched = counts
+	q.dirty = true
+	return results, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const repoListDir = ".clonegitea/lists"
+
+// repoList is the last enumerated repository list, reused with --cached.
+type repoList struct {
+	Key       string       `json:"key"`
+	FetchedAt time.Time    `json:"fetched_at"`
+	Repos     []Repository `json:"repos"`
+}
+
+// repoListKey identifies what was listed, so a list fetched for other filters
+// or another token is never reused. Every key is saved to its own file.
+func repoListKey(opts *options, owners []string) string {
+	listing := fmt.Sprintf("%s all=%t org=%s team=%s user=%s onlyme=%t collaborations=%t search=%s", opts.giteaHost, opts.all, opts.org, opts.team, strings.Join(owners, ","), opts.onlyMe, opts.collaborations, opts.search)
+	return cacheKey(opts.giteaAccessToken, listing)
+}
+
+// loadRepoList returns the saved repository list for key, or nil when there is
+// none.
+func loadRepoList(key string) *repoList {
+	var list repoList
+	if err := readJSONFile(filepath.Join(repoListDir, key+".json"), &list); err != nil || list.Key != key {
+		return nil
+	}
+	return &list
+}
+
+func saveRepoList(key string, repos []Repository) error {
+	if err := os.MkdirAll(repoListDir, os.ModePerm); err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(repoListDir, key+".json"), repoList{Key: key, FetchedAt: time.Now(), Repos: repos})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// reportRow is the result of one repository as written by --report.
+type reportRow struct {
+	Repository string  `json:"repository"`
+	Status     string  `json:"status"`
+	Duration   float64 `json:"duration_seconds"`
+	Size       int64   `json:"size_bytes"`
+	Visibility string  `json:"visibility,omitempty"`
+	// Toolchains are those the project files of the clone call for.
+	Toolchains []string `json:"toolchains,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	// Output is the end of git's error output when it failed.
+	Output string `json:"output,omitempty"`
+	// Class is the cause of the failure, see classifyFailure.
+	Class string `json:"class,omitempty"`
+}
+
+func reportRows(results []Result) []reportRow {
+	rows := make([]reportRow, 0, len(results))
+	for _, res := range results {
+		row := reportRow{Repository: res.RepoName, Status: res.Action, Duration: res.Duration.Seconds(), Size: res.Size, Visibility: res.Visibility, Toolchains: res.Toolchains}
+		switch {
+		case errors.Is(res.Err, errNotFastForward):
+			row.Status = "not fast-forward"
+		case res.Err != nil:
+			row.Status = "failed"
+			row.Error = res.Err.Error()
+			row.Output = res.Output
+			row.Class = res.Class
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Repository < rows[j].Repository })
+	return rows
+}
+
+// writeReport writes one row per repository to path, as CSV when it ends in
+// .csv, as an HTML page when it ends in .html and as JSON otherwise.
+func writeReport(path string, results []Result) error {
+	rows := reportRows(results)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		return writeHTMLReport(path, results)
+	case ".csv":
+	default:
+		return writeJSONFile(path, rows)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"repository", "status", "duration_seconds", "size_bytes", "visibility", "toolchains", "error", "class", "output"})
+	for _, row := range rows {
+		w.Write([]string{row.Repository, row.Status, strconv.FormatFloat(row.Duration, 'f', 3, 64), strconv.FormatInt(row.Size, 10), row.Visibility, strings.Join(row.Toolchains, " "), row.Error, row.Class, row.Output})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"html/template"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"size": formatSize,
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Gitea backup report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #ddd; }
+th { cursor: pointer; background: #f4f4f4; user-select: none; }
+tr.failed td { background: #fde8e8; }
+.bar { background: #609926; height: 1em; }
+.chart td { border: none; }
+.summary span { margin-right: 2em; }
+pre { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>Gitea backup report</h1>
+<p class="summary"><span>Generated {{.Generated.Format "2006-01-02 15:04:05 MST"}}</span><span>{{len .Rows}} repositories</span><span>{{.Succeeded}} succeeded</span><span>{{len .Failures}} failed</span><span>{{size .TotalSize}} total</span></p>
+
+<h2>Size per owner</h2>
+<table class="chart">
+{{range .Owners}}<tr><td>{{.Name}}</td><td style="width:70%"><div class="bar" style="width:{{.Percent}}%"></div></td><td>{{size .Size}}</td></tr>
+{{end}}</table>
+
+{{if .Failures}}<h2>Failures</h2>
+{{range .Failures}}<details><summary>{{.Repository}} ({{.Class}})</summary><pre>{{.Error}}{{if .Output}}
+
+{{.Output}}{{end}}</pre></details>
+{{end}}{{end}}
+<h2>Repositories</h2>
+<table id="repos">
+<thead><tr><th>Repository</th><th>Status</th><th data-numeric>Duration (s)</th><th data-numeric>Size</th><th>Visibility</th><th>Toolchains</th></tr></thead>
+<tbody>
+{{range .Rows}}<tr{{if .Error}} class="failed"{{end}}><td>{{.Repository}}</td><td>{{.Status}}</td><td data-value="{{.Duration}}">{{printf "%.1f" .Duration}}</td><td data-value="{{.Size}}">{{size .Size}}</td><td>{{.Visibility}}</td><td>{{join .Toolchains ", "}}</td></tr>
+{{end}}</tbody>
+</table>
+<script>
+document.querySelectorAll("#repos th").forEach(function (th, column) {
+  var ascending = true;
+  th.addEventListener("click", function () {
+    var tbody = document.querySelector("#repos tbody");
+    var numeric = th.hasAttribute("data-numeric");
+    var rows = Array.prototype.slice.call(tbody.rows);
+    rows.sort(function (a, b) {
+      var x = a.cells[column], y = b.cells[column];
+      var c = numeric ? x.dataset.value - y.dataset.value : x.textContent.localeCompare(y.textContent);
+      return ascending ? c : -c;
+    });
+    ascending = !ascending;
+    rows.forEach(function (row) { tbody.appendChild(row); });
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+type ownerSize struct {
+	Name    string
+	Size    int64
+	Percent float64
+}
+
+// writeHTMLReport renders a standalone HTML page with a sortable table of the
+// results, the failures and the size per owner.
+func writeHTMLReport(path string, results []Result) error {
+	data := struct {
+		Generated time.Time
+		Rows      []reportRow
+		Failures  []reportRow
+		Owners    []ownerSize
+		Succeeded int
+		TotalSize int64
+	}{Generated: time.Now(), Rows: reportRows(results)}
+
+	perOwner := make(map[string]int64)
+	var largest int64
+	for _, row := range data.Rows {
+		if row.Error != "" {
+			data.Failures = append(data.Failures, row)
+		} else {
+			data.Succeeded++
+		}
+		data.TotalSize += row.Size
+		owner, _, _ := strings.Cut(row.Repository, "/")
+		perOwner[owner] += row.Size
+		if perOwner[owner] > largest {
+			largest = perOwner[owner]
+		}
+	}
+	for name, size := range perOwner {
+		o := ownerSize{Name: name, Size: size}
+		if largest > 0 {
+			o.Percent = float64(size) * 100 / float64(largest)
+		}
+		data.Owners = append(data.Owners, o)
+	}
+	sort.Slice(data.Owners, func(i, j int) bool { return data.Owners[i].Size > data.Owners[j].Size })
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return htmlReportTemplate.Execute(f, data)
+}
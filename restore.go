@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var errNotFound = errors.New("not found")
+
+// runRestore implements the restore subcommand, which recreates the backed up
+// repositories and their planning structure on a (new) Gitea instance.
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	host := fs.String("host", "", "Gitea instance to restore to (default RESTORE_GITEA_HOST, then GITEA_HOST)")
+	token := fs.String("token", "", "Access token for the target instance (default RESTORE_GITEA_ACCESS_TOKEN, then GITEA_ACCESS_TOKEN)")
+	only := fs.String("repo", "", "Restore only this owner/name")
+	userMapFile := fs.String("user-map", "", "File with old=new lines mapping usernames for @mentions in restored issues")
+	fs.Parse(args)
+
+	config, err := loadConfig("config.env")
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	targetHost, err := parseHost(firstNonEmpty(*host, config["RESTORE_GITEA_HOST"], config["GITEA_HOST"]))
+	if err != nil {
+		return err
+	}
+	targetToken := firstNonEmpty(*token, config["RESTORE_GITEA_ACCESS_TOKEN"], config["GITEA_ACCESS_TOKEN"])
+	root := config["TARGET_DIR"]
+	if targetToken != "" {
+		if err := configureAskpass(targetHost, targetToken); err != nil {
+			return err
+		}
+	}
+
+	closeAudit, err := openAuditLog(root)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	defer closeAudit()
+
+	me, err := fetchUsername(context.Background(), targetHost, targetToken)
+	if err != nil {
+		return fmt.Errorf("fetching user details: %w", err)
+	}
+	userMap, err := loadUserMap(*userMapFile)
+	if err != nil {
+		return fmt.Errorf("loading user map: %w", err)
+	}
+	mentions := &mentionMapper{giteaHost: targetHost, giteaAccessToken: targetToken, userMap: userMap, exists: make(map[string]bool)}
+
+	clones, err := findClones(root)
+	if err != nil {
+		return fmt.Errorf("scanning target directory: %w", err)
+	}
+
+	failed := 0
+	for _, clone := range clones {
+		// Bare repositories of --bare are named like the repository.
+		name := strings.TrimSuffix(clone, bareSuffix)
+		if *only != "" && name != *only {
+			continue
+		}
+		fmt.Printf("Restoring %s to %s\n", name, targetHost)
+		if err := restoreRepository(targetHost, targetToken, me, filepath.Join(root, clone), name, mentions); err != nil {
+			failed++
+			fmt.Printf("Error restoring %s: %v\n", name, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d repositories could not be restored", failed)
+	}
+	return nil
+}
+
+func restoreRepository(giteaHost, giteaAccessToken, me, dir, fullName string, mentions *mentionMapper) error {
+	repo, err := ensureRepository(giteaHost, giteaAccessToken, me, fullName)
+	if err != nil {
+		return err
+	}
+	if err := pushClone(context.Background(), dir, repo.CloneURL); err != nil {
+		return fmt.Errorf("pushing: %w", err)
+	}
+	if err := restorePlanning(giteaHost, giteaAccessToken, repo, filepath.Join(dir, planningDir)); err != nil {
+		return fmt.Errorf("restoring labels and milestones: %w", err)
+	}
+	if err := restoreIssues(giteaHost, giteaAccessToken, repo, filepath.Join(dir, issuesDir), mentions); err != nil {
+		return fmt.Errorf("restoring issues: %w", err)
+	}
+	if err := restoreSettings(giteaHost, giteaAccessToken, repo, filepath.Join(dir, settingsDir)); err != nil {
+		return fmt.Errorf("restoring settings: %w", err)
+	}
+	return nil
+}
+
+// ensureRepository returns the repository on the target instance, creating it
+// as a private repository of the user, organization or (for admins) other
+// user that owned it.
+func ensureRepository(giteaHost, giteaAccessToken, me, fullName string) (Repository, error) {
+	repo := Repository{FullName: fullName}
+	err := getJSON(repoAPIURL(giteaHost, repo), giteaAccessToken, &repo)
+	if err == nil {
+		return repo, nil
+	}
+
+	owner, name, _ := strings.Cut(fullName, "/")
+	create := map[string]interface{}{"name": name, "private": true}
+	endpoints := []string{"/api/v1/user/repos"}
+	if owner != me {
+		endpoints = []string{
+			"/api/v1/orgs/" + url.PathEscape(owner) + "/repos",
+			"/api/v1/admin/users/" + url.PathEscape(owner) + "/repos",
+		}
+	}
+	for _, endpoint := range endpoints {
+		if err = sendJSON("POST", giteaHost+endpoint, giteaAccessToken, create, &repo); err == nil {
+			fmt.Printf("Created repository %s\n", fullName)
+			return repo, nil
+		}
+	}
+	return repo, fmt.Errorf("creating repository: %w", err)
+}
+
+// pushClone pushes every branch and tag of a working clone to cloneURL, with
+// the access token given to configureAskpass.
+func pushClone(ctx context.Context, dir, cloneURL string) error {
+	prefix := branchRefs(dir)
+	branches, err := gitOutput(ctx, dir, "for-each-ref", "--format=%(refname)", prefix)
+	if err != nil {
+		return err
+	}
+	refspecs := []string{"refs/tags/*:refs/tags/*"}
+	for _, ref := range strings.Split(branches, "\n") {
+		if branch := strings.TrimPrefix(ref, prefix); branch != "" && branch != "HEAD" {
+			refspecs = append(refspecs, ref+":refs/heads/"+branch)
+		}
+	}
+
+	args := append([]string{"-C", dir, "push"}, gitTransportArgs()...)
+	cmd := gitCommand(append(append(args, cloneURL), refspecs...)...)
+	out, err := commandCombinedOutput(ctx, cmd)
+	audit("push", cloneURL, dir, err)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// restorePlanning creates the exported labels and milestones that do not yet
+// exist (by name) in the target repository.
+func restorePlanning(giteaHost, giteaAccessToken string, repo Repository, dir string) error {
+	base := repoAPIURL(giteaHost, repo)
+
+	var labels []giteaLabel
+	if err := readJSONFile(filepath.Join(dir, "labels.json"), &labels); err != nil && !errors.Is(err, errNotFound) {
+		return err
+	}
+	existingLabels, err := getAllPages[giteaLabel](base+"/labels", giteaAccessToken)
+	if err != nil {
+		return err
+	}
+	haveLabel := make(map[string]bool)
+	for _, label := range existingLabels {
+		haveLabel[label.Name] = true
+	}
+	for _, label := range labels {
+		if haveLabel[label.Name] {
+			continue
+		}
+		create := map[string]interface{}{
+			"name":        label.Name,
+			"color":       "#" + strings.TrimPrefix(label.Color, "#"),
+			"description": label.Description,
+			"exclusive":   label.Exclusive,
+		}
+		if err := sendJSON("POST", base+"/labels", giteaAccessToken, create, nil); err != nil {
+			return fmt.Errorf("label %q: %w", label.Name, err)
+		}
+	}
+
+	var milestones []giteaMilestone
+	if err := readJSONFile(filepath.Join(dir, "milestones.json"), &milestones); err != nil && !errors.Is(err, errNotFound) {
+		return err
+	}
+	existingMilestones, err := getAllPages[giteaMilestone](base+"/milestones?state=all", giteaAccessToken)
+	if err != nil {
+		return err
+	}
+	haveMilestone := make(map[string]bool)
+	for _, milestone := range existingMilestones {
+		haveMilestone[milestone.Title] = true
+	}
+	for _, milestone := range milestones {
+		if haveMilestone[milestone.Title] {
+			continue
+		}
+		create := map[string]interface{}{
+			"title":       milestone.Title,
+			"description": milestone.Description,
+			"due_on":      milestone.DueOn,
+			"state":       milestone.State,
+		}
+		if err := sendJSON("POST", base+"/milestones", giteaAccessToken, create, nil); err != nil {
+			return fmt.Errorf("milestone %q: %w", milestone.Title, err)
+		}
+	}
+	return nil
+}
+
+// readJSONFile decodes path into v, returning errNotFound if it does not
+// exist.
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return errNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// mentionMapper rewrites @mentions of users from the source instance for the
+// target instance: mapped users are renamed, users that exist on the target
+// are kept, and all others are quoted so they do not notify the wrong person.
+type mentionMapper struct {
+	giteaHost        string
+	giteaAccessToken string
+	userMap          map[string]string
+	exists           map[string]bool
+}
+
+var mentionPattern = regexp.MustCompile(`(^|[^\w` + "`" + `])@([\w.-]+\w)`)
+
+func (m *mentionMapper) user(login string) (string, bool) {
+	if mapped, ok := m.userMap[login]; ok {
+		return mapped, true
+	}
+	exists, ok := m.exists[login]
+	if !ok {
+		var u giteaUser
+		exists = getJSON(m.giteaHost+"/api/v1/users/"+url.PathEscape(login), m.giteaAccessToken, &u) == nil
+		m.exists[login] = exists
+	}
+	return login, exists
+}
+
+func (m *mentionMapper) rewrite(text string) string {
+	return mentionPattern.ReplaceAllStringFunc(text, func(match string) string {
+		sub := mentionPattern.FindStringSubmatch(match)
+		if login, ok := m.user(sub[2]); ok {
+			return sub[1] + "@" + login
+		}
+		return sub[1] + "`@" + sub[2] + "`"
+	})
+}
+
+// attribution names the original author and date, since restored issues and
+// comments are created by the owner of the access token.
+func (m *mentionMapper) attribution(login string, at time.Time) string {
+	author := "`@" + login + "`"
+	if mapped, ok := m.user(login); ok {
+		author = "@" + mapped
+	}
+	return fmt.Sprintf("> Originally by %s on %s\n\n", author, at.Format("2006-01-02 15:04"))
+}
+
+// loadUserMap reads "old=new" lines mapping source to target usernames.
+func loadUserMap(path string) (map[string]string, error) {
+	userMap := make(map[string]string)
+	if path == "" {
+		return userMap, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		from, to, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad line in user map: %s", line)
+		}
+		userMap[strings.TrimPrefix(strings.TrimSpace(from), "@")] = strings.TrimPrefix(strings.TrimSpace(to), "@")
+	}
+	return userMap, nil
+}
+
+// restoredMarker is appended to the body of restored issues and comments so a
+// repeated restore can tell which of them already exist.
+const restoredMarker = "<!-- cloneAllGitea restored %s %d -->"
+
+// restoredID returns the original number of a restored issue, or the original
+// ID of a restored comment, recorded in body by restoredMarker.
+func restoredID(body, kind string) (int64, bool) {
+	i := strings.LastIndex(body, "<!-- cloneAllGitea restored "+kind+" ")
+	if i < 0 {
+		return 0, false
+	}
+	var id int64
+	if _, err := fmt.Sscanf(body[i:], restoredMarker, &kind, &id); err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// restoreIssues replays the exported issues and comments into the target
+// repository. Issues and comments carry their original number or ID in a
+// hidden marker, and those already restored are skipped, so the restore can
+// be repeated or resumed after a failure.
+func restoreIssues(giteaHost, giteaAccessToken string, repo Repository, dir string, mentions *mentionMapper) error {
+	var issues []exportedIssue
+	if err := readJSONFile(filepath.Join(dir, "issues.json"), &issues); err != nil {
+		if errors.Is(err, errNotFound) {
+			return nil
+		}
+		return err
+	}
+	sort.Slice(issues, func(i, j int) bool { return issues[i].Number < issues[j].Number })
+
+	base := repoAPIURL(giteaHost, repo)
+	existing, err := getAllPages[giteaIssue](base+"/issues?state=all&type=issues", giteaAccessToken)
+	if err != nil {
+		return err
+	}
+	restored := make(map[int64]int64)
+	for _, issue := range existing {
+		if number, ok := restoredID(issue.Body, "issue"); ok {
+			restored[number] = issue.Number
+		}
+	}
+	labels, err := getAllPages[giteaLabel](base+"/labels", giteaAccessToken)
+	if err != nil {
+		return err
+	}
+	labelIDs := make(map[string]int64)
+	for _, label := range labels {
+		labelIDs[label.Name] = label.ID
+	}
+	milestones, err := getAllPages[giteaMilestone](base+"/milestones?state=all", giteaAccessToken)
+	if err != nil {
+		return err
+	}
+	milestoneIDs := make(map[string]int64)
+	for _, milestone := range milestones {
+		milestoneIDs[milestone.Title] = milestone.ID
+	}
+
+	for _, issue := range issues {
+		number, ok := restored[issue.Number]
+		if !ok {
+			created, err := createIssue(base, giteaAccessToken, issue, labelIDs, milestoneIDs, mentions)
+			if err != nil {
+				return fmt.Errorf("issue #%d: %w", issue.Number, err)
+			}
+			number = created
+		}
+		if err := restoreComments(base, giteaAccessToken, number, issue.CommentList, !ok, mentions); err != nil {
+			return fmt.Errorf("comment on issue #%d: %w", issue.Number, err)
+		}
+	}
+	return nil
+}
+
+// createIssue creates issue in the repository at base and returns its number
+// there.
+func createIssue(base, giteaAccessToken string, issue exportedIssue, labelIDs, milestoneIDs map[string]int64, mentions *mentionMapper) (int64, error) {
+	create := map[string]interface{}{
+		"title":  issue.Title,
+		"body":   mentions.attribution(issue.User.Username, issue.CreatedAt) + mentions.rewrite(issue.Body) + "\n\n" + fmt.Sprintf(restoredMarker, "issue", issue.Number),
+		"closed": issue.State == "closed",
+	}
+	var ids []int64
+	for _, label := range issue.Labels {
+		if id, ok := labelIDs[label.Name]; ok {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > 0 {
+		create["labels"] = ids
+	}
+	if issue.Milestone != nil {
+		if id, ok := milestoneIDs[issue.Milestone.Title]; ok {
+			create["milestone"] = id
+		}
+	}
+
+	var created giteaIssue
+	if err := sendJSON("POST", base+"/issues", giteaAccessToken, create, &created); err != nil {
+		return 0, err
+	}
+	return created.Number, nil
+}
+
+// restoreComments adds the comments to issue number that are not there yet.
+// A freshly created issue has none, so its comments are not listed first.
+func restoreComments(base, giteaAccessToken string, number int64, comments []issueComment, fresh bool, mentions *mentionMapper) error {
+	if len(comments) == 0 {
+		return nil
+	}
+	url := fmt.Sprintf("%s/issues/%d/comments", base, number)
+	have := make(map[int64]bool)
+	if !fresh {
+		// Gitea returns all comments of an issue at once, the endpoint has
+		// no pages.
+		var existing []issueComment
+		if err := getJSON(url, giteaAccessToken, &existing); err != nil {
+			return err
+		}
+		for _, comment := range existing {
+			if id, ok := restoredID(comment.Body, "comment"); ok {
+				have[id] = true
+			}
+		}
+	}
+	for _, comment := range comments {
+		if have[comment.ID] {
+			continue
+		}
+		body := map[string]string{"body": mentions.attribution(comment.User.Username, comment.CreatedAt) + mentions.rewrite(comment.Body) + "\n\n" + fmt.Sprintf(restoredMarker, "comment", comment.ID)}
+		if err := sendJSON("POST", url, giteaAccessToken, body, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIssueServer is a Gitea repository o/r with issues and comments.
+type fakeIssueServer struct {
+	mu       sync.Mutex
+	issues   []giteaIssue
+	comments map[int64][]issueComment
+	posts    int
+}
+
+func (f *fakeIssueServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/repos/o/r")
+	page := r.URL.Query().Get("page")
+	var out interface{}
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/v1/users/"):
+		http.NotFound(w, r)
+		return
+	case path == "/labels" || path == "/milestones":
+		out = []interface{}{}
+	case path == "/issues" && r.Method == http.MethodGet:
+		out = []giteaIssue{}
+		if page == "1" {
+			out = f.issues
+		}
+	case path == "/issues" && r.Method == http.MethodPost:
+		var issue giteaIssue
+		json.NewDecoder(r.Body).Decode(&issue)
+		issue.Number = int64(len(f.issues) + 1)
+		f.issues = append(f.issues, issue)
+		f.posts++
+		out = issue
+	case strings.HasPrefix(path, "/issues/") && strings.HasSuffix(path, "/comments"):
+		number, _ := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(path, "/issues/"), "/comments"), 10, 64)
+		if page != "" {
+			// Gitea does not page comments, asking for pages never ends.
+			http.Error(w, "comments have no pages", http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPost {
+			var comment issueComment
+			json.NewDecoder(r.Body).Decode(&comment)
+			comment.ID = int64(1000 + f.posts)
+			f.comments[number] = append(f.comments[number], comment)
+			f.posts++
+			out = comment
+		} else {
+			out = append([]issueComment{}, f.comments[number]...)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// TestRestoreIssuesRepeated restores two issues with the same title into a
+// repository that has an unrelated issue with that title as well, then
+// repeats the restore, and resumes it after a comment got lost.
+func TestRestoreIssuesRepeated(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	exported := []exportedIssue{
+		{
+			giteaIssue: giteaIssue{Number: 3, Title: "Bug", Body: "first", State: "open", User: giteaUser{Username: "bob"}, CreatedAt: created},
+			CommentList: []issueComment{
+				{ID: 10, Body: "one", User: giteaUser{Username: "carol"}, CreatedAt: created},
+				{ID: 11, Body: "two", User: giteaUser{Username: "carol"}, CreatedAt: created},
+			},
+		},
+		{
+			giteaIssue:  giteaIssue{Number: 5, Title: "Bug", Body: "second", State: "closed", User: giteaUser{Username: "bob"}, CreatedAt: created},
+			CommentList: []issueComment{{ID: 12, Body: "three", User: giteaUser{Username: "carol"}, CreatedAt: created}},
+		},
+	}
+	dir := t.TempDir()
+	if err := writeJSONFile(filepath.Join(dir, "issues.json"), exported); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeIssueServer{
+		issues:   []giteaIssue{{Number: 1, Title: "Bug", Body: "unrelated"}},
+		comments: map[int64][]issueComment{},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	repo := Repository{FullName: "o/r"}
+	restore := func() {
+		t.Helper()
+		mentions := &mentionMapper{giteaHost: server.URL, userMap: map[string]string{}, exists: map[string]bool{}}
+		if err := restoreIssues(server.URL, "", repo, dir, mentions); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restore()
+	if fake.posts != 5 {
+		t.Fatalf("first restore created %d issues and comments, want 5", fake.posts)
+	}
+	for i, want := range []int64{3, 5} {
+		issue := fake.issues[i+1]
+		if got, ok := restoredID(issue.Body, "issue"); !ok || got != want {
+			t.Errorf("issue #%d is marked as restored from #%d, want #%d", issue.Number, got, want)
+		}
+	}
+	if got := len(fake.comments[2]); got != 2 {
+		t.Errorf("issue #2 has %d comments, want 2", got)
+	}
+
+	restore()
+	if fake.posts != 5 {
+		t.Fatalf("repeated restore created %d issues and comments, want none", fake.posts-5)
+	}
+
+	// A restore that failed before the last comment of #3.
+	fake.comments[2] = fake.comments[2][:1]
+	restore()
+	if fake.posts != 6 {
+		t.Fatalf("resumed restore created %d issues and comments, want 1", fake.posts-5)
+	}
+	last := fake.comments[2][1].Body
+	if !strings.HasSuffix(last, fmt.Sprintf(restoredMarker, "comment", 11)) {
+		t.Errorf("resumed restore added %q, want comment 11", last)
+	}
+}
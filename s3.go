@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Region = "us-east-1"
+	defaultS3Prefix = "cloneAllGitea"
+	// unsignedPayload lets large files be streamed instead of hashed twice.
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// s3Target is the S3 compatible bucket configured with the S3_* keys.
+type s3Target struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	prefix    string
+	retention time.Duration
+}
+
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+}
+
+// loadS3Target reads the S3_* keys of the configuration. It returns nil when
+// S3_BUCKET is not set.
+func loadS3Target(config map[string]string) (*s3Target, error) {
+	if config["S3_BUCKET"] == "" {
+		return nil, nil
+	}
+	endpoint, err := url.Parse(firstNonEmpty(config["S3_ENDPOINT"], "https://s3.amazonaws.com"))
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT %q", config["S3_ENDPOINT"])
+	}
+	endpoint.Path = strings.TrimRight(endpoint.Path, "/")
+	t := &s3Target{
+		endpoint:  endpoint,
+		bucket:    config["S3_BUCKET"],
+		region:    firstNonEmpty(config["S3_REGION"], defaultS3Region),
+		accessKey: config["S3_ACCESS_KEY"],
+		secretKey: config["S3_SECRET_KEY"],
+		prefix:    strings.Trim(firstNonEmpty(config["S3_PREFIX"], defaultS3Prefix), "/"),
+	}
+	if t.accessKey == "" || t.secretKey == "" {
+		return nil, errors.New("S3_BUCKET needs S3_ACCESS_KEY and S3_SECRET_KEY")
+	}
+	if value := config["S3_RETENTION"]; value != "" {
+		if t.retention, err = parseRetention(value); err != nil {
+			return nil, fmt.Errorf("parsing S3_RETENTION: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// s3Upload is a local file and the key it is uploaded to.
+type s3Upload struct {
+	path string
+	key  string
+}
+
+// uploadBackups uploads the archives and bundles of repos that changed since
+// their last upload and a manifest of the run. With a retention, run
+// manifests older than it are deleted. So are archives and bundles that are
+// no longer part of repos, e.g. of deleted repositories, but only when repos
+// is the complete listing, of owners if any.
+func (t *s3Target) uploadBackups(repos []Repository, archiveFormat string, summary runSummary, complete bool, owners []string) error {
+	var uploads []s3Upload
+	for _, repo := range repos {
+		if archiveFormat != "" {
+			archive := archiveFile(repo, archiveFormat)
+			for _, p := range []string{archive, archive + ".sha256"} {
+				if _, err := os.Stat(p); err == nil {
+					uploads = append(uploads, s3Upload{p, "archives/" + repo.FullName + "." + archiveFormat + strings.TrimPrefix(p, archive)})
+				}
+			}
+		}
+		bundles, _ := filepath.Glob(filepath.Join(repoDir(repo), "*.bundle"))
+		for _, p := range bundles {
+			uploads = append(uploads, s3Upload{p, "bundles/" + repo.FullName + "/" + filepath.Base(p)})
+		}
+	}
+
+	existing, err := t.list()
+	if err != nil {
+		return fmt.Errorf("listing bucket: %w", err)
+	}
+	current := make(map[string]bool, len(uploads))
+	uploaded := 0
+	for _, u := range uploads {
+		key := t.prefix + "/" + u.key
+		current[key] = true
+		info, err := os.Stat(u.path)
+		if err != nil {
+			return err
+		}
+		if obj, ok := existing[key]; ok && obj.Size == info.Size() && !info.ModTime().Truncate(time.Second).After(obj.LastModified) {
+			continue
+		}
+		if err := t.putFile(key, u.path); err != nil {
+			return fmt.Errorf("uploading %s: %w", u.path, err)
+		}
+		uploaded++
+	}
+
+	manifest := struct {
+		runSummary
+		Results []reportRow `json:"results"`
+	}{summary, summary.Results}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	runKey := t.prefix + "/runs/" + summary.Started.UTC().Format("20060102T150405Z") + ".json"
+	if err := t.put(runKey, data); err != nil {
+		return fmt.Errorf("uploading run manifest: %w", err)
+	}
+	fmt.Printf("Uploaded %d files and the run manifest to s3://%s/%s\n", uploaded, t.bucket, t.prefix)
+
+	if t.retention <= 0 {
+		return nil
+	}
+	cutoff := time.Now().Add(-t.retention)
+	for key, obj := range existing {
+		if current[key] || key == runKey || !obj.LastModified.Before(cutoff) {
+			continue
+		}
+		rel := strings.TrimPrefix(key, t.prefix+"/")
+		if !strings.HasPrefix(rel, "runs/") {
+			kind, name, _ := strings.Cut(rel, "/")
+			if !complete || (kind != "archives" && kind != "bundles") || (len(owners) > 0 && !contains(owners, strings.SplitN(name, "/", 2)[0])) {
+				continue
+			}
+		}
+		if err := t.delete(key); err != nil {
+			return fmt.Errorf("deleting %s: %w", key, err)
+		}
+		fmt.Printf("Deleted expired s3://%s/%s\n", t.bucket, key)
+	}
+	return nil
+}
+
+// objectURL returns the path-style URL of key, which S3 and MinIO both accept.
+func (t *s3Target) objectURL(key string, query url.Values) *url.URL {
+	u := *t.endpoint
+	u.Path = t.endpoint.Path + "/" + t.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = t.endpoint.Path + "/" + s3Escape(t.bucket, false)
+	if key != "" {
+		u.RawPath += "/" + s3Escape(key, false)
+	}
+	u.RawQuery = s3CanonicalQuery(query)
+	return &u
+}
+
+func (t *s3Target) put(key string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPut, t.objectURL(key, nil).String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	return t.do(req, hex.EncodeToString(sum[:]), nil)
+}
+
+func (t *s3Target) putFile(key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, t.objectURL(key, nil).String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.GetBody = func() (io.ReadCloser, error) { return os.Open(path) }
+	err = t.do(req, unsignedPayload, nil)
+	audit("upload", "s3://"+t.bucket+"/"+key, path, err)
+	return err
+}
+
+func (t *s3Target) delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, t.objectURL(key, nil).String(), nil)
+	if err != nil {
+		return err
+	}
+	err = t.do(req, emptySHA256, nil)
+	audit("delete", "s3://"+t.bucket+"/"+key, "", err)
+	return err
+}
+
+// list returns the objects below the prefix by key.
+func (t *s3Target) list() (map[string]s3Object, error) {
+	objects := make(map[string]s3Object)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {t.prefix + "/"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := http.NewRequest(http.MethodGet, t.objectURL("", query).String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents              []s3Object `xml:"Contents"`
+			IsTruncated           bool       `xml:"IsTruncated"`
+			NextContinuationToken string     `xml:"NextContinuationToken"`
+		}
+		if err := t.do(req, emptySHA256, &page); err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			objects[obj.Key] = obj
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// emptySHA256 is the payload hash of requests without a body.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// do signs req, sends it and decodes an XML response into out, if not nil.
+func (t *s3Target) do(req *http.Request, payloadHash string, out interface{}) error {
+	t.sign(req, payloadHash, time.Now().UTC())
+	response, err := doWithRetry(downloadClient, req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		var s3Err struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		xml.NewDecoder(response.Body).Decode(&s3Err)
+		return fmt.Errorf("S3 request failed with HTTP status code: %d %s %s", response.StatusCode, s3Err.Code, s3Err.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return xml.NewDecoder(response.Body).Decode(out)
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req.
+func (t *s3Target) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + t.region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + t.secretKey)
+	for _, part := range []string{date, t.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3CanonicalQuery encodes query sorted by key, as SigV4 requires.
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape percent-encodes everything but unreserved characters, and slashes
+// unless encodeSlash is set.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
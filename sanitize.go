@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// reservedNames are device names Windows does not allow as file names, with
+// or without an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeName makes a single path component safe on Windows, macOS and
+// Linux alike: characters Windows forbids become underscores, as do trailing
+// dots and spaces, and reserved device names get an underscore appended.
+// Names that are already safe are returned unchanged.
+func sanitizeName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			b.WriteByte('_')
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	name = b.String()
+	trimmed := strings.TrimRight(name, ". ")
+	if trimmed != name {
+		name = trimmed + strings.Repeat("_", len(name)-len(trimmed))
+	}
+	if name == "" {
+		name = "_"
+	}
+	base, _, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		name = base + "_" + name[len(base):]
+	}
+	return name
+}
+
+// sanitizePath sanitizes every component of the slash separated path p, e.g.
+// a repository's full name, and returns it with the OS's separators.
+func sanitizePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = sanitizeName(part)
+	}
+	return filepath.Join(parts...)
+}
+
+// resolvePathCollisions gives repositories whose local paths would end up
+// as the same directory on a case-insensitive file system distinct paths.
+// Of each group the repository whose full name sorts first keeps its path,
+// the others get a suffix derived from their full name, so the renaming is
+// the same on every run.
+func resolvePathCollisions(repos []Repository) {
+	groups := make(map[string][]int)
+	for i := range repos {
+		key := strings.ToLower(filepath.Clean(repoDir(repos[i])))
+		groups[key] = append(groups[key], i)
+	}
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(a, b int) bool { return repos[group[a]].FullName < repos[group[b]].FullName })
+		kept := repos[group[0]]
+		for _, i := range group[1:] {
+			sum := sha1.Sum([]byte(repos[i].FullName))
+			dir := repoDir(repos[i])
+			repos[i].Path = dir + "-" + hex.EncodeToString(sum[:4])
+			fmt.Printf("Warning: %s and %s would both be cloned to %s, cloning %s to %s instead\n",
+				kept.FullName, repos[i].FullName, dir, repos[i].FullName, repos[i].Path)
+		}
+	}
+}
+
+// longPathArgs are passed to git clone so that checkouts with paths longer
+// than 260 characters work on Windows.
+func longPathArgs() []string {
+	if runtime.GOOS != "windows" {
+		return nil
+	}
+	return []string{"--config", "core.longpaths=true"}
+}
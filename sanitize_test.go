@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"repo", "repo"},
+		{"über-repo", "über-repo"},
+		{"a:b", "a_b"},
+		{`a<b>c|d?e*f"g\h`, "a_b_c_d_e_f_g_h"},
+		{"tab\tname", "tab_name"},
+		{"trailing.", "trailing_"},
+		{"name. ", "name__"},
+		{"...", "___"},
+		{"", "_"},
+		{"CON", "CON_"},
+		{"con.txt", "con_.txt"},
+		{"Aux.tar.gz", "Aux_.tar.gz"},
+		{"com1", "com1_"},
+		{"LPT9.git", "LPT9_.git"},
+		{"CONSOLE", "CONSOLE"},
+		{"com10", "com10"},
+		{"nul ", "nul_"},
+	}
+	for _, tt := range tests {
+		got := sanitizeName(tt.name)
+		if got != tt.want {
+			t.Errorf("sanitizeName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		if again := sanitizeName(got); again != got {
+			t.Errorf("sanitizeName(%q) = %q, not stable for its own result %q", got, again, got)
+		}
+	}
+}
+
+func TestResolvePathCollisions(t *testing.T) {
+	names := []string{"alice/repo", "bob/x", "Alice/Repo", "o/a?b", "ALICE/REPO", "o/con", "o/a:b", "o/CON"}
+	want := map[string]string{
+		"ALICE/REPO": "ALICE/REPO",
+		"Alice/Repo": "Alice/Repo-1fdfc7b0",
+		"alice/repo": "alice/repo-6d6f1aec",
+		"bob/x":      "bob/x",
+		"o/a:b":      "o/a_b",
+		"o/a?b":      "o/a_b-cdb8932f",
+		"o/CON":      "o/CON_",
+		"o/con":      "o/con_-3d830d17",
+	}
+	// The same paths come out whatever order the server lists the
+	// repositories in.
+	for _, reverse := range []bool{false, true} {
+		repos := make([]Repository, len(names))
+		for i, name := range names {
+			if reverse {
+				i = len(names) - 1 - i
+			}
+			repos[i] = Repository{FullName: name}
+		}
+		resolvePathCollisions(repos)
+		for _, repo := range repos {
+			if got := repoDir(repo); got != filepath.FromSlash(want[repo.FullName]) {
+				t.Errorf("reverse %v: %s is cloned to %s, want %s", reverse, repo.FullName, got, want[repo.FullName])
+			}
+		}
+	}
+}
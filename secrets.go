@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// encryptedPrefix marks a config value encrypted by `config encrypt`.
+	encryptedPrefix = "enc:v1:"
+	// passphraseEnv supplies the passphrase without a prompt, e.g. in cron.
+	passphraseEnv    = "CLONEGITEA_PASSPHRASE"
+	pbkdf2Iterations = 600000
+	saltSize         = 16
+)
+
+// secretConfigKeys are the config keys `config encrypt` encrypts.
+var secretConfigKeys = []string{"GITEA_ACCESS_TOKEN", "RESTORE_GITEA_ACCESS_TOKEN", "S3_SECRET_KEY", "NOTIFY_WEBHOOK_URL", "STATE_STORE", "CONTROL_TOKEN"}
+
+var (
+	passphraseOnce sync.Once
+	passphrase     string
+	passphraseErr  error
+)
+
+// configPassphrase returns the passphrase of encrypted config values, from
+// CLONEGITEA_PASSPHRASE or else asked for on the terminal, once per process.
+func configPassphrase() (string, error) {
+	passphraseOnce.Do(func() {
+		if value, ok := os.LookupEnv(passphraseEnv); ok {
+			passphrase = value
+		} else {
+			passphrase, passphraseErr = readPassphrase("Config passphrase: ")
+		}
+		if passphraseErr == nil && passphrase == "" {
+			passphraseErr = errors.New("empty passphrase")
+		}
+	})
+	return passphrase, passphraseErr
+}
+
+// readPassphrase prompts on the terminal without echoing the input.
+func readPassphrase(prompt string) (string, error) {
+	if stat, err := os.Stdin.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return "", fmt.Errorf("no terminal to ask for the config passphrase, set %s", passphraseEnv)
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	if _, err := stty("-echo"); err == nil {
+		defer stty("echo")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	fmt.Fprintln(os.Stderr)
+	if err == io.EOF {
+		return "", fmt.Errorf("no config passphrase entered, set %s", passphraseEnv)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// encryptValue encrypts value with AES-256-GCM under a key derived from pass
+// with PBKDF2-HMAC-SHA256 and a random salt.
+func encryptValue(value, pass string) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	gcm, err := newConfigCipher(pass, salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(append(salt, nonce...), nonce, []byte(value), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptValue reverses encryptValue.
+func decryptValue(value, pass string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil || len(data) < saltSize {
+		return "", errors.New("malformed encrypted value")
+	}
+	gcm, err := newConfigCipher(pass, data[:saltSize])
+	if err != nil {
+		return "", err
+	}
+	data = data[saltSize:]
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("wrong passphrase or corrupted value")
+	}
+	return string(plain), nil
+}
+
+func newConfigCipher(pass string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2SHA256([]byte(pass), salt, pbkdf2Iterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2SHA256 derives a key of keyLen bytes as specified in RFC 8018.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		key = append(key, pbkdf2Block(prf, salt, iterations, block)...)
+	}
+	return key[:keyLen]
+}
+
+func pbkdf2Block(prf hash.Hash, salt []byte, iterations int, block uint32) []byte {
+	prf.Reset()
+	prf.Write(salt)
+	binary.Write(prf, binary.BigEndian, block)
+	u := prf.Sum(nil)
+	t := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range t {
+			t[j] ^= u[j]
+		}
+	}
+	return t
+}
+
+// runConfig is the config subcommand. `config encrypt` encrypts the secrets
+// of config.env in place.
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "encrypt" {
+		return errors.New("usage: config encrypt [-file config.env]")
+	}
+	fs := flag.NewFlagSet("config encrypt", flag.ExitOnError)
+	file := fs.String("file", "config.env", "Config file to encrypt the secrets of")
+	fs.Parse(args[1:])
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	bom := strings.HasPrefix(string(data), utf8BOM)
+	lines := strings.Split(strings.TrimPrefix(string(data), utf8BOM), "\n")
+	var pass string
+	encrypted := 0
+	for i, line := range lines {
+		eol := ""
+		if strings.HasSuffix(line, "\r") {
+			line, eol = strings.TrimSuffix(line, "\r"), "\r"
+		}
+		content := stripYAMLComment(line)
+		comment := line[len(content):]
+		key, value, ok := strings.Cut(content, "=")
+		key, value = strings.TrimSpace(key), unquoteYAML(strings.TrimSpace(value))
+		if !ok || value == "" || strings.HasPrefix(value, encryptedPrefix) || !contains(secretConfigKeys, key) {
+			continue
+		}
+		if pass == "" {
+			if pass, err = newPassphrase(); err != nil {
+				return err
+			}
+		}
+		if value, err = encryptValue(value, pass); err != nil {
+			return err
+		}
+		lines[i] = key + "=" + value
+		if comment != "" {
+			lines[i] += " " + comment
+		}
+		lines[i] += eol
+		encrypted++
+	}
+	if encrypted == 0 {
+		fmt.Printf("No plaintext secrets in %s\n", *file)
+		return nil
+	}
+
+	tmp := *file + ".tmp"
+	output := strings.Join(lines, "\n")
+	if bom {
+		output = utf8BOM + output
+	}
+	if err := os.WriteFile(tmp, []byte(output), 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, *file); err != nil {
+		return err
+	}
+	fmt.Printf("Encrypted %d values in %s\n", encrypted, *file)
+	return nil
+}
+
+// newPassphrase returns the passphrase to encrypt with: CLONEGITEA_PASSPHRASE,
+// or one entered twice on the terminal.
+func newPassphrase() (string, error) {
+	if value := os.Getenv(passphraseEnv); value != "" {
+		return value, nil
+	}
+	pass, err := readPassphrase("New config passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	again, err := readPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if pass != again {
+		return "", errors.New("passphrases do not match")
+	}
+	if pass == "" {
+		return "", errors.New("empty passphrase")
+	}
+	return pass, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// TestPBKDF2SHA256 checks the key derivation against the test vector of
+// RFC 7914, section 11.
+func TestPBKDF2SHA256(t *testing.T) {
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if got := hex.EncodeToString(pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 64)); got != want {
+		t.Errorf("pbkdf2SHA256 = %s, want %s", got, want)
+	}
+}
+
+func TestEncryptValue(t *testing.T) {
+	for _, value := range []string{"", "s3cret-token", "redis://:pässword@host:6379/0"} {
+		encrypted, err := encryptValue(value, "correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(encrypted, encryptedPrefix) || (value != "" && strings.Contains(encrypted, value)) {
+			t.Errorf("encryptValue(%q) = %q", value, encrypted)
+		}
+		if got, err := decryptValue(encrypted, "correct horse"); err != nil || got != value {
+			t.Errorf("decryptValue(encryptValue(%q)) = %q, %v", value, got, err)
+		}
+	}
+
+	// The same value encrypts differently every time.
+	first, _ := encryptValue("token", "correct horse")
+	second, _ := encryptValue("token", "correct horse")
+	if first == second {
+		t.Error("encrypting a value twice gave the same result")
+	}
+}
+
+func TestDecryptValueFails(t *testing.T) {
+	encrypted, err := encryptValue("s3cret-token", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, encryptedPrefix))
+	// tampered flips a bit of the salt, the nonce, the ciphertext or the tag.
+	tampered := func(i int) string {
+		changed := append([]byte(nil), data...)
+		changed[i] ^= 1
+		return encryptedPrefix + base64.StdEncoding.EncodeToString(changed)
+	}
+
+	tests := []struct {
+		name, value, pass string
+	}{
+		{"wrong passphrase", encrypted, "battery staple"},
+		{"tampered salt", tampered(0), "correct horse"},
+		{"tampered nonce", tampered(saltSize), "correct horse"},
+		{"tampered ciphertext", tampered(saltSize + 12), "correct horse"},
+		{"tampered tag", tampered(len(data) - 1), "correct horse"},
+		{"truncated", encryptedPrefix + base64.StdEncoding.EncodeToString(data[:saltSize+4]), "correct horse"},
+		{"not base64", encryptedPrefix + "not base64!", "correct horse"},
+	}
+	for _, tt := range tests {
+		if got, err := decryptValue(tt.value, tt.pass); err == nil {
+			t.Errorf("%s: decryptValue = %q, want an error", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/cgi"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runServeMirror implements the serve-mirror subcommand, which serves the
+// clones below TARGET_DIR read-only over HTTP at /owner/name.git, as a
+// stand-in while the Gitea server is down.
+func runServeMirror(args []string) error {
+	fs := flag.NewFlagSet("serve-mirror", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:8418", "Address to serve the repositories on")
+	dumb := fs.Bool("dumb", false, "Serve the files of the repositories for git's dumb HTTP protocol instead of running git http-backend")
+	fs.Parse(args)
+
+	config, err := loadConfig("config.env")
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	root, err := filepath.Abs(config["TARGET_DIR"])
+	if err != nil {
+		return err
+	}
+	clones, err := findClones(root)
+	if err != nil {
+		return fmt.Errorf("scanning target directory: %w", err)
+	}
+
+	server := &mirrorServer{root: root, dumb: *dumb}
+	if !*dumb {
+		git, err := exec.LookPath("git")
+		if err != nil {
+			return err
+		}
+		server.backend = &cgi.Handler{
+			Path: git,
+			Args: []string{"http-backend"},
+			Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+		}
+	}
+	fmt.Printf("Serving %d repositories of %s read-only on http://%s/owner/name.git\n", len(clones), root, *listen)
+	return (&http.Server{Addr: *listen, Handler: server, ReadHeaderTimeout: 10 * time.Second}).ListenAndServe()
+}
+
+type mirrorServer struct {
+	root    string
+	dumb    bool
+	backend *cgi.Handler
+}
+
+func (s *mirrorServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" {
+		s.serveIndex(w, r)
+		return
+	}
+	// The mirror is read-only: pushes are refused before git sees them.
+	if strings.HasSuffix(r.URL.Path, "/git-receive-pack") || r.URL.Query().Get("service") == "git-receive-pack" {
+		http.Error(w, "this mirror is read-only", http.StatusForbidden)
+		return
+	}
+	// The repository is owner/name, or category/owner/name with
+	// categories.yaml, and the rest is git's.
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	var dir, rest string
+	for i := 2; i < len(parts) && i <= maxCloneDepth; i++ {
+		name := append(append([]string(nil), parts[:i-1]...), strings.TrimSuffix(parts[i-1], bareSuffix))
+		if found, ok := s.clone(name); ok {
+			dir, rest = found, strings.Join(parts[i:], "/")
+			break
+		}
+	}
+	if dir == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !s.dumb {
+		req := r.Clone(r.Context())
+		req.URL.Path = "/" + filepath.ToSlash(dir) + "/" + rest
+		s.backend.ServeHTTP(w, req)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "only the dumb HTTP protocol is served", http.StatusMethodNotAllowed)
+		return
+	}
+	gitDir := filepath.Join(s.root, dir)
+	if !isBareRepo(gitDir) {
+		gitDir = filepath.Join(gitDir, ".git")
+	}
+	if rest != "HEAD" && rest != "info/refs" && !strings.HasPrefix(rest, "objects/") || strings.Contains(rest, "..") {
+		http.NotFound(w, r)
+		return
+	}
+	if rest == "info/refs" {
+		// The dumb protocol reads the refs and packs from files that only
+		// update-server-info writes, so they are brought up to date first.
+		if _, err := gitOutput(r.Context(), gitDir, "update-server-info"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	http.ServeFile(w, r, filepath.Join(gitDir, filepath.FromSlash(rest)))
+}
+
+// clone returns the directory of the clone with the path name relative to the
+// root, either a bare repository or one with a working tree.
+func (s *mirrorServer) clone(name []string) (string, bool) {
+	for _, part := range name {
+		if part == "" || strings.HasPrefix(part, ".") {
+			return "", false
+		}
+	}
+	base := filepath.Join(name...)
+	for _, dir := range []string{base + bareSuffix, base} {
+		if isClone(filepath.Join(s.root, dir)) {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// serveIndex lists the clone URLs of the served repositories.
+func (s *mirrorServer) serveIndex(w http.ResponseWriter, r *http.Request) {
+	clones, err := findClones(s.root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, name := range clones {
+		fmt.Fprintf(w, "http://%s/%s%s\n", r.Host, strings.TrimSuffix(name, bareSuffix), bareSuffix)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const settingsDir = ".settings"
+
+// repoSettingKeys are the fields of a repository that its edit API accepts
+// and that are worth restoring.
+var repoSettingKeys = []string{
+	"description", "website", "private", "template", "default_branch",
+	"has_issues", "has_wiki", "has_pull_requests", "has_projects", "has_releases", "has_packages", "has_actions",
+	"internal_tracker", "external_tracker", "external_wiki",
+	"ignore_whitespace_conflicts", "allow_merge_commits", "allow_rebase", "allow_rebase_explicit",
+	"allow_squash_merge", "allow_fast_forward_only_merge", "allow_rebase_update", "default_delete_branch_after_merge",
+	"default_merge_style", "default_allow_maintainer_edit", "archived",
+}
+
+// repoSettings is what exportSettings writes to owner/name/.settings/settings.json.
+// Protections and hooks are kept as the server returned them, so fields of
+// newer Gitea versions survive the round trip.
+type repoSettings struct {
+	Repository        map[string]interface{}   `json:"repository"`
+	BranchProtections []map[string]interface{} `json:"branch_protections"`
+	Webhooks          []map[string]interface{} `json:"webhooks"`
+	DeployKeys        []deployKey              `json:"deploy_keys"`
+}
+
+type deployKey struct {
+	ID          int64  `json:"id"`
+	Title       string `json:"title"`
+	Key         string `json:"key"`
+	Fingerprint string `json:"fingerprint"`
+	ReadOnly    bool   `json:"read_only"`
+}
+
+// exportSettings writes the settings, branch protections, webhooks and deploy
+// keys of repo to owner/name/.settings/settings.json. Protections, hooks and
+// keys are only visible to repository admins; without access they are left
+// out rather than failing the export.
+func exportSettings(giteaHost, giteaAccessToken string, repo Repository) error {
+	base := repoAPIURL(giteaHost, repo)
+	var full map[string]interface{}
+	if err := getJSON(base, giteaAccessToken, &full); err != nil {
+		return err
+	}
+	settings := repoSettings{Repository: make(map[string]interface{})}
+	for _, key := range repoSettingKeys {
+		if value, ok := full[key]; ok {
+			settings.Repository[key] = value
+		}
+	}
+
+	// Branch protections have no pages, webhooks and deploy keys do.
+	err := getJSON(base+"/branch_protections", giteaAccessToken, &settings.BranchProtections)
+	if err != nil && !isForbidden(err) && !isNotFound(err) {
+		return fmt.Errorf("fetching branch protections: %w", err)
+	}
+	if settings.Webhooks, err = getAllPages[map[string]interface{}](base+"/hooks", giteaAccessToken); err != nil && !isForbidden(err) && !isNotFound(err) {
+		return fmt.Errorf("fetching webhooks: %w", err)
+	}
+	if settings.DeployKeys, err = getAllPages[deployKey](base+"/keys", giteaAccessToken); err != nil && !isForbidden(err) && !isNotFound(err) {
+		return fmt.Errorf("fetching deploy keys: %w", err)
+	}
+
+	dir := filepath.Join(repoDir(repo), settingsDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	if err := excludeFromGit(repoDir(repo), "/"+settingsDir+"/"); err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(dir, "settings.json"), settings)
+}
+
+// restoreSettings re-applies the exported settings to the target repository:
+// the repository options are patched, and branch protections, webhooks and
+// deploy keys that do not exist yet are created. Gitea never returns webhook
+// secrets, so restored webhooks have none and are reported.
+func restoreSettings(giteaHost, giteaAccessToken string, repo Repository, dir string) error {
+	var settings repoSettings
+	err := readJSONFile(filepath.Join(dir, "settings.json"), &settings)
+	if err == errNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	base := repoAPIURL(giteaHost, repo)
+
+	existingRules := make(map[string]bool)
+	var protections []map[string]interface{}
+	if err := getJSON(base+"/branch_protections", giteaAccessToken, &protections); err != nil {
+		return fmt.Errorf("fetching branch protections: %w", err)
+	}
+	for _, p := range protections {
+		existingRules[protectionRule(p)] = true
+	}
+	for _, p := range settings.BranchProtections {
+		rule := protectionRule(p)
+		if existingRules[rule] {
+			continue
+		}
+		// Older servers name the rule branch_name, newer ones rule_name;
+		// sending both works with either.
+		p["branch_name"], p["rule_name"] = rule, rule
+		delete(p, "created_at")
+		delete(p, "updated_at")
+		if err := sendJSON("POST", base+"/branch_protections", giteaAccessToken, p, nil); err != nil {
+			return fmt.Errorf("branch protection %q: %w", rule, err)
+		}
+	}
+
+	existingHooks := make(map[string]bool)
+	hooks, err := getAllPages[map[string]interface{}](base+"/hooks", giteaAccessToken)
+	if err != nil {
+		return fmt.Errorf("fetching webhooks: %w", err)
+	}
+	for _, h := range hooks {
+		existingHooks[hookURL(h)] = true
+	}
+	for _, h := range settings.Webhooks {
+		if existingHooks[hookURL(h)] {
+			continue
+		}
+		create := map[string]interface{}{
+			"type":          h["type"],
+			"config":        h["config"],
+			"events":        h["events"],
+			"active":        h["active"],
+			"branch_filter": h["branch_filter"],
+		}
+		if err := sendJSON("POST", base+"/hooks", giteaAccessToken, create, nil); err != nil {
+			return fmt.Errorf("webhook %s: %w", hookURL(h), err)
+		}
+		fmt.Printf("Restored webhook %s of %s without its secret, set it again if the receiver checks it\n", hookURL(h), repo.FullName)
+	}
+
+	existingKeys := make(map[string]bool)
+	keys, err := getAllPages[deployKey](base+"/keys", giteaAccessToken)
+	if err != nil {
+		return fmt.Errorf("fetching deploy keys: %w", err)
+	}
+	for _, k := range keys {
+		existingKeys[k.Fingerprint] = true
+	}
+	for _, k := range settings.DeployKeys {
+		if existingKeys[k.Fingerprint] {
+			continue
+		}
+		create := map[string]interface{}{"title": k.Title, "key": k.Key, "read_only": k.ReadOnly}
+		if err := sendJSON("POST", base+"/keys", giteaAccessToken, create, nil); err != nil {
+			return fmt.Errorf("deploy key %q: %w", k.Title, err)
+		}
+	}
+
+	// The repository options come last: archiving the repository would
+	// prevent the changes above.
+	if len(settings.Repository) > 0 {
+		if err := sendJSON("PATCH", base, giteaAccessToken, settings.Repository, nil); err != nil {
+			return fmt.Errorf("repository options: %w", err)
+		}
+	}
+	return nil
+}
+
+func protectionRule(p map[string]interface{}) string {
+	if rule, _ := p["rule_name"].(string); rule != "" {
+		return rule
+	}
+	branch, _ := p["branch_name"].(string)
+	return branch
+}
+
+func hookURL(h map[string]interface{}) string {
+	config, _ := h["config"].(map[string]interface{})
+	url, _ := config["url"].(string)
+	return url
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// shard is the part i of n of the repositories that --shard mirrors. The
+// zero value mirrors all of them.
+type shard struct {
+	index, count int
+}
+
+// parseShard parses i/n, with i counting from 1.
+func parseShard(value string) (shard, error) {
+	i, n, ok := strings.Cut(value, "/")
+	index, err1 := strconv.Atoi(i)
+	count, err2 := strconv.Atoi(n)
+	if !ok || err1 != nil || err2 != nil || count < 1 || index < 1 || index > count {
+		return shard{}, fmt.Errorf("invalid shard %q, expected i/n with 1 <= i <= n, e.g. 2/4", value)
+	}
+	return shard{index: index, count: count}, nil
+}
+
+func (s shard) String() string {
+	if s.count == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", s.index, s.count)
+}
+
+// contains reports whether repo belongs to the shard. The shard follows from
+// a hash of the repository's ID, which unlike its name survives renames and
+// transfers, so every machine agrees on it without talking to the others and
+// a repository stays on the same machine from run to run.
+func (s shard) contains(repo Repository) bool {
+	if s.count <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(strconv.FormatInt(repo.ID, 10)))
+	return int(h.Sum32()%uint32(s.count)) == s.index-1
+}
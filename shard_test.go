@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestParseShard(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    shard
+		wantErr bool
+	}{
+		{value: "1/1", want: shard{1, 1}},
+		{value: "2/4", want: shard{2, 4}},
+		{value: "4/4", want: shard{4, 4}},
+		{value: "0/4", wantErr: true},
+		{value: "5/4", wantErr: true},
+		{value: "1/0", wantErr: true},
+		{value: "2", wantErr: true},
+		{value: "a/b", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseShard(tt.value)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseShard(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+// TestShardContains pins the shard of a few repositories: machines running
+// different versions must agree on it, and a repository must not move to
+// another machine after an upgrade.
+func TestShardContains(t *testing.T) {
+	tests := []struct {
+		id   int64
+		want [4]int // the shard of 2, 3, 4 and 7
+	}{
+		{1, [4]int{1, 2, 1, 3}},
+		{2, [4]int{2, 2, 2, 4}},
+		{3, [4]int{1, 3, 3, 6}},
+		{42, [4]int{2, 3, 4, 7}},
+		{1000, [4]int{1, 2, 1, 4}},
+		{123456789, [4]int{1, 2, 1, 2}},
+	}
+	for _, tt := range tests {
+		// The name does not count, a renamed repository stays put.
+		repo := Repository{ID: tt.id, FullName: "renamed/repo"}
+		for i, count := range []int{2, 3, 4, 7} {
+			var in []int
+			for index := 1; index <= count; index++ {
+				if (shard{index, count}).contains(repo) {
+					in = append(in, index)
+				}
+			}
+			if len(in) != 1 || in[0] != tt.want[i] {
+				t.Errorf("repository %d is in shards %v of %d, want %d", tt.id, in, count, tt.want[i])
+			}
+		}
+		if !(shard{}).contains(repo) {
+			t.Errorf("repository %d is not mirrored without --shard", tt.id)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// sharedObjectsRepo is the bare repository whose objects clones of fork
+// networks borrow through git alternates with --shared-objects.
+const sharedObjectsRepo = ".clonegitea/objects.git"
+
+var sharedObjectsInit sync.Mutex
+
+// forkNetworks returns the names of the repositories in repos that are forks
+// or have forks among repos. Only those benefit from sharing objects.
+func forkNetworks(repos []Repository) map[string]bool {
+	shared := make(map[string]bool)
+	for _, repo := range repos {
+		if repo.Fork && repo.Parent != nil {
+			shared[repo.FullName] = true
+			shared[repo.Parent.FullName] = true
+		}
+	}
+	return shared
+}
+
+// fetchSharedObjects fetches the branches and tags of repo into the shared
+// object repository and returns its absolute path, for git clone --reference.
+// Refs are kept per repository so the objects stay reachable.
+func fetchSharedObjects(ctx context.Context, cloneURL string, repo Repository) (string, error) {
+	pool, err := filepath.Abs(sharedObjectsRepo)
+	if err != nil {
+		return "", err
+	}
+	sharedObjectsInit.Lock()
+	if _, err := os.Stat(pool); os.IsNotExist(err) {
+		err = runCommand(ctx, gitCommand("init", "--quiet", "--bare", pool))
+	}
+	sharedObjectsInit.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	ns := "refs/shared/" + strings.ReplaceAll(repo.FullName, "/", "--")
+	args := append(append([]string{"fetch", "--quiet", "--no-tags"}, gitTransportArgs()...),
+		cloneURL, "+refs/heads/*:"+ns+"/heads/*", "+refs/tags/*:"+ns+"/tags/*")
+	_, err = gitOutput(ctx, pool, args...)
+	audit("fetch", cloneURL, sharedObjectsRepo, err)
+	return pool, err
+}
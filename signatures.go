@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const signatureReportFile = ".clonegitea/signatures.json"
+
+// signatureStatus is the verification result of the tip of a clone's checked
+// out (default) branch.
+type signatureStatus struct {
+	Repo     string `json:"repo"`
+	Commit   string `json:"commit"`
+	Verified bool   `json:"verified"`
+	Reason   string `json:"reason,omitempty"`
+	Signer   string `json:"signer,omitempty"`
+}
+
+// gpgStatusReasons explains the %G? codes of git log.
+var gpgStatusReasons = map[string]string{
+	"B": "bad signature",
+	"X": "good signature that has expired",
+	"Y": "good signature made by an expired key",
+	"R": "good signature made by a revoked key",
+	"E": "signature cannot be checked, the key is missing from the keyring",
+	"N": "unsigned",
+}
+
+type commitVerification struct {
+	Commit struct {
+		Verification struct {
+			Verified bool   `json:"verified"`
+			Reason   string `json:"reason"`
+			Signer   *struct {
+				Name  string `json:"name"`
+				Email string `json:"email"`
+			} `json:"signer"`
+		} `json:"verification"`
+	} `json:"commit"`
+}
+
+// verifySignature checks the signature of the commit checked out in the clone
+// of repo. With a keyring (a GNUPGHOME directory) git verifies it locally,
+// otherwise the verification of the Gitea server is used.
+func verifySignature(ctx context.Context, giteaHost, giteaAccessToken, keyring string, repo Repository) (signatureStatus, error) {
+	status := signatureStatus{Repo: repo.FullName}
+	head, err := gitOutput(ctx, repoDir(repo), "rev-parse", "HEAD")
+	if err != nil {
+		return status, err
+	}
+	status.Commit = head
+
+	if keyring != "" {
+		cmd := gitCommand("-C", repoDir(repo), "log", "-1", "--format=%G?%n%GS", head)
+		cmd.Env = append(os.Environ(), "GNUPGHOME="+keyring)
+		out, err := commandOutput(ctx, cmd)
+		if err != nil {
+			return status, err
+		}
+		code, signer, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+		status.Verified = code == "G" || code == "U"
+		status.Reason = gpgStatusReasons[code]
+		status.Signer = signer
+		return status, nil
+	}
+
+	var commit commitVerification
+	if err := getJSON(repoAPIURL(giteaHost, repo)+"/git/commits/"+head, giteaAccessToken, &commit); err != nil {
+		return status, err
+	}
+	verification := commit.Commit.Verification
+	status.Verified = verification.Verified
+	if !verification.Verified {
+		status.Reason = verification.Reason
+	}
+	if verification.Signer != nil {
+		status.Signer = fmt.Sprintf("%s <%s>", verification.Signer.Name, verification.Signer.Email)
+	}
+	return status, nil
+}
+
+// verifySignatures verifies the branch tips of repos, prints the ones that are
+// unsigned or badly signed and writes the full report to
+// .clonegitea/signatures.json. It returns the number of unverified tips.
+func verifySignatures(giteaHost, giteaAccessToken, keyring string, repos []Repository) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	report := make([]signatureStatus, 0, len(repos))
+	unverified := 0
+	for _, repo := range repos {
+		status, err := verifySignature(ctx, giteaHost, giteaAccessToken, keyring, repo)
+		if err != nil {
+			status.Reason = "verification failed: " + err.Error()
+		}
+		if !status.Verified {
+			unverified++
+			fmt.Printf("Unverified signature on %s at %.12s: %s\n", repo.FullName, status.Commit, status.Reason)
+		}
+		report = append(report, status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(signatureReportFile), os.ModePerm); err != nil {
+		return unverified, err
+	}
+	return unverified, writeJSONFile(signatureReportFile, report)
+}
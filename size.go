@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = []string{"B", "K", "M", "G", "T"}
+
+// parseSize parses a size such as 200G, 50M or 1024, using binary units.
+func parseSize(value string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B")
+	multiplier := int64(1)
+	for i := len(sizeUnits) - 1; i > 0; i-- {
+		if strings.HasSuffix(s, sizeUnits[i]) {
+			s = strings.TrimSuffix(s, sizeUnits[i])
+			multiplier = int64(1) << (10 * i)
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	// NaN and sizes beyond int64, e.g. "Inf", compare false here.
+	if err != nil || !(n >= 0 && n*float64(multiplier) < math.MaxInt64) {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// formatSize renders a byte count with a binary unit, e.g. 1.5G.
+func formatSize(n int64) string {
+	size := float64(n)
+	unit := 0
+	for size >= 1024 && unit < len(sizeUnits)-1 {
+		size /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.1f%s", size, sizeUnits[unit])
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "0", want: 0},
+		{value: "1024", want: 1024},
+		{value: "512B", want: 512},
+		{value: "50M", want: 50 << 20},
+		{value: "50mb", want: 50 << 20},
+		{value: " 200G ", want: 200 << 30},
+		{value: "1.5K", want: 1536},
+		{value: "2T", want: 2 << 40},
+		{value: "", wantErr: true},
+		{value: "G", wantErr: true},
+		{value: "-1G", wantErr: true},
+		{value: "12X", wantErr: true},
+		{value: "NaN", wantErr: true},
+		{value: "Inf", wantErr: true},
+		{value: "9000000T", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.value)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1536, "1.5K"},
+		{50 << 20, "50.0M"},
+		{3 << 40, "3.0T"},
+		{5000 << 40, "5000.0T"},
+	}
+	for _, tt := range tests {
+		if got := formatSize(tt.n); got != tt.want {
+			t.Errorf("formatSize(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
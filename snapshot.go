@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// markSnapshots marks the repositories that are stored as a tar.gz snapshot
+// instead of a clone: the archived ones with archived, and those not updated
+// for inactive, when it is not zero. Repositories that already have a clone
+// keep it. It returns how many repositories it marked.
+func markSnapshots(repos []Repository, archived bool, inactive time.Duration) int {
+	n := 0
+	for i := range repos {
+		repo := &repos[i]
+		repo.Snapshot = false
+		if isClone(repoDir(*repo)) {
+			continue
+		}
+		if archived && repo.Archived || inactive > 0 && !repo.UpdatedAt.IsZero() && time.Since(repo.UpdatedAt) > inactive {
+			repo.Snapshot = true
+			n++
+		}
+	}
+	return n
+}
+
+// snapshotCurrent reports whether the snapshot of repo was downloaded after
+// the repository was last updated.
+func snapshotCurrent(repo Repository) bool {
+	info, err := os.Stat(archivePath(repo))
+	return err == nil && info.ModTime().After(repo.UpdatedAt)
+}
+
+// hasSnapshotOnly reports whether repo has a snapshot and, at most, the
+// exports of --with-issues and the like where its clone would be, so that it
+// is cloned when it is no longer stored as a snapshot.
+func hasSnapshotOnly(repo Repository) bool {
+	_, err := os.Stat(archivePath(repo))
+	return err == nil && !isClone(repoDir(repo))
+}
+
+// setAside moves the directory dir out of the way of a clone. The returned
+// function moves its entries into the clone, except those the clone has
+// itself.
+func setAside(dir string) (func() error, error) {
+	aside := dir + ".aside"
+	if err := os.Rename(dir, aside); err != nil {
+		return nil, err
+	}
+	return func() error {
+		entries, err := os.ReadDir(aside)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			dest := filepath.Join(dir, entry.Name())
+			if _, err := os.Stat(dest); err == nil {
+				continue
+			}
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				return err
+			}
+			if err := os.Rename(filepath.Join(aside, entry.Name()), dest); err != nil {
+				return err
+			}
+		}
+		return os.RemoveAll(aside)
+	}, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const knownHostsFile = ".clonegitea/known_hosts"
+
+// sshHost returns the host and port of an SSH clone URL, given either as
+// ssh://user@host:port/path or in scp-like user@host:path form.
+func sshHost(sshURL string) (host, port string, err error) {
+	if strings.Contains(sshURL, "://") {
+		u, err := url.Parse(sshURL)
+		if err != nil {
+			return "", "", err
+		}
+		return u.Hostname(), u.Port(), nil
+	}
+	hostPart, _, ok := strings.Cut(sshURL, ":")
+	if !ok {
+		return "", "", fmt.Errorf("invalid SSH URL %q", sshURL)
+	}
+	if i := strings.LastIndex(hostPart, "@"); i >= 0 {
+		hostPart = hostPart[i+1:]
+	}
+	return hostPart, "", nil
+}
+
+// knownHostsName is how host appears in a known_hosts file.
+func knownHostsName(host, port string) string {
+	if port == "" || port == "22" {
+		return host
+	}
+	return fmt.Sprintf("[%s]:%s", host, port)
+}
+
+// setupSSH makes sure the host keys of the SSH server behind sshURL are in the
+// tool's own known_hosts file, and configures git to use that file with strict
+// host key checking. Unknown keys are fetched with ssh-keyscan and only added
+// after the user confirmed their fingerprints, or when trust is set.
+//
+// With keyFile only that identity is offered, with agentOnly only the keys
+// held by ssh-agent.
+func setupSSH(sshURL string, trust bool, keyFile string, agentOnly bool) error {
+	host, port, err := sshHost(sshURL)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return fmt.Errorf("no host in SSH URL %q", sshURL)
+	}
+	path, err := filepath.Abs(knownHostsFile)
+	if err != nil {
+		return err
+	}
+
+	name := knownHostsName(host, port)
+	if exec.Command("ssh-keygen", "-F", name, "-f", path).Run() != nil {
+		if err := trustHostKeys(path, host, port, trust); err != nil {
+			return err
+		}
+	}
+
+	command := fmt.Sprintf("ssh -o UserKnownHostsFile=%q -o StrictHostKeyChecking=yes", path)
+	switch {
+	case keyFile != "":
+		command += fmt.Sprintf(" -i %q -o IdentitiesOnly=yes", keyFile)
+	case agentOnly:
+		command += " -o IdentityFile=none -o IdentitiesOnly=no -o PasswordAuthentication=no -o KbdInteractiveAuthentication=no"
+	}
+	return os.Setenv("GIT_SSH_COMMAND", command)
+}
+
+func trustHostKeys(path, host, port string, trust bool) error {
+	args := []string{"-q"}
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	keys, err := exec.Command("ssh-keyscan", append(args, host)...).Output()
+	if err != nil || len(keys) == 0 {
+		return fmt.Errorf("fetching SSH host keys of %s failed: %v", host, err)
+	}
+
+	fingerprints := exec.Command("ssh-keygen", "-l", "-f", "-")
+	fingerprints.Stdin = strings.NewReader(string(keys))
+	out, err := fingerprints.Output()
+	if err != nil {
+		return fmt.Errorf("computing host key fingerprints: %w", err)
+	}
+	fmt.Printf("The SSH host keys of %s are not known yet:\n%s", host, out)
+
+	if !trust {
+		if stat, err := os.Stdin.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+			return fmt.Errorf("unknown SSH host keys for %s: run once interactively or pass --trust-host-keys after checking the fingerprints", host)
+		}
+		fmt.Print("Trust these host keys? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return fmt.Errorf("SSH host keys of %s were not trusted", host)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(keys)
+	audit("trust-host-keys", knownHostsName(host, port), path, err)
+	return err
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	stateFile          = ".clonegitea/state.json"
+	queueFlushInterval = 2 * time.Second
+)
+
+// State is everything the tool remembers between runs. It lives in
+// TARGET_DIR/.clonegitea/state.json, or in the StateStore STATE_STORE
+// configures.
+type State struct {
+	Queue []Repository `json:"queue,omitempty"`
+	// DiskUsage has a sample per run with --disk-usage, oldest first.
+	DiskUsage []diskUsageSample `json:"disk_usage,omitempty"`
+	// LastDeepVerify is when --deep-verify-interval last verified the clones.
+	LastDeepVerify *time.Time `json:"last_deep_verify,omitempty"`
+	// Runs has the aggregates of the most recent runs, oldest first.
+	Runs []runRecord `json:"runs,omitempty"`
+	// Activity has the latest activity per repository, by the server's
+	// clock, when it was last cloned or synced, for --changed-only.
+	Activity map[string]time.Time `json:"activity,omitempty"`
+	// Unmatched counts, per clone, the consecutive runs it matched no
+	// repository on the server, for --quarantine-after.
+	Unmatched map[string]int `json:"unmatched,omitempty"`
+}
+
+func loadState() (*State, error) {
+	return stateStore.Load()
+}
+
+func (s *State) save() error {
+	return stateStore.Save(s)
+}
+
+// workQueue tracks the repositories of the current run that still have to be
+// cloned and periodically persists them, so that -resume can pick up an
+// interrupted run.
+type workQueue struct {
+	mu    sync.Mutex
+	state *State
+	dirty bool
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func newWorkQueue(state *State, repos []Repository) (*workQueue, error) {
+	state.Queue = append([]Repository(nil), repos...)
+	if err := state.save(); err != nil {
+		return nil, err
+	}
+	q := &workQueue{state: state, stop: make(chan struct{}), done: make(chan struct{})}
+	go q.flushLoop()
+	return q, nil
+}
+
+// complete removes a finished repository from the queue.
+func (q *workQueue) complete(fullName string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, repo := range q.state.Queue {
+		if repo.FullName == fullName {
+			q.state.Queue = append(q.state.Queue[:i], q.state.Queue[i+1:]...)
+			q.dirty = true
+			return
+		}
+	}
+}
+
+func (q *workQueue) flushLoop() {
+	defer close(q.done)
+	ticker := time.NewTicker(queueFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.stop:
+			q.flushWithWarning()
+			return
+		case <-ticker.C:
+			q.flushWithWarning()
+		}
+	}
+}
+
+func (q *workQueue) flushWithWarning() {
+	if err := q.flush(); err != nil {
+		fmt.Printf("Warning: could not save work queue: %v\n", err)
+	}
+}
+
+func (q *workQueue) flush() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.dirty {
+		return nil
+	}
+	q.dirty = false
+	return q.state.save()
+}
+
+// recordDiskUsage adds sample to the state, which is written with the queue.
+func (q *workQueue) recordDiskUsage(sample diskUsageSample) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.state.DiskUsage = append(q.state.DiskUsage, sample)
+	if len(q.state.DiskUsage) > diskUsageHistory {
+		q.state.DiskUsage = q.state.DiskUsage[len(q.state.DiskUsage)-diskUsageHistory:]
+	}
+	q.dirty = true
+}
+
+// close stops the background flushing and writes the final queue.
+func (q *workQueue) close() {
+	close(q.stop)
+	<-q.done
+}
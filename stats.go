@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type repoStats struct {
+	Name        string    `json:"name"`
+	Commits     int       `json:"commits"`
+	Authors     int       `json:"authors"`
+	FirstCommit time.Time `json:"first_commit"`
+	LastCommit  time.Time `json:"last_commit"`
+}
+
+type authorStats struct {
+	Name         string `json:"name"`
+	Email        string `json:"email"`
+	Commits      int    `json:"commits"`
+	Repositories int    `json:"repositories"`
+}
+
+type monthStats struct {
+	Month   string `json:"month"`
+	Commits int    `json:"commits"`
+}
+
+type mirrorStats struct {
+	TotalCommits int           `json:"total_commits"`
+	Repositories []repoStats   `json:"repositories"`
+	Authors      []authorStats `json:"authors"`
+	Months       []monthStats  `json:"months"`
+}
+
+// commitRecord is one commit of one repository, the unit the statistics are
+// aggregated from.
+type commitRecord struct {
+	Repo  string
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// runStats implements the stats subcommand.
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	format := fs.String("format", "json", "Output format: json or csv")
+	output := fs.String("o", "", "Write the statistics to this file instead of stdout")
+	fs.Parse(args)
+
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("unknown format %q, expected json or csv", *format)
+	}
+
+	config, err := loadConfig("config.env")
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	clones, err := findClones(config["TARGET_DIR"])
+	if err != nil {
+		return fmt.Errorf("scanning target directory: %w", err)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	commits := collectCommits(config["TARGET_DIR"], clones)
+	if *format == "csv" {
+		err = writeStatsCSV(out, commits)
+	} else {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(aggregateStats(clones, commits))
+	}
+	return err
+}
+
+// collectCommits reads the history of the checked out branch of every clone,
+// a few repositories at a time.
+func collectCommits(root string, clones []string) []commitRecord {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		commits []commitRecord
+	)
+	lim := newLimiter(runtime.NumCPU())
+	for _, name := range clones {
+		lim.acquire()
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			defer lim.release()
+			out, err := gitOutput(context.Background(), filepath.Join(root, name), "log", "--format=%an%x09%ae%x09%at", "HEAD")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: could not read history of %s: %v\n", name, err)
+				return
+			}
+			var records []commitRecord
+			for _, line := range strings.Split(out, "\n") {
+				parts := strings.Split(line, "\t")
+				if len(parts) != 3 {
+					continue
+				}
+				ts, err := strconv.ParseInt(parts[2], 10, 64)
+				if err != nil {
+					continue
+				}
+				records = append(records, commitRecord{Repo: name, Name: parts[0], Email: parts[1], When: time.Unix(ts, 0).UTC()})
+			}
+			mu.Lock()
+			commits = append(commits, records...)
+			mu.Unlock()
+		}(name)
+	}
+	wg.Wait()
+	return commits
+}
+
+func aggregateStats(clones []string, commits []commitRecord) mirrorStats {
+	stats := mirrorStats{TotalCommits: len(commits)}
+
+	repos := make(map[string]*repoStats)
+	for _, name := range clones {
+		repos[name] = &repoStats{Name: name}
+	}
+	repoAuthors := make(map[string]map[string]bool)
+	authors := make(map[string]*authorStats)
+	authorRepos := make(map[string]map[string]bool)
+	months := make(map[string]int)
+
+	for _, c := range commits {
+		r := repos[c.Repo]
+		r.Commits++
+		if r.FirstCommit.IsZero() || c.When.Before(r.FirstCommit) {
+			r.FirstCommit = c.When
+		}
+		if c.When.After(r.LastCommit) {
+			r.LastCommit = c.When
+		}
+		if repoAuthors[c.Repo] == nil {
+			repoAuthors[c.Repo] = make(map[string]bool)
+		}
+		repoAuthors[c.Repo][c.Email] = true
+
+		a, ok := authors[c.Email]
+		if !ok {
+			a = &authorStats{Name: c.Name, Email: c.Email}
+			authors[c.Email] = a
+			authorRepos[c.Email] = make(map[string]bool)
+		}
+		a.Commits++
+		authorRepos[c.Email][c.Repo] = true
+
+		months[c.When.Format("2006-01")]++
+	}
+
+	for name, r := range repos {
+		r.Authors = len(repoAuthors[name])
+		stats.Repositories = append(stats.Repositories, *r)
+	}
+	sort.Slice(stats.Repositories, func(i, j int) bool { return stats.Repositories[i].Name < stats.Repositories[j].Name })
+
+	for email, a := range authors {
+		a.Repositories = len(authorRepos[email])
+		stats.Authors = append(stats.Authors, *a)
+	}
+	sort.Slice(stats.Authors, func(i, j int) bool {
+		if stats.Authors[i].Commits != stats.Authors[j].Commits {
+			return stats.Authors[i].Commits > stats.Authors[j].Commits
+		}
+		return stats.Authors[i].Email < stats.Authors[j].Email
+	})
+
+	for month, n := range months {
+		stats.Months = append(stats.Months, monthStats{Month: month, Commits: n})
+	}
+	sort.Slice(stats.Months, func(i, j int) bool { return stats.Months[i].Month < stats.Months[j].Month })
+
+	return stats
+}
+
+// writeStatsCSV writes one row per repository, author and month, which is easy
+// to pivot in a spreadsheet.
+func writeStatsCSV(w io.Writer, commits []commitRecord) error {
+	type key struct{ repo, name, email, month string }
+	counts := make(map[key]int)
+	for _, c := range commits {
+		counts[key{c.Repo, c.Name, c.Email, c.When.Format("2006-01")}]++
+	}
+	keys := make([]key, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.repo != b.repo {
+			return a.repo < b.repo
+		}
+		if a.month != b.month {
+			return a.month < b.month
+		}
+		return a.email < b.email
+	})
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"repository", "author", "email", "month", "commits"})
+	for _, k := range keys {
+		cw.Write([]string{k.repo, k.name, k.email, k.month, strconv.Itoa(counts[k])})
+	}
+	cw.Flush()
+	return cw.Error()
+}
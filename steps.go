@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// repoJob is a repository a run has backed up, for the postCloneSteps.
+type repoJob struct {
+	opts   *options
+	server serverInfo
+	repo   Repository
+	// format is what the repository was backed up as, a snapshot may be
+	// kept as tar.gz in a run of clones.
+	format string
+	res    *Result
+}
+
+// postCloneStep is work done on a repository once it is backed up.
+type postCloneStep struct {
+	// fetched limits the step to repositories cloned or synced by the run.
+	fetched bool
+	// enabled reports whether the options ask for the step, nil for always.
+	enabled func(opts *options) bool
+	// run reports its own problems, it only returns an error when the
+	// repository failed.
+	run func(ctx context.Context, job *repoJob) error
+}
+
+// postCloneSteps run in order after a repository is backed up, as long as
+// nothing failed.
+var postCloneSteps = []postCloneStep{
+	{fetched: true, run: checkoutPinnedRef},
+	{fetched: true, enabled: func(opts *options) bool { return opts.fetchPRRefs }, run: fetchPullRefsStep},
+	{fetched: true, enabled: func(opts *options) bool { return opts.checksums }, run: writeChecksumsStep},
+	{run: detectToolchainsStep},
+	{enabled: func(opts *options) bool { return opts.archive != "" }, run: archiveStep},
+	{enabled: func(opts *options) bool { return opts.withIssues }, run: exportIssuesStep},
+	{enabled: func(opts *options) bool { return opts.withPulls }, run: exportPullsStep},
+	{enabled: func(opts *options) bool { return opts.withArtifacts }, run: exportArtifactsStep},
+	{enabled: func(opts *options) bool { return opts.withSettings }, run: exportSettingsStep},
+	{enabled: func(opts *options) bool { return opts.withPlanning }, run: exportPlanningStep},
+}
+
+// runPostCloneSteps runs the postCloneSteps that apply to job.
+func runPostCloneSteps(ctx context.Context, job *repoJob) {
+	fetched := job.res.Action == "cloned" || job.res.Action == "synced"
+	for _, step := range postCloneSteps {
+		if job.res.Err != nil {
+			return
+		}
+		if (step.fetched && !fetched) || (step.enabled != nil && !step.enabled(job.opts)) {
+			continue
+		}
+		job.res.Err = step.run(ctx, job)
+	}
+}
+
+// checkoutPinnedRef checks out the ref --repos asks for. A clone that is no
+// longer pinned loses its mark, so that health reports it when it is still
+// detached.
+func checkoutPinnedRef(ctx context.Context, job *repoJob) error {
+	if job.repo.Ref == "" {
+		// Fails harmlessly for a clone that was never pinned.
+		gitOutput(ctx, repoDir(job.repo), "config", "--unset", pinnedKey)
+		return nil
+	}
+	if err := checkoutRef(ctx, repoDir(job.repo), job.repo.Ref); err != nil {
+		return fmt.Errorf("checking out %s: %w", job.repo.Ref, err)
+	}
+	fmt.Printf("Checked out %s in %s\n", job.repo.Ref, job.repo.FullName)
+	return nil
+}
+
+func fetchPullRefsStep(ctx context.Context, job *repoJob) error {
+	if matchesAny(job.repo.FullName, job.opts.tagsOnly) {
+		return nil
+	}
+	if err := fetchPullRefs(ctx, repoDir(job.repo)); err != nil {
+		fmt.Printf("Error fetching pull request refs of %s: %v\n", job.repo.FullName, err)
+	}
+	return nil
+}
+
+func writeChecksumsStep(ctx context.Context, job *repoJob) error {
+	if err := writeChecksums(ctx, repoDir(job.repo)); err != nil {
+		fmt.Printf("Error writing checksums of %s: %v\n", job.repo.FullName, err)
+	}
+	return nil
+}
+
+func detectToolchainsStep(ctx context.Context, job *repoJob) error {
+	if job.format != formatGit || !isClone(repoDir(job.repo)) {
+		return nil
+	}
+	var err error
+	if job.res.Toolchains, err = detectToolchains(ctx, repoDir(job.repo)); err != nil {
+		fmt.Printf("Warning: cannot detect the toolchains of %s: %v\n", job.repo.FullName, err)
+	}
+	return nil
+}
+
+// archiveStep packs the clone again when it changed or its archive is
+// missing.
+func archiveStep(ctx context.Context, job *repoJob) error {
+	_, statErr := os.Stat(archiveFile(job.repo, job.opts.archive))
+	if job.res.Action == "skipped" && !os.IsNotExist(statErr) {
+		return nil
+	}
+	if dest, err := packRepository(ctx, job.repo, job.opts.archive, job.opts.archiveSum); err != nil {
+		fmt.Printf("Error archiving %s: %v\n", job.repo.FullName, err)
+	} else {
+		fmt.Printf("Archived %s into %s\n", job.repo.FullName, dest)
+	}
+	return nil
+}
+
+func exportIssuesStep(ctx context.Context, job *repoJob) error {
+	if err := exportIssues(job.opts.giteaHost, job.opts.giteaAccessToken, job.repo, job.opts.issuesMarkdown); err != nil {
+		fmt.Printf("Error exporting issues of %s: %v\n", job.repo.FullName, err)
+	}
+	return nil
+}
+
+func exportPullsStep(ctx context.Context, job *repoJob) error {
+	if err := exportPulls(job.opts.giteaHost, job.opts.giteaAccessToken, job.repo); err != nil {
+		fmt.Printf("Error exporting pull requests of %s: %v\n", job.repo.FullName, err)
+	}
+	return nil
+}
+
+func exportArtifactsStep(ctx context.Context, job *repoJob) error {
+	if err := exportArtifacts(job.opts.giteaHost, job.opts.giteaAccessToken, job.server, job.repo); err != nil {
+		fmt.Printf("Error exporting Actions artifacts of %s: %v\n", job.repo.FullName, err)
+	}
+	return nil
+}
+
+func exportSettingsStep(ctx context.Context, job *repoJob) error {
+	if err := exportSettings(job.opts.giteaHost, job.opts.giteaAccessToken, job.repo); err != nil {
+		fmt.Printf("Error exporting settings of %s: %v\n", job.repo.FullName, err)
+	}
+	return nil
+}
+
+func exportPlanningStep(ctx context.Context, job *repoJob) error {
+	if err := exportPlanning(job.opts.giteaHost, job.opts.giteaAccessToken, job.repo); err != nil {
+		fmt.Printf("Error exporting labels and milestones of %s: %v\n", job.repo.FullName, err)
+	}
+	return nil
+}
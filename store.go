@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StateStore keeps the State between runs. Stores that several backup nodes
+// share also let a node claim a repository, so that no two nodes clone or
+// sync the same one at the same time.
+type StateStore interface {
+	Load() (*State, error)
+	Save(state *State) error
+	// Claim reserves repo for this node until Release and reports whether no
+	// other node held it. The store renews the claim while it is held, so
+	// that it outlasts however long the repository takes, and it expires ttl
+	// after this node stopped renewing it, e.g. because it died.
+	Claim(repo string, ttl time.Duration) (bool, error)
+	Release(repo string) error
+}
+
+// stateStore is the store of the run, configured with STATE_STORE.
+var stateStore StateStore = fileStateStore{path: stateFile}
+
+// claimTTL is how long the claims of a node that died keep other nodes off
+// its repositories.
+const claimTTL = 2 * time.Minute
+
+// parseStateStore parses a STATE_STORE value: empty or file for the state
+// file in the target directory, or a redis:// or rediss:// URL.
+func parseStateStore(value string) (*url.URL, error) {
+	if value == "" || value == "file" {
+		return nil, nil
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		if u.Host == "" {
+			return nil, fmt.Errorf("%s has no host", u.Redacted())
+		}
+		if db := strings.Trim(u.Path, "/"); db != "" {
+			if _, err := strconv.Atoi(db); err != nil {
+				return nil, fmt.Errorf("invalid database %q, expected a number as in redis://host:6379/0", db)
+			}
+		}
+		return u, nil
+	}
+	return nil, fmt.Errorf("unknown state store %q, expected file or a redis:// URL", value)
+}
+
+// openStateStore returns the store STATE_STORE configures, with the state
+// file below targetDir. The keys of a shared store are namespaced by the
+// Gitea host, so that nodes mirroring different servers can use the same
+// Redis.
+func openStateStore(value, giteaHost, targetDir string) (StateStore, error) {
+	u, err := parseStateStore(value)
+	if err != nil || u == nil {
+		return fileStateStore{path: filepath.Join(targetDir, stateFile)}, err
+	}
+	host, _ := os.Hostname()
+	store := &redisStateStore{
+		url:    u,
+		prefix: "cloneallgitea:" + giteaHost + ":",
+		host:   host,
+		node:   fmt.Sprintf("%s:%d", host, os.Getpid()),
+		held:   map[string]chan struct{}{},
+	}
+	// Fail before the run rather than at its first save.
+	if _, err := store.do("PING"); err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", u.Redacted(), err)
+	}
+	return store, nil
+}
+
+// fileStateStore keeps the state in TARGET_DIR/.clonegitea/state.json. The
+// run's lock file already keeps other runs out, so claims always succeed.
+type fileStateStore struct {
+	path string
+}
+
+func (s fileStateStore) Load() (*State, error) {
+	state := &State{}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Save writes the state atomically so an interrupted run never leaves a
+// truncated file behind.
+func (s fileStateStore) Save(state *State) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s fileStateStore) Claim(repo string, ttl time.Duration) (bool, error) { return true, nil }
+
+func (s fileStateStore) Release(repo string) error { return nil }
+
+// releaseScript deletes a claim only if this node still holds it, so that a
+// claim that expired and was taken over by another node is left alone.
+const releaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+
+// renewScript extends a claim by ARGV[2] milliseconds if this node still
+// holds it.
+const renewScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`
+
+// repoState is what the state has about one repository.
+type repoState struct {
+	Activity  *time.Time `json:"activity,omitempty"`
+	Unmatched int        `json:"unmatched,omitempty"`
+}
+
+// stateFields splits the state into the fields a shared store keeps it in:
+// one per repository, run and disk usage sample, and the queue of --resume
+// per host. A node then only writes the fields it changed, so that saving
+// never undoes what other nodes saved in the meantime.
+func stateFields(state *State, host string) (map[string]string, error) {
+	fields := map[string]string{}
+	set := func(field string, v interface{}) error {
+		data, err := json.Marshal(v)
+		fields[field] = string(data)
+		return err
+	}
+	repos := map[string]*repoState{}
+	repo := func(name string) *repoState {
+		if repos[name] == nil {
+			repos[name] = &repoState{}
+		}
+		return repos[name]
+	}
+	for name, at := range state.Activity {
+		at := at
+		repo(name).Activity = &at
+	}
+	for name, n := range state.Unmatched {
+		repo(name).Unmatched = n
+	}
+	for name, r := range repos {
+		if err := set("repo:"+name, r); err != nil {
+			return nil, err
+		}
+	}
+	if len(state.Queue) > 0 {
+		if err := set("queue:"+host, state.Queue); err != nil {
+			return nil, err
+		}
+	}
+	for _, run := range state.Runs {
+		if err := set("run:"+run.Started.UTC().Format(time.RFC3339Nano), run); err != nil {
+			return nil, err
+		}
+	}
+	for _, sample := range state.DiskUsage {
+		if err := set("disk:"+sample.Time.UTC().Format(time.RFC3339Nano), sample); err != nil {
+			return nil, err
+		}
+	}
+	if state.LastDeepVerify != nil {
+		if err := set("deep_verify", state.LastDeepVerify); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// stateFromFields puts the fields of stateFields back together, with the
+// queue of host.
+func stateFromFields(fields map[string]string, host string) (*State, error) {
+	state := &State{}
+	for field, value := range fields {
+		kind, key, _ := strings.Cut(field, ":")
+		var err error
+		switch kind {
+		case "repo":
+			var r repoState
+			if err = json.Unmarshal([]byte(value), &r); err != nil {
+				break
+			}
+			if r.Activity != nil {
+				if state.Activity == nil {
+					state.Activity = map[string]time.Time{}
+				}
+				state.Activity[key] = *r.Activity
+			}
+			if r.Unmatched > 0 {
+				if state.Unmatched == nil {
+					state.Unmatched = map[string]int{}
+				}
+				state.Unmatched[key] = r.Unmatched
+			}
+		case "queue":
+			if key == host {
+				err = json.Unmarshal([]byte(value), &state.Queue)
+			}
+		case "run":
+			var run runRecord
+			err = json.Unmarshal([]byte(value), &run)
+			state.Runs = append(state.Runs, run)
+		case "disk":
+			var sample diskUsageSample
+			err = json.Unmarshal([]byte(value), &sample)
+			state.DiskUsage = append(state.DiskUsage, sample)
+		case "deep_verify":
+			err = json.Unmarshal([]byte(value), &state.LastDeepVerify)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("state field %s: %w", field, err)
+		}
+	}
+	sort.Slice(state.Runs, func(i, j int) bool { return state.Runs[i].Started.Before(state.Runs[j].Started) })
+	sort.Slice(state.DiskUsage, func(i, j int) bool { return state.DiskUsage[i].Time.Before(state.DiskUsage[j].Time) })
+	// Nodes append to the histories concurrently, so they are trimmed here
+	// as well as where they grow.
+	if len(state.Runs) > runHistory {
+		state.Runs = state.Runs[len(state.Runs)-runHistory:]
+	}
+	if len(state.DiskUsage) > diskUsageHistory {
+		state.DiskUsage = state.DiskUsage[len(state.DiskUsage)-diskUsageHistory:]
+	}
+	return state, nil
+}
+
+// fieldChanges tracks the fields a shared store last read or wrote, to tell
+// which fields a save has to write and which to delete.
+type fieldChanges struct {
+	mu   sync.Mutex
+	base map[string]string
+}
+
+// load makes the fields just read the base, except for the queues of other
+// hosts, which are not this node's to delete. Records trimmed from the
+// histories stay in the base, so that the next save deletes them.
+func (c *fieldChanges) load(fields map[string]string, host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.base = map[string]string{}
+	for field, value := range fields {
+		if strings.HasPrefix(field, "queue:") && field != "queue:"+host {
+			continue
+		}
+		c.base[field] = value
+	}
+}
+
+// diff returns the fields that differ from the base and those that are gone.
+func (c *fieldChanges) diff(fields map[string]string) (changed map[string]string, removed []string) {
+	changed = map[string]string{}
+	for field, value := range fields {
+		if old, ok := c.base[field]; !ok || old != value {
+			changed[field] = value
+		}
+	}
+	for field := range c.base {
+		if _, ok := fields[field]; !ok {
+			removed = append(removed, field)
+		}
+	}
+	sort.Strings(removed)
+	return changed, removed
+}
+
+// redisStateStore keeps the state in a Redis hash with a field per
+// repository and record, see stateFields, and claims repositories with keys
+// that expire, so that the claims of a node that died do not block the others
+// for good. It speaks the Redis protocol itself over one connection, which
+// is plenty for a save every few seconds and a claim per repository.
+type redisStateStore struct {
+	url    *url.URL
+	prefix string
+	// host is the machine, whose queue of --resume the store loads.
+	host string
+	// node identifies this process in the claims it holds.
+	node    string
+	changes fieldChanges
+
+	// mu serializes the commands on conn, which is dialed on first use and
+	// again after an error.
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+
+	// held stops the renewal of each claim this node holds.
+	heldMu sync.Mutex
+	held   map[string]chan struct{}
+}
+
+func (s *redisStateStore) Load() (*State, error) {
+	reply, err := s.do("HGETALL", s.prefix+"state")
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+	fields := map[string]string{}
+	for i := 0; i+1 < len(items); i += 2 {
+		field, _ := items[i].(string)
+		value, _ := items[i+1].(string)
+		fields[field] = value
+	}
+	state, err := stateFromFields(fields, s.host)
+	if err != nil {
+		return nil, err
+	}
+	s.changes.load(fields, s.host)
+	return state, nil
+}
+
+// Save writes the fields that changed since the last load or save and
+// deletes those that are gone, leaving the fields other nodes changed alone.
+func (s *redisStateStore) Save(state *State) error {
+	fields, err := stateFields(state, s.host)
+	if err != nil {
+		return err
+	}
+	s.changes.mu.Lock()
+	defer s.changes.mu.Unlock()
+	changed, removed := s.changes.diff(fields)
+	if len(changed) > 0 {
+		args := []string{"HSET", s.prefix + "state"}
+		for field, value := range changed {
+			args = append(args, field, value)
+		}
+		if _, err := s.do(args...); err != nil {
+			return err
+		}
+	}
+	if len(removed) > 0 {
+		if _, err := s.do(append([]string{"HDEL", s.prefix + "state"}, removed...)...); err != nil {
+			return err
+		}
+	}
+	s.changes.base = fields
+	return nil
+}
+
+func (s *redisStateStore) Claim(repo string, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		ttl = claimTTL
+	}
+	key := s.prefix + "claim:" + repo
+	ms := strconv.FormatInt(ttl.Milliseconds(), 10)
+	reply, err := s.do("SET", key, s.node, "NX", "PX", ms)
+	if err != nil || reply == nil {
+		return false, err
+	}
+	stop := make(chan struct{})
+	s.heldMu.Lock()
+	s.held[repo] = stop
+	s.heldMu.Unlock()
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := s.do("EVAL", renewScript, "1", key, s.node, ms); err != nil {
+					fmt.Printf("Warning: could not renew the claim on %s: %v\n", repo, err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return true, nil
+}
+
+func (s *redisStateStore) Release(repo string) error {
+	s.heldMu.Lock()
+	if stop, ok := s.held[repo]; ok {
+		close(stop)
+		delete(s.held, repo)
+	}
+	s.heldMu.Unlock()
+	_, err := s.do("EVAL", releaseScript, "1", s.prefix+"claim:"+repo, s.node)
+	return err
+}
+
+// do runs one command. It returns the reply as a string, an int64, a
+// []interface{} or nil. After an error other than an error reply the
+// connection is closed, since it may be broken or have a reply still on its
+// way, and the next command dials a new one.
+func (s *redisStateStore) do(args ...string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if err := s.dial(); err != nil {
+			return nil, err
+		}
+	}
+	s.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	reply, err := s.send(args)
+	if err != nil {
+		var replyErr redisError
+		if !errors.As(err, &replyErr) {
+			s.conn.Close()
+			s.conn = nil
+		}
+		return nil, fmt.Errorf("redis %s: %w", args[0], err)
+	}
+	return reply, nil
+}
+
+// redisError is an error reply, after which the connection can still be used.
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// send writes command to the connection and reads its reply.
+func (s *redisStateStore) send(command []string) (interface{}, error) {
+	w := bufio.NewWriter(s.conn)
+	fmt.Fprintf(w, "*%d\r\n", len(command))
+	for _, arg := range command {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return readRESP(s.r)
+}
+
+// dial connects to Redis, authenticates and selects the database.
+func (s *redisStateStore) dial() error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if s.url.Scheme == "rediss" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.url.Host, &tls.Config{ServerName: s.url.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", s.url.Host)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	s.conn, s.r = conn, bufio.NewReader(conn)
+
+	var commands [][]string
+	if user := s.url.User; user != nil {
+		// redis://:password@host authenticates as the default user.
+		password, ok := user.Password()
+		switch {
+		case !ok:
+			commands = append(commands, []string{"AUTH", user.Username()})
+		case user.Username() == "":
+			commands = append(commands, []string{"AUTH", password})
+		default:
+			commands = append(commands, []string{"AUTH", user.Username(), password})
+		}
+	}
+	if db := strings.Trim(s.url.Path, "/"); db != "" && db != "0" {
+		commands = append(commands, []string{"SELECT", db})
+	}
+	for _, command := range commands {
+		if _, err := s.send(command); err != nil {
+			conn.Close()
+			s.conn = nil
+			return fmt.Errorf("redis %s: %w", command[0], err)
+		}
+	}
+	return nil
+}
+
+// readRESP reads one reply of the Redis protocol.
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStateFieldsRoundTrip(t *testing.T) {
+	at := func(day int) time.Time { return time.Date(2024, 1, day, 12, 0, 0, 0, time.UTC) }
+	verified := at(9)
+	state := &State{
+		Queue:          []Repository{{ID: 1, FullName: "alice/one"}, {ID: 2, FullName: "bob/two"}},
+		DiskUsage:      []diskUsageSample{{Time: at(1), Total: 100, Owners: map[string]int64{"alice": 100}}, {Time: at(2), Total: 150, Owners: map[string]int64{"alice": 100, "bob": 50}}},
+		LastDeepVerify: &verified,
+		Runs:           []runRecord{{Started: at(1), Repositories: 2, Cloned: 2}, {Started: at(2), Repositories: 2, Synced: 1, Failed: 1, Growth: []repoGrowth{{Repository: "bob/two", Fetched: 50}}}},
+		Activity:       map[string]time.Time{"alice/one": at(3), "bob/two": at(4)},
+		Unmatched:      map[string]int{"bob/two": 1, "carol/gone": 3},
+	}
+	fields, err := stateFields(state, "https://a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := stateFromFields(fields, "https://a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, state) {
+		t.Errorf("stateFromFields(stateFields(state)) = %+v, want %+v", got, state)
+	}
+
+	// Another host sees everything but the queue.
+	other, err := stateFromFields(fields, "https://b.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Queue != nil || len(other.Runs) != 2 || len(other.Activity) != 2 {
+		t.Errorf("the state for another host is %+v, want all but the queue", other)
+	}
+
+	if got, err := stateFromFields(map[string]string{}, "https://a.example.com"); err != nil || !reflect.DeepEqual(got, &State{}) {
+		t.Errorf("stateFromFields of no fields = %+v, %v, want an empty state", got, err)
+	}
+	if _, err := stateFromFields(map[string]string{"repo:alice/one": "{"}, "https://a.example.com"); err == nil {
+		t.Error("stateFromFields accepted a broken field")
+	}
+}
+
+// TestStateFieldsMerge puts the fields two nodes saved together, as they end
+// up in the shared store.
+func TestStateFieldsMerge(t *testing.T) {
+	started := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	merged := map[string]string{}
+	for i, host := range []string{"https://a.example.com", "https://b.example.com"} {
+		state := &State{
+			Queue:    []Repository{{ID: int64(i), FullName: "queued/repo"}},
+			Activity: map[string]time.Time{host: started},
+		}
+		for run := 0; run < runHistory; run++ {
+			// The runs of the two nodes interleave.
+			state.Runs = append(state.Runs, runRecord{Started: started.Add(time.Duration(2*run+i) * time.Minute)})
+		}
+		fields, err := stateFields(state, host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for field, value := range fields {
+			merged[field] = value
+		}
+	}
+
+	state, err := stateFromFields(merged, "https://b.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Queue) != 1 || state.Queue[0].ID != 1 {
+		t.Errorf("the queue is %+v, want the one of b", state.Queue)
+	}
+	if len(state.Activity) != 2 {
+		t.Errorf("the activity is %v, want both nodes'", state.Activity)
+	}
+	if len(state.Runs) != runHistory {
+		t.Fatalf("%d runs are kept, want %d", len(state.Runs), runHistory)
+	}
+	for i, run := range state.Runs {
+		if want := started.Add(time.Duration(runHistory+i) * time.Minute); !run.Started.Equal(want) {
+			t.Fatalf("run %d started at %v, want %v: the newest runs, oldest first", i, run.Started, want)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// repoChanges describes what a sync brought into an existing clone.
+type repoChanges struct {
+	RepoName    string
+	OldHead     string
+	NewHead     string
+	NewCommits  int
+	NewBranches []string
+	NewTags     []string
+	Shortlog    string
+}
+
+func (c *repoChanges) empty() bool {
+	return c.OldHead == c.NewHead && len(c.NewBranches) == 0 && len(c.NewTags) == 0
+}
+
+// Strategies for clones with local modifications, see syncOptions.
+const (
+	dirtySkip  = "skip"
+	dirtyStash = "stash"
+	dirtyReset = "reset"
+)
+
+// Strategies for integrating the upstream branch, see syncOptions.
+const (
+	pullFFOnly = "ff-only"
+	pullRebase = "rebase"
+	pullMerge  = "merge"
+)
+
+// remoteName is the name of the remote clones track the server with, set with
+// REMOTE_NAME.
+var remoteName = "origin"
+
+// checkRemoteName validates a REMOTE_NAME value.
+func checkRemoteName(name string) error {
+	if name == "" || strings.HasPrefix(name, "-") || strings.HasPrefix(name, ".") || strings.ContainsAny(name, " \t/\\:~^?*[") || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid REMOTE_NAME %q", name)
+	}
+	return nil
+}
+
+// renameRemote renames the origin remote of a clone made before REMOTE_NAME
+// was set to remoteName.
+func renameRemote(ctx context.Context, dir string) error {
+	if remoteName == "origin" {
+		return nil
+	}
+	if _, err := gitOutput(ctx, dir, "remote", "get-url", remoteName); err == nil {
+		return nil
+	}
+	if _, err := gitOutput(ctx, dir, "remote", "get-url", "origin"); err != nil {
+		return nil
+	}
+	_, err := gitOutput(ctx, dir, "remote", "rename", "origin", remoteName)
+	audit("rename-remote", dir, "origin "+remoteName, err)
+	if err != nil {
+		return fmt.Errorf("renaming remote origin to %s: %w", remoteName, err)
+	}
+	fmt.Printf("Renamed remote origin of %s to %s\n", dir, remoteName)
+	return nil
+}
+
+// errNotFastForward reports a clone whose branch has diverged from upstream.
+var errNotFastForward = errors.New("local branch has diverged from the server and cannot be fast-forwarded")
+
+// syncOptions controls how gitSync updates a clone.
+type syncOptions struct {
+	// fetchArgs are passed on to git fetch.
+	fetchArgs []string
+	// dirty decides what happens to a working tree with local modifications:
+	// dirtySkip leaves it alone, dirtyStash stashes the modifications and
+	// reapplies them after the update, dirtyReset discards them and resets
+	// the branch to its upstream.
+	dirty string
+	// pull is pullFFOnly, pullRebase or pullMerge.
+	pull string
+}
+
+// gitSync fetches an existing clone and fast-forwards its checked out branch,
+// returning what changed.
+func gitSync(ctx context.Context, dir string, opts syncOptions) (*repoChanges, error) {
+	changes := &repoChanges{RepoName: dir}
+	if err := renameRemote(ctx, dir); err != nil {
+		return nil, err
+	}
+
+	oldRefs, err := gitRefs(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	changes.OldHead, _ = gitOutput(ctx, dir, "rev-parse", "HEAD")
+
+	args := append(append([]string{"fetch", "--tags"}, gitTransportArgs()...), opts.fetchArgs...)
+	_, err = gitOutput(ctx, dir, append(args, remoteName)...)
+	audit("fetch", dir, "", err)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gitOutput(ctx, dir, "rev-parse", "--abbrev-ref", "@{u}"); err == nil {
+		if err := updateWorkingTree(ctx, dir, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	newRefs, err := gitRefs(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	changes.NewHead, _ = gitOutput(ctx, dir, "rev-parse", "HEAD")
+
+	for ref := range newRefs {
+		if _, ok := oldRefs[ref]; ok {
+			continue
+		}
+		if strings.HasPrefix(ref, "refs/tags/") {
+			changes.NewTags = append(changes.NewTags, strings.TrimPrefix(ref, "refs/tags/"))
+		} else if !strings.HasSuffix(ref, "/HEAD") {
+			changes.NewBranches = append(changes.NewBranches, strings.TrimPrefix(ref, branchRefs(dir)))
+		}
+	}
+	sort.Strings(changes.NewTags)
+	sort.Strings(changes.NewBranches)
+
+	if changes.OldHead != "" && changes.OldHead != changes.NewHead {
+		revRange := changes.OldHead + ".." + changes.NewHead
+		if count, err := gitOutput(ctx, dir, "rev-list", "--count", revRange); err == nil {
+			fmt.Sscanf(count, "%d", &changes.NewCommits)
+		}
+		changes.Shortlog, _ = gitOutput(ctx, dir, "shortlog", "-s", "-n", revRange)
+	}
+
+	return changes, nil
+}
+
+// updateWorkingTree brings the checked out branch up to date with its
+// upstream, handling local modifications according to opts.dirty.
+func updateWorkingTree(ctx context.Context, dir string, opts syncOptions) error {
+	status, err := gitOutput(ctx, dir, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return err
+	}
+	dirty := status != ""
+
+	switch {
+	case dirty && opts.dirty == dirtyReset:
+		_, err := gitOutput(ctx, dir, "reset", "--hard", "@{u}")
+		audit("reset", dir, "--hard @{u}", err)
+		return err
+	case dirty && opts.dirty == dirtyStash:
+		if _, err := gitOutput(ctx, dir, "stash", "push", "-m", "cloneAllGitea sync"); err != nil {
+			return fmt.Errorf("stashing local modifications: %w", err)
+		}
+		audit("stash", dir, "push", nil)
+	case dirty:
+		fmt.Printf("Warning: %s has local modifications, not updating its working tree\n", dir)
+		return nil
+	}
+
+	err = integrateUpstream(ctx, dir, opts.pull)
+	if dirty {
+		if _, popErr := gitOutput(ctx, dir, "stash", "pop"); popErr != nil {
+			return fmt.Errorf("reapplying local modifications failed, they are kept in git stash: %w", popErr)
+		}
+	}
+	return err
+}
+
+// integrateUpstream updates the checked out branch with its upstream using
+// strategy. With pullFFOnly a branch that has diverged is left alone and
+// errNotFastForward is returned.
+func integrateUpstream(ctx context.Context, dir, strategy string) error {
+	var args []string
+	switch strategy {
+	case pullRebase:
+		args = []string{"rebase", "@{u}"}
+	case pullMerge:
+		args = []string{"merge", "--no-edit", "@{u}"}
+	default:
+		if _, err := gitOutput(ctx, dir, "merge-base", "--is-ancestor", "HEAD", "@{u}"); err != nil {
+			return errNotFastForward
+		}
+		args = []string{"merge", "--ff-only", "@{u}"}
+	}
+
+	_, err := gitOutput(ctx, dir, args...)
+	audit(args[0], dir, strings.Join(args[1:], " "), err)
+	if err != nil && strategy != pullFFOnly {
+		gitOutput(ctx, dir, args[0], "--abort")
+	}
+	return err
+}
+
+func gitRefs(ctx context.Context, dir string) (map[string]string, error) {
+	out, err := gitOutput(ctx, dir, "for-each-ref", "--format=%(refname) %(objectname)", branchRefs(dir), "refs/tags")
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if parts := strings.Fields(line); len(parts) == 2 {
+			refs[parts[0]] = parts[1]
+		}
+	}
+	return refs, nil
+}
+
+// gitOutput runs a git command inside dir and returns its trimmed stdout.
+func gitOutput(ctx context.Context, dir string, args ...string) (string, error) {
+	out, err := commandOutput(ctx, gitCommand(append([]string{"-C", dir}, args...)...))
+	return strings.TrimSpace(string(out)), err
+}
+
+// writeChangeReport renders the changes of a sync run as Markdown.
+func writeChangeReport(path string, changes []*repoChanges) error {
+	var b strings.Builder
+	b.WriteString("# Changes since last sync\n")
+	for _, c := range changes {
+		fmt.Fprintf(&b, "\n## %s\n\n", c.RepoName)
+		if c.OldHead != c.NewHead {
+			fmt.Fprintf(&b, "- %d new commit(s): `%.12s..%.12s`\n", c.NewCommits, c.OldHead, c.NewHead)
+		}
+		if len(c.NewBranches) > 0 {
+			fmt.Fprintf(&b, "- New branches: %s\n", strings.Join(c.NewBranches, ", "))
+		}
+		if len(c.NewTags) > 0 {
+			fmt.Fprintf(&b, "- New tags: %s\n", strings.Join(c.NewTags, ", "))
+		}
+		if c.Shortlog != "" {
+			fmt.Fprintf(&b, "\n```\n%s\n```\n", c.Shortlog)
+		}
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// excludeFromGit adds pattern to the clone's .git/info/exclude, so files the
+// tool writes into a working tree do not show up as untracked changes. It does
+// nothing when dir is not a clone, e.g. in tar.gz mode.
+func excludeFromGit(dir, pattern string) error {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		return nil
+	}
+	// In a linked worktree .git is a file and info/exclude is in the
+	// repository it belongs to.
+	path, err := gitOutput(context.Background(), dir, "rev-parse", "--git-path", "info/exclude")
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == pattern {
+			return nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		pattern = "\n" + pattern
+	}
+	_, err = f.WriteString(pattern + "\n")
+	return err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+)
+
+// toolchainMarkers maps the files that mark a project to its toolchain.
+var toolchainMarkers = map[string]string{
+	"go.mod":           "go",
+	"package.json":     "node",
+	"Cargo.toml":       "rust",
+	"pyproject.toml":   "python",
+	"setup.py":         "python",
+	"requirements.txt": "python",
+	"pom.xml":          "maven",
+	"build.gradle":     "gradle",
+	"build.gradle.kts": "gradle",
+	"Gemfile":          "ruby",
+	"composer.json":    "php",
+	"mix.exs":          "elixir",
+	"CMakeLists.txt":   "cmake",
+	"Dockerfile":       "docker",
+}
+
+// vendoredDirs hold the projects of dependencies, whose markers say nothing
+// about the repository itself.
+var vendoredDirs = []string{"vendor", "node_modules", "third_party", "testdata"}
+
+// detectToolchains returns the toolchains whose marker files are committed
+// anywhere in HEAD of the clone in dir, sorted. It reads the tree from git
+// rather than the working tree, so it works for bare repositories and sees
+// neither build output nor untracked files.
+func detectToolchains(ctx context.Context, dir string) ([]string, error) {
+	if _, err := gitOutput(ctx, dir, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		// An empty repository has nothing to build.
+		return nil, nil
+	}
+	files, err := gitOutput(ctx, dir, "ls-tree", "-r", "-z", "--name-only", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]bool{}
+	for _, file := range strings.Split(files, "\x00") {
+		toolchain, ok := toolchainMarkers[path.Base(file)]
+		if !ok || found[toolchain] || isVendored(file) {
+			continue
+		}
+		found[toolchain] = true
+	}
+	toolchains := make([]string, 0, len(found))
+	for toolchain := range found {
+		toolchains = append(toolchains, toolchain)
+	}
+	sort.Strings(toolchains)
+	return toolchains, nil
+}
+
+func isVendored(file string) bool {
+	for _, part := range strings.Split(path.Dir(file), "/") {
+		if contains(vendoredDirs, part) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dashboardRefresh  = 250 * time.Millisecond
+	dashboardFailures = 5
+	dashboardLogLines = 5
+)
+
+// dashboard is the full-screen view of --tui. While it runs, everything the
+// program prints goes to its log pane instead of the terminal.
+type dashboard struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	term     *os.File
+	pipe     *os.File
+	sttyMode string
+	quit     context.CancelFunc
+	resized  func()
+
+	start     time.Time
+	total     int
+	done      int
+	failed    int
+	bytes     int64
+	paused    bool
+	selected  int
+	workers   []*dashboardWorker
+	failures  []string
+	log       []string
+	width     int
+	quitting  bool
+	stop      chan struct{}
+	stoppedWg sync.WaitGroup
+}
+
+type dashboardWorker struct {
+	repo     string
+	started  time.Time
+	progress string
+	cancel   context.CancelFunc
+	skipped  bool
+}
+
+type progressKey struct{}
+
+// withProgress makes git commands run with ctx report their progress lines to
+// report, in addition to any reporter ctx already has.
+func withProgress(ctx context.Context, report func(string)) context.Context {
+	if previous, _ := ctx.Value(progressKey{}).(func(string)); previous != nil {
+		next := report
+		report = func(line string) {
+			previous(line)
+			next(line)
+		}
+	}
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+// progressWriter returns the writer git's progress output should go to when
+// ctx was set up by withProgress, or nil.
+func progressWriter(ctx context.Context) io.WriteCloser {
+	report, _ := ctx.Value(progressKey{}).(func(string))
+	if report == nil {
+		return nil
+	}
+	r, w := io.Pipe()
+	go func() {
+		scanner := bufio.NewScanner(r)
+		// git redraws its progress with carriage returns.
+		scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			for i, b := range data {
+				if b == '\r' || b == '\n' {
+					return i + 1, data[:i], nil
+				}
+			}
+			if atEOF && len(data) > 0 {
+				return len(data), data, nil
+			}
+			return 0, nil, nil
+		})
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				report(line)
+			}
+		}
+		r.Close()
+	}()
+	return w
+}
+
+// startDashboard switches the terminal to the dashboard. It needs a terminal
+// and stty. Quitting the dashboard calls quit.
+func startDashboard(repos []Repository, quit context.CancelFunc) (*dashboard, error) {
+	if stat, err := os.Stdin.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return nil, fmt.Errorf("--tui needs an interactive terminal")
+	}
+	mode, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("--tui needs stty: %w", err)
+	}
+	if _, err := stty("-icanon", "-echo", "-isig", "min", "1"); err != nil {
+		return nil, fmt.Errorf("--tui needs stty: %w", err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		stty(mode)
+		return nil, err
+	}
+	d := &dashboard{
+		term:     os.Stdout,
+		pipe:     w,
+		sttyMode: mode,
+		quit:     quit,
+		start:    time.Now(),
+		total:    len(repos),
+		stop:     make(chan struct{}),
+	}
+	d.cond = sync.NewCond(&d.mu)
+	d.resize()
+	d.resized = watchResize(d.resize)
+	os.Stdout = w
+	fmt.Fprint(d.term, "\x1b[?1049h\x1b[?25l")
+
+	d.stoppedWg.Add(2)
+	go d.readLog(r)
+	go d.redraw()
+	go d.readKeys()
+	return d, nil
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// close restores the terminal and lets the program print to it again.
+func (d *dashboard) close() {
+	if d == nil {
+		return
+	}
+	close(d.stop)
+	d.resized()
+	os.Stdout = d.term
+	d.pipe.Close()
+	d.stoppedWg.Wait()
+	d.restoreTerminal()
+	d.mu.Lock()
+	d.paused = false
+	d.mu.Unlock()
+	d.cond.Broadcast()
+}
+
+func (d *dashboard) restoreTerminal() {
+	fmt.Fprint(d.term, "\x1b[?25h\x1b[?1049l")
+	stty(d.sttyMode)
+}
+
+func (d *dashboard) readLog(r *os.File) {
+	defer d.stoppedWg.Done()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		d.mu.Lock()
+		d.log = append(d.log, scanner.Text())
+		if len(d.log) > dashboardLogLines {
+			d.log = d.log[1:]
+		}
+		d.mu.Unlock()
+	}
+	r.Close()
+}
+
+func (d *dashboard) readKeys() {
+	in := bufio.NewReader(os.Stdin)
+	for {
+		b, err := in.ReadByte()
+		if err != nil {
+			return
+		}
+		d.mu.Lock()
+		switch b {
+		case 'p', ' ':
+			d.paused = !d.paused
+			d.cond.Broadcast()
+		case 'k':
+			d.moveSelection(-1)
+		case 'j':
+			d.moveSelection(1)
+		case 0x1b:
+			// Arrow keys arrive as ESC [ A and ESC [ B.
+			if next, _ := in.Peek(2); len(next) == 2 && next[0] == '[' {
+				in.Discard(2)
+				switch next[1] {
+				case 'A':
+					d.moveSelection(-1)
+				case 'B':
+					d.moveSelection(1)
+				}
+			}
+		case 's':
+			if d.selected < len(d.workers) {
+				w := d.workers[d.selected]
+				w.skipped = true
+				w.cancel()
+			}
+		case 'q', 3:
+			if d.quitting {
+				// Pressed again while the clones are stopping, like a second
+				// interrupt.
+				d.mu.Unlock()
+				killRunningCommands()
+				d.restoreTerminal()
+				fmt.Fprintln(d.term, "Interrupted, continue with --resume")
+				os.Exit(130)
+			}
+			d.quitting = true
+			d.quit()
+		}
+		d.mu.Unlock()
+	}
+}
+
+func (d *dashboard) moveSelection(delta int) {
+	d.selected += delta
+	if d.selected >= len(d.workers) {
+		d.selected = len(d.workers) - 1
+	}
+	if d.selected < 0 {
+		d.selected = 0
+	}
+}
+
+// waitIfPaused blocks while the dashboard is paused, so no new repositories
+// are started. Running ones carry on.
+func (d *dashboard) waitIfPaused() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	for d.paused {
+		d.cond.Wait()
+	}
+	d.mu.Unlock()
+}
+
+// begin shows repo as being worked on and returns a context to run it with,
+// which is canceled when the user skips it.
+func (d *dashboard) begin(ctx context.Context, repo Repository) context.Context {
+	if d == nil {
+		return ctx
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	w := &dashboardWorker{repo: repo.FullName, started: time.Now(), cancel: cancel}
+	d.mu.Lock()
+	d.workers = append(d.workers, w)
+	d.mu.Unlock()
+	return withProgress(ctx, func(line string) {
+		d.mu.Lock()
+		w.progress = line
+		d.mu.Unlock()
+	})
+}
+
+// finish removes repo from the workers and records its result. It reports
+// whether the user skipped it.
+func (d *dashboard) finish(res Result) bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	skipped := false
+	for i, w := range d.workers {
+		if w.repo == res.RepoName {
+			skipped = w.skipped
+			w.cancel()
+			d.workers = append(d.workers[:i], d.workers[i+1:]...)
+			break
+		}
+	}
+	d.moveSelection(0)
+	d.done++
+	switch {
+	case skipped:
+		d.failures = append(d.failures, res.RepoName+": skipped")
+	case res.Err != nil:
+		d.failed++
+		d.failures = append(d.failures, fmt.Sprintf("%s: %v", res.RepoName, res.Err))
+	case res.Action == "cloned" || res.Action == "bundled" || res.Action == "downloaded":
+		d.bytes += res.Size
+	}
+	if len(d.failures) > dashboardFailures {
+		d.failures = d.failures[1:]
+	}
+	return skipped
+}
+
+func (d *dashboard) redraw() {
+	defer d.stoppedWg.Done()
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+	for {
+		d.draw()
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resize reads the width of the terminal, at the start and when the terminal
+// is resized.
+func (d *dashboard) resize() {
+	width := 80
+	if size, err := stty("size"); err == nil {
+		if _, cols, ok := strings.Cut(size, " "); ok {
+			if n, err := strconv.Atoi(cols); err == nil && n > 0 {
+				width = n
+			}
+		}
+	}
+	d.mu.Lock()
+	d.width = width
+	d.mu.Unlock()
+}
+
+func (d *dashboard) draw() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		s := fmt.Sprintf(format, args...)
+		if r := []rune(s); len(r) > d.width {
+			s = string(r[:d.width])
+		}
+		b.WriteString(s + "\x1b[K\n")
+	}
+
+	elapsed := time.Since(d.start)
+	state := "running"
+	switch {
+	case d.quitting:
+		state = "STOPPING"
+	case d.paused || dispatchPause.isPaused():
+		state = "PAUSED"
+	}
+	line("cloneAllGitea  %s  %s elapsed", state, elapsed.Round(time.Second))
+	line("%d/%d done, %d active, %d queued, %d failed", d.done, d.total, len(d.workers), d.total-d.done-len(d.workers), d.failed)
+	line("Throughput: %.1f repositories/min, %.2f MB/s", float64(d.done)/elapsed.Minutes(), float64(d.bytes)/(1024*1024)/elapsed.Seconds())
+	line("")
+	line("Workers:")
+	for i, w := range d.workers {
+		marker := " "
+		if i == d.selected {
+			marker = ">"
+		}
+		line("%s %-40s %6s  %s", marker, w.repo, time.Since(w.started).Round(time.Second), w.progress)
+	}
+	line("")
+	line("Recent failures:")
+	for _, f := range d.failures {
+		line("  %s", f)
+	}
+	line("")
+	line("Log:")
+	for _, l := range d.log {
+		line("  %s", l)
+	}
+	line("")
+	line("p pause/resume  j/k or arrows select  s skip selected  q quit")
+	fmt.Fprint(d.term, "\x1b[H"+b.String()+"\x1b[J")
+}
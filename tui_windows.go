@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+// watchResize does nothing on Windows, which has no SIGWINCH; the dashboard
+// keeps the width it started with.
+func watchResize(resized func()) func() {
+	return func() {}
+}
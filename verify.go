@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deepVerifyTimeout bounds the verification of a single clone.
+const deepVerifyTimeout = time.Hour
+
+// deepVerifyDue reports whether the deep verification of the mirror is due,
+// interval after the last one recorded in the state.
+func deepVerifyDue(interval time.Duration) bool {
+	state, err := loadState()
+	if err != nil || state.LastDeepVerify == nil {
+		return true
+	}
+	return time.Since(*state.LastDeepVerify) >= interval
+}
+
+// deepVerify checks every clone below root for silent corruption: git fsck
+// reads and checks all objects, and the branches and tags of the clone are
+// compared with the hashes the server reports for them. It records when it
+// ran and returns the clones with problems.
+func deepVerify(root string, jobs int) ([]string, error) {
+	clones, err := findClones(root)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Deep verifying %d clones\n", len(clones))
+	started := time.Now()
+
+	problems := make(map[string][]string)
+	var mu sync.Mutex
+	lim := newLimiter(jobs)
+	var wg sync.WaitGroup
+	for _, name := range clones {
+		lim.acquire()
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			defer lim.release()
+			ctx, cancel := context.WithTimeout(context.Background(), deepVerifyTimeout)
+			defer cancel()
+			if found := verifyClone(ctx, name); len(found) > 0 {
+				mu.Lock()
+				problems[name] = found
+				mu.Unlock()
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	names := make([]string, 0, len(problems))
+	for name := range problems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, problem := range problems[name] {
+			fmt.Printf("Deep verify: %s: %s\n", name, problem)
+		}
+	}
+	fmt.Printf("Deep verify finished in %s: %d clones checked, %d with problems\n",
+		time.Since(started).Round(time.Second), len(clones), len(problems))
+
+	state, err := loadState()
+	if err != nil {
+		return names, err
+	}
+	state.LastDeepVerify = &started
+	return names, state.save()
+}
+
+// verifyClone runs the checks of deepVerify on the clone in dir.
+func verifyClone(ctx context.Context, dir string) []string {
+	var problems []string
+	out, err := commandCombinedOutput(ctx, gitCommand("-C", dir, "fsck", "--full", "--no-dangling", "--no-progress"))
+	if err != nil {
+		problems = append(problems, "fsck: "+firstLine(string(out)))
+	}
+
+	remote, err := gitOutput(ctx, dir, "ls-remote", "--heads", "--tags", remoteName)
+	if err != nil {
+		return append(problems, fmt.Sprintf("listing the refs of the server: %v", err))
+	}
+	local, err := gitOutput(ctx, dir, "for-each-ref", "--format=%(objectname) %(refname)", branchRefs(dir), "refs/tags/")
+	if err != nil {
+		return append(problems, fmt.Sprintf("listing the refs of the clone: %v", err))
+	}
+	localRefs := make(map[string]string)
+	hasBranches := false
+	for _, line := range strings.Split(local, "\n") {
+		if hash, ref, ok := strings.Cut(line, " "); ok {
+			localRefs[ref] = hash
+			hasBranches = hasBranches || !strings.HasPrefix(ref, "refs/tags/")
+		}
+	}
+	for _, line := range strings.Split(remote, "\n") {
+		hash, ref, ok := strings.Cut(line, "\t")
+		if !ok || strings.HasSuffix(ref, "^{}") {
+			continue
+		}
+		name := ref
+		if branch := strings.TrimPrefix(ref, "refs/heads/"); branch != ref {
+			// A tags-only clone has no branches, and a branch named HEAD
+			// cannot be told from the remote's HEAD.
+			if !hasBranches || branch == "HEAD" {
+				continue
+			}
+			name = branchRefs(dir) + branch
+		}
+		switch localHash, ok := localRefs[name]; {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s is missing", ref))
+		case localHash != hash:
+			problems = append(problems, fmt.Sprintf("%s is %.12s, but %.12s on the server", ref, localHash, hash))
+		}
+	}
+	return problems
+}
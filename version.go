@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	versionEndpoint     = "/api/v1/version"
+	apiSettingsEndpoint = "/api/v1/settings/api"
+	minGiteaVersion     = "1.12.0"
+	// activityFeedsVersion added the activity feeds of repositories.
+	activityFeedsVersion = "1.20.0"
+)
+
+// serverInfo describes the Gitea instance so callers can adapt to what it
+// supports.
+type serverInfo struct {
+	Version     string
+	version     [3]int
+	MaxPageSize int
+}
+
+// atLeast reports whether the server version is at least v.
+func (s serverInfo) atLeast(v string) bool {
+	want, err := parseVersion(v)
+	if err != nil {
+		return false
+	}
+	for i := range want {
+		if s.version[i] != want[i] {
+			return s.version[i] > want[i]
+		}
+	}
+	return true
+}
+
+// parseVersion accepts Gitea versions such as "1.21.4", "1.22.0+dev-12-gabc"
+// and Forgejo versions such as "7.0.0+gitea-1.21.11", for which the embedded
+// Gitea version is used.
+func parseVersion(v string) ([3]int, error) {
+	var parsed [3]int
+	if i := strings.Index(v, "+gitea-"); i >= 0 {
+		v = v[i+len("+gitea-"):]
+	}
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "+-"); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) < 2 {
+		return parsed, fmt.Errorf("unrecognized version %q", v)
+	}
+	for i := 0; i < len(parts) && i < 3; i++ {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return parsed, fmt.Errorf("unrecognized version %q", v)
+		}
+		parsed[i] = n
+	}
+	return parsed, nil
+}
+
+func fetchServerInfo(giteaHost, giteaAccessToken string) (serverInfo, error) {
+	var info serverInfo
+	var version struct {
+		Version string `json:"version"`
+	}
+	if err := getJSON(giteaHost+versionEndpoint, giteaAccessToken, &version); isNotFound(err) {
+		return info, fmt.Errorf("%s has no Gitea API, check GITEA_HOST: %w", giteaHost, err)
+	} else if err != nil {
+		return info, fmt.Errorf("failed to query server version: %w", err)
+	}
+
+	parsed, err := parseVersion(version.Version)
+	if err != nil {
+		return info, err
+	}
+	info.Version = version.Version
+	info.version = parsed
+
+	if !info.atLeast(minGiteaVersion) {
+		return info, fmt.Errorf("gitea %s is not supported, at least %s is required", info.Version, minGiteaVersion)
+	}
+
+	var settings struct {
+		MaxResponseItems int `json:"max_response_items"`
+	}
+	if err := getJSON(giteaHost+apiSettingsEndpoint, giteaAccessToken, &settings); err == nil {
+		info.MaxPageSize = settings.MaxResponseItems
+	}
+
+	return info, nil
+}
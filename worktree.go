@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	layoutClone    = "clone"
+	layoutWorktree = "worktree"
+	// storeDir holds the bare repositories of --layout worktree.
+	storeDir = ".store"
+)
+
+// storePath is the bare repository backing the worktree of repo.
+func storePath(repo Repository) string {
+	return filepath.Join(storeDir, sanitizePath(repo.FullName)+".git")
+}
+
+// gitCloneWorktree clones repo as a bare repository below .store, unless it
+// is there already, and adds its default branch as a linked worktree at
+// repoDir(repo). More branches can later be checked out next to it with git
+// worktree add.
+func gitCloneWorktree(ctx context.Context, cloneURL string, repo Repository, extraArgs ...string) error {
+	store := storePath(repo)
+	if _, err := os.Stat(store); os.IsNotExist(err) {
+		if err := gitClone(ctx, cloneURL, store, append([]string{"--bare"}, extraArgs...)...); err != nil {
+			return err
+		}
+		// Bare clones copy the server's branches as local ones and do not
+		// fetch them afterwards; track them as remote branches instead, as
+		// in a normal clone.
+		if _, err := gitOutput(ctx, store, "config", "remote."+remoteName+".fetch", "+refs/heads/*:refs/remotes/"+remoteName+"/*"); err != nil {
+			return err
+		}
+		args := append(append([]string{"fetch", "--quiet"}, gitTransportArgs()...), remoteName)
+		_, err := gitOutput(ctx, store, args...)
+		audit("fetch", store, "", err)
+		if err != nil {
+			return err
+		}
+		heads, err := gitOutput(ctx, store, "for-each-ref", "--format=%(refname)", "refs/heads/")
+		if err != nil {
+			return err
+		}
+		for _, ref := range strings.Fields(heads) {
+			if _, err := gitOutput(ctx, store, "update-ref", "-d", ref); err != nil {
+				return err
+			}
+		}
+	} else if err := renameRemote(ctx, store); err != nil {
+		return err
+	}
+
+	worktree, err := filepath.Abs(repoDir(repo))
+	if err != nil {
+		return err
+	}
+	if _, err := gitOutput(ctx, store, "worktree", "prune"); err != nil {
+		return err
+	}
+	if _, err := gitOutput(ctx, store, "worktree", "add", "--quiet", "-B", repo.DefaultBranch, worktree, remoteName+"/"+repo.DefaultBranch); err != nil {
+		return fmt.Errorf("adding worktree: %w", err)
+	}
+	return nil
+}